@@ -0,0 +1,250 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// IssueLevel the level of an analysis issue
+type IssueLevel string
+
+// issue levels
+const (
+	IssueError   IssueLevel = "error"
+	IssueWarning IssueLevel = "warning"
+	IssueInfo    IssueLevel = "info"
+)
+
+// Issue a finding of static analysis
+type Issue struct {
+	Namespace string     `json:"namespace"`
+	Level     IssueLevel `json:"level"`
+	Code      string     `json:"code"`
+	Statuses  []string   `json:"statuses,omitempty"`
+	Message   string     `json:"message"`
+}
+
+// NamespaceGraph statuses and events of a namespace
+type NamespaceGraph struct {
+	Namespace string `json:"namespace"`
+	// Statuses all statuses in order, without AnyStatus
+	Statuses []string `json:"statuses"`
+	// Events all events in order
+	Events []string `json:"events"`
+	// Initials statuses which are not targets of other statuses
+	Initials []string `json:"initials"`
+	// Terminals statuses without transactions
+	Terminals []string `json:"terminals"`
+	// Transactions ordered by current status and event, including wildcard ones
+	Transactions []*Transaction `json:"transactions"`
+	// Wildcards transactions from AnyStatus
+	Wildcards []*Transaction `json:"wildcards,omitempty"`
+}
+
+// NewNamespaceGraphs group transactions by namespace into graphs ordered by namespace
+func NewNamespaceGraphs(ts []*Transaction) []*NamespaceGraph {
+	spaces := make(map[string][]*Transaction)
+	for _, t := range ts {
+		spaces[t.Namespace] = append(spaces[t.Namespace], t)
+	}
+
+	graphs := make([]*NamespaceGraph, 0, len(spaces))
+	for namespace, spaceTrans := range spaces {
+		graphs = append(graphs, NewNamespaceGraph(namespace, spaceTrans))
+	}
+	sort.Slice(graphs, func(i, j int) bool {
+		return graphs[i].Namespace < graphs[j].Namespace
+	})
+	return graphs
+}
+
+// NewNamespaceGraph new a graph of namespace's transactions, other namespaces are ignored
+func NewNamespaceGraph(namespace string, ts []*Transaction) *NamespaceGraph {
+	g := &NamespaceGraph{Namespace: namespace}
+	statuses, events := make(map[string]bool), make(map[string]bool)
+	for _, t := range ts {
+		if t.Namespace != namespace {
+			continue
+		}
+		g.Transactions = append(g.Transactions, t)
+		if t.CurrentStatus == AnyStatus {
+			g.Wildcards = append(g.Wildcards, t)
+		} else {
+			statuses[t.CurrentStatus] = true
+		}
+		// a self transition targets the status it fires in
+		if !t.IsSelf() {
+			statuses[t.TargetStatus] = true
+		}
+		events[t.Event] = true
+	}
+	sortTransactions(g.Transactions)
+	sortTransactions(g.Wildcards)
+	g.Statuses, g.Events = setKeys(statuses), setKeys(events)
+
+	sources, targeted := make(map[string]bool), make(map[string]bool)
+	for _, t := range g.Expand() {
+		sources[t.CurrentStatus] = true
+		if t.CurrentStatus != t.TargetStatus {
+			targeted[t.TargetStatus] = true
+		}
+	}
+	for _, status := range g.Statuses {
+		if !targeted[status] {
+			g.Initials = append(g.Initials, status)
+		}
+		if !sources[status] {
+			g.Terminals = append(g.Terminals, status)
+		}
+	}
+	return g
+}
+
+// Expand get the transactions which can be fired in every status,
+// wildcard ones are expanded to statuses without a transaction of the same event
+func (p *NamespaceGraph) Expand() []*Transaction {
+	if len(p.Wildcards) == 0 {
+		return p.Transactions
+	}
+
+	exact := make(map[string]bool, len(p.Transactions))
+	var ts []*Transaction
+	for _, t := range p.Transactions {
+		if t.CurrentStatus != AnyStatus {
+			exact[t.CurrentStatus+"::"+t.Event] = true
+			ts = append(ts, t)
+		}
+	}
+	for _, status := range p.Statuses {
+		for _, w := range p.Wildcards {
+			if exact[status+"::"+w.Event] {
+				continue
+			}
+			t := *w
+			t.CurrentStatus = status
+			if t.IsSelf() {
+				t.TargetStatus = status
+			}
+			ts = append(ts, &t)
+		}
+	}
+	sortTransactions(ts)
+	return ts
+}
+
+// Analyze analyze transactions by namespace
+func Analyze(ts []*Transaction) []Issue {
+	var issues []Issue
+	for _, g := range NewNamespaceGraphs(ts) {
+		issues = append(issues, g.Analyze()...)
+	}
+	return issues
+}
+
+// Analyze find statuses which can not be reached from initial statuses,
+// or can not reach any terminal status
+func (p *NamespaceGraph) Analyze() []Issue {
+	var issues []Issue
+	issue := func(level IssueLevel, code string, statuses []string, format string, args ...interface{}) {
+		issues = append(issues, Issue{
+			Namespace: p.Namespace,
+			Level:     level,
+			Code:      code,
+			Statuses:  statuses,
+			Message:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	if len(p.Wildcards) > 0 {
+		var events []string
+		for _, t := range p.Wildcards {
+			events = append(events, t.Event)
+		}
+		issue(IssueInfo, "wildcard", nil, "events accepted from any status: %s", strings.Join(events, ", "))
+	}
+
+	forward, backward := make(map[string][]string), make(map[string][]string)
+	for _, t := range p.Expand() {
+		forward[t.CurrentStatus] = append(forward[t.CurrentStatus], t.TargetStatus)
+		backward[t.TargetStatus] = append(backward[t.TargetStatus], t.CurrentStatus)
+	}
+
+	switch len(p.Initials) {
+	case 0:
+		issue(IssueInfo, "no-initial", nil, "every status is a target, the initial status is not defined")
+	case 1:
+	default:
+		issue(IssueInfo, "multiple-initials", p.Initials,
+			"%d statuses are not targets: %s", len(p.Initials), strings.Join(p.Initials, ", "))
+	}
+	if len(p.Initials) > 0 {
+		if unreachable := p.missing(reach(p.Initials, forward)); len(unreachable) > 0 {
+			issue(IssueWarning, "unreachable", unreachable,
+				"statuses can not be reached from initial statuses: %s", strings.Join(unreachable, ", "))
+		}
+	}
+
+	if len(p.Terminals) == 0 {
+		issue(IssueInfo, "no-terminal", nil, "every status has transactions, the machine never ends")
+	} else if traps := p.missing(reach(p.Terminals, backward)); len(traps) > 0 {
+		issue(IssueWarning, "trap", traps,
+			"statuses can not reach any terminal status: %s", strings.Join(traps, ", "))
+	}
+	return issues
+}
+
+func (p *NamespaceGraph) missing(reached map[string]bool) []string {
+	var statuses []string
+	for _, status := range p.Statuses {
+		if !reached[status] {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+func reach(from []string, edges map[string][]string) map[string]bool {
+	reached := make(map[string]bool)
+	queue := append([]string(nil), from...)
+	for _, status := range from {
+		reached[status] = true
+	}
+	for len(queue) > 0 {
+		status := queue[0]
+		queue = queue[1:]
+		for _, next := range edges[status] {
+			if !reached[next] {
+				reached[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return reached
+}
+
+func setKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
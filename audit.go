@@ -0,0 +1,259 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry a transition in the audit log, chained to the previous entry by PrevHash
+type AuditEntry struct {
+	// Seq the sequence of entries from 1
+	Seq       int64     `json:"seq"`
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	Instance  string    `json:"instance,omitempty"`
+	Event     string    `json:"event"`
+	// Action undo or redo of the event's transition, empty when it is fired
+	Action        string `json:"action,omitempty"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	PayloadDigest string `json:"payload_digest,omitempty"`
+	// PrevHash the Hash of the previous entry, empty for the first one
+	PrevHash string `json:"prev_hash"`
+	// Hash the sha256 of the entry in json without Hash
+	Hash string `json:"hash,omitempty"`
+}
+
+// actions of audit entries and transition events besides firing
+const (
+	ActionUndo = "undo"
+	ActionRedo = "redo"
+)
+
+// digest get the sha256 of the entry in json without Hash
+func (p *AuditEntry) digest() (string, error) {
+	copied := *p
+	copied.Hash = ""
+	data, err := json.Marshal(&copied)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditSink an append-only audit log file of json lines, it is safe for concurrent use
+type AuditSink struct {
+	file     *os.File
+	syncs    bool
+	seq      int64
+	lastHash string
+
+	sync.Mutex
+}
+
+// AuditOption audit sink option function
+type AuditOption func(*AuditSink)
+
+// AuditSync sync the file after every entry
+func AuditSync(enabled bool) AuditOption {
+	return func(s *AuditSink) {
+		s.syncs = enabled
+	}
+}
+
+// OpenAuditLog open or create an audit log file, new entries are chained to its last entry
+func OpenAuditLog(filepath string, opts ...AuditOption) (*AuditSink, error) {
+	file, err := os.OpenFile(filepath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &AuditSink{file: file}
+	for _, o := range opts {
+		o(s)
+	}
+
+	last, err := lastAuditEntry(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if last != nil {
+		s.seq, s.lastHash = last.Seq, last.Hash
+	}
+	return s, nil
+}
+
+func lastAuditEntry(r io.Reader) (*AuditEntry, error) {
+	var last []byte
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+	entry := &AuditEntry{}
+	if err := json.Unmarshal(last, entry); err != nil {
+		return nil, fmt.Errorf("%w: last entry: %v", ErrAuditBroken, err)
+	}
+	return entry, nil
+}
+
+// Write append an entry, its Seq, PrevHash and Hash are set by the sink
+func (p *AuditSink) Write(e *AuditEntry) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.file == nil {
+		return os.ErrClosed
+	}
+
+	e.Seq, e.PrevHash, e.Time = p.seq+1, p.lastHash, e.Time.UTC()
+	hash, err := e.digest()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = p.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if p.syncs {
+		if err = p.file.Sync(); err != nil {
+			return err
+		}
+	}
+	p.seq, p.lastHash = e.Seq, e.Hash
+	return nil
+}
+
+// Close close the file
+func (p *AuditSink) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+// AuditProblem a problem found in an audit log
+type AuditProblem struct {
+	Line int    `json:"line"`
+	Seq  int64  `json:"seq,omitempty"`
+	Code string `json:"code"`
+	// Message describe the problem
+	Message string `json:"message"`
+}
+
+// AuditReport the result of verifying an audit log
+type AuditReport struct {
+	Entries  int            `json:"entries"`
+	Problems []AuditProblem `json:"problems,omitempty"`
+}
+
+// Valid judge the log has no problems
+func (p *AuditReport) Valid() bool {
+	return len(p.Problems) == 0
+}
+
+// VerifyAuditLogFile verify an audit log file
+func VerifyAuditLogFile(filepath string) (*AuditReport, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return VerifyAuditLog(file)
+}
+
+// VerifyAuditLog check every entry of an audit log, problems are reported by code:
+// malformed for lines which are not entries, edited for entries whose hash does not match,
+// gap for missing sequences, reordered for sequences which go back,
+// and chain for entries which are not chained to the previous one.
+// The error is only returned if the log can not be read.
+func VerifyAuditLog(r io.Reader) (*AuditReport, error) {
+	report := &AuditReport{}
+	problem := func(line int, seq int64, code, format string, args ...interface{}) {
+		report.Problems = append(report.Problems, AuditProblem{
+			Line: line, Seq: seq, Code: code, Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	var prev *AuditEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		e := &AuditEntry{}
+		if err := json.Unmarshal(data, e); err != nil {
+			problem(line, 0, "malformed", "not an audit entry: %v", err)
+			continue
+		}
+		report.Entries++
+
+		if hash, err := e.digest(); err != nil || hash != e.Hash {
+			problem(line, e.Seq, "edited", "hash does not match the entry")
+		}
+
+		var prevSeq int64
+		var prevHash string
+		if prev != nil {
+			prevSeq, prevHash = prev.Seq, prev.Hash
+		}
+		switch {
+		case e.Seq <= prevSeq:
+			problem(line, e.Seq, "reordered", "sequence %d after %d", e.Seq, prevSeq)
+		case e.Seq == prevSeq+2:
+			problem(line, e.Seq, "gap", "sequence %d is missing", prevSeq+1)
+		case e.Seq > prevSeq+2:
+			problem(line, e.Seq, "gap", "sequences %d to %d are missing", prevSeq+1, e.Seq-1)
+		case e.PrevHash != prevHash:
+			problem(line, e.Seq, "chain", "previous hash does not match the entry before")
+		}
+		prev = e
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,282 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readAuditLog read the entries of a verified audit log
+func readAuditLog(t *testing.T, file string) []*AuditEntry {
+	t.Helper()
+	report, err := VerifyAuditLogFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("audit log is broken: %+v", report.Problems)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []*AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := &AuditEntry{}
+		if err = json.Unmarshal(scanner.Bytes(), e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestFireInstanceAuditAfterPut(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"})
+
+	file := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := OpenAuditLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	store := NewMemoryStore()
+	inst := &Instance{ID: "o1", Namespace: "order", Status: "created"}
+	if err = store.Put(inst); err != nil {
+		t.Fatal(err)
+	}
+	stale := *inst
+	stale.Revision = 0
+	_, _, err = FireInstance(context.Background(), repo, store, &stale, "pay", nil,
+		MachineAudit[string, string](sink))
+	if !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("FireInstance(stale) = %v, want %v", err, ErrRevisionConflict)
+	}
+	if entries := readAuditLog(t, file); len(entries) != 0 {
+		t.Fatalf("audited a transition which was not put: %+v", entries[0])
+	}
+
+	m, tr, err := FireInstance(context.Background(), repo, store, inst, "pay", map[string]interface{}{"amount": 1},
+		MachineAudit[string, string](sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.TargetStatus != "paid" || m.Current() != "paid" || inst.Status != "paid" || inst.Revision != 2 {
+		t.Fatalf("FireInstance = %+v, %s, instance %+v", tr, m.Current(), inst)
+	}
+	entries := readAuditLog(t, file)
+	if len(entries) != 1 || entries[0].Instance != "o1" || entries[0].From != "created" || entries[0].To != "paid" ||
+		entries[0].PayloadDigest == "" {
+		t.Fatalf("audit log = %+v", entries)
+	}
+}
+
+func TestMachineActionUndoRedo(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := OpenAuditLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	m, err := NewMachine(newParityChecker(t), "parity", "even", MachineID[string, string]("p1"),
+		MachineAudit[string, string](sink), MachineHistory[string, string](10, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Fire("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Redo(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readAuditLog(t, file)
+	want := []struct{ action, from, to string }{{"", "even", "odd"}, {ActionUndo, "odd", "even"}, {ActionRedo, "even", "odd"}}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i, w := range want {
+		if e := entries[i]; e.Action != w.action || e.From != w.from || e.To != w.to || e.Event != "1" {
+			t.Errorf("entry %d = %+v, want %+v", i, e, w)
+		}
+	}
+
+	sink.Close()
+	if err = m.Undo(); err == nil || m.Current() != "odd" {
+		t.Fatalf("Undo with a closed sink = %v at %s, want an error without moving", err, m.Current())
+	}
+}
+
+// tamperAuditLog write an audit log of four entries, change its lines by fn and verify it
+func tamperAuditLog(t *testing.T, fn func(lines [][]byte) [][]byte) *AuditReport {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := OpenAuditLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []struct{ from, to string }{{"even", "odd"}, {"odd", "even"}, {"even", "odd"}, {"odd", "even"}} {
+		if err = sink.Write(&AuditEntry{Namespace: "parity", Event: "1", From: e.from, To: e.to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if err = os.WriteFile(file, append(bytes.Join(fn(lines), []byte("\n")), '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyAuditLogFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// rewriteAuditEntry change the entry of a line, its hash is recomputed if rehash
+func rewriteAuditEntry(t *testing.T, line []byte, fn func(e *AuditEntry), rehash bool) []byte {
+	t.Helper()
+	e := &AuditEntry{}
+	if err := json.Unmarshal(line, e); err != nil {
+		t.Fatal(err)
+	}
+	fn(e)
+	if rehash {
+		hash, err := e.digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Hash = hash
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifyAuditLogTampered(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(t *testing.T, lines [][]byte) [][]byte
+		entries int
+		want    []AuditProblem
+	}{
+		{
+			name:    "intact",
+			tamper:  func(t *testing.T, lines [][]byte) [][]byte { return lines },
+			entries: 4,
+		},
+		{
+			name: "edited",
+			tamper: func(t *testing.T, lines [][]byte) [][]byte {
+				lines[1] = rewriteAuditEntry(t, lines[1], func(e *AuditEntry) { e.To = "odd" }, false)
+				return lines
+			},
+			entries: 4,
+			want:    []AuditProblem{{Line: 2, Seq: 2, Code: "edited"}},
+		},
+		{
+			name: "edited and rehashed",
+			tamper: func(t *testing.T, lines [][]byte) [][]byte {
+				lines[1] = rewriteAuditEntry(t, lines[1], func(e *AuditEntry) { e.To = "odd" }, true)
+				return lines
+			},
+			entries: 4,
+			want:    []AuditProblem{{Line: 3, Seq: 3, Code: "chain"}},
+		},
+		{
+			name: "removed",
+			tamper: func(t *testing.T, lines [][]byte) [][]byte {
+				return append(lines[:1:1], lines[2:]...)
+			},
+			entries: 3,
+			want:    []AuditProblem{{Line: 2, Seq: 3, Code: "gap"}},
+		},
+		{
+			name: "removed last",
+			tamper: func(t *testing.T, lines [][]byte) [][]byte {
+				// truncating the tail keeps the chain, only an external record of the last hash tells
+				return lines[:3]
+			},
+			entries: 3,
+		},
+		{
+			name: "reordered",
+			tamper: func(t *testing.T, lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			entries: 4,
+			want: []AuditProblem{
+				{Line: 2, Seq: 3, Code: "gap"},
+				{Line: 3, Seq: 2, Code: "reordered"},
+				{Line: 4, Seq: 4, Code: "gap"},
+			},
+		},
+		{
+			name: "malformed",
+			tamper: func(t *testing.T, lines [][]byte) [][]byte {
+				lines[3] = []byte("{")
+				return lines
+			},
+			entries: 3,
+			want:    []AuditProblem{{Line: 4, Code: "malformed"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := tamperAuditLog(t, func(lines [][]byte) [][]byte { return test.tamper(t, lines) })
+			if report.Entries != test.entries {
+				t.Errorf("entries = %d, want %d", report.Entries, test.entries)
+			}
+			var got []AuditProblem
+			for _, p := range report.Problems {
+				got = append(got, AuditProblem{Line: p.Line, Seq: p.Seq, Code: p.Code})
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("problems = %+v, want %+v", report.Problems, test.want)
+			}
+			if report.Valid() != (len(test.want) == 0) {
+				t.Fatalf("Valid() = %t with problems %+v", report.Valid(), report.Problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSubscriptionBuffer default number of events buffered per subscription
+const DefaultSubscriptionBuffer = 64
+
+// TransitionEvent a transition fired by a machine, statuses and events are formatted with fmt.Sprint
+type TransitionEvent struct {
+	Namespace string `json:"namespace"`
+	Instance  string `json:"instance,omitempty"`
+	Event     string `json:"event"`
+	// Action undo or redo of the event's transition, empty when it is fired
+	Action string    `json:"action,omitempty"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Time   time.Time `json:"time"`
+	// Revision and Data the stored instance after the transition, they are set only by FireInstance
+	Revision int64 `json:"revision,omitempty"`
+	Data     Data  `json:"data,omitempty"`
+}
+
+// SubscriptionFilter the events a subscription gets, an empty list matches everything
+type SubscriptionFilter struct {
+	Namespaces []string
+	Instances  []string
+	Events     []string
+	// Targets target statuses
+	Targets []string
+}
+
+func (p *SubscriptionFilter) match(e *TransitionEvent) bool {
+	return matchAny(p.Namespaces, e.Namespace) && matchAny(p.Instances, e.Instance) &&
+		matchAny(p.Events, e.Event) && matchAny(p.Targets, e.To)
+}
+
+func matchAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SlowPolicy what to do when the buffer of a subscription is full
+type SlowPolicy int
+
+// slow subscriber policies
+const (
+	// SlowDrop drop the new event and count it
+	SlowDrop SlowPolicy = iota
+	// SlowBlock wait until the subscriber takes an event, which blocks the firing machine
+	SlowBlock
+	// SlowDisconnect unsubscribe with ErrSlowSubscriber
+	SlowDisconnect
+)
+
+// SubscribeOption subscription option function
+type SubscribeOption func(*Subscription)
+
+// SubscribeBuffer set the number of buffered events
+func SubscribeBuffer(size int) SubscribeOption {
+	return func(s *Subscription) {
+		s.buffer = size
+	}
+}
+
+// SubscribePolicy set the policy when the buffer is full, SlowDrop by default
+func SubscribePolicy(policy SlowPolicy) SubscribeOption {
+	return func(s *Subscription) {
+		s.policy = policy
+	}
+}
+
+// Subscription the events of a filter
+type Subscription struct {
+	// dropped is first to be 64-bit aligned for atomic access on 32-bit platforms
+	dropped uint64
+
+	// C the events, it is closed when unsubscribed, events sent before can still be received
+	C <-chan *TransitionEvent
+
+	ch     chan *TransitionEvent
+	filter SubscriptionFilter
+	buffer int
+	policy SlowPolicy
+	broker *Broker
+	err    error
+	// done closed when unsubscribing, to stop blocked deliveries
+	done     chan struct{}
+	doneOnce sync.Once
+	closed   bool
+
+	sync.Mutex
+}
+
+// Dropped get the number of events dropped by SlowDrop
+func (p *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// Err get why the subscription ends: nil if it is unsubscribed or still active,
+// ErrSlowSubscriber if it is disconnected and ErrBrokerClosed if the broker is closed
+func (p *Subscription) Err() error {
+	p.Lock()
+	defer p.Unlock()
+	return p.err
+}
+
+// Unsubscribe stop the subscription, no event is delivered after it returns
+func (p *Subscription) Unsubscribe() {
+	p.broker.remove(p)
+	p.close(nil)
+}
+
+// close close the channel with the reason, only the first one is kept
+func (p *Subscription) close(err error) {
+	p.doneOnce.Do(func() {
+		close(p.done)
+	})
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed, p.err = true, err
+	close(p.ch)
+}
+
+// deliver send the event by the policy, it reports false if the subscriber must be disconnected
+func (p *Subscription) deliver(e *TransitionEvent) bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return true
+	}
+	select {
+	case p.ch <- e:
+		return true
+	default:
+	}
+	switch p.policy {
+	case SlowBlock:
+		select {
+		case p.ch <- e:
+		case <-p.done:
+		}
+	case SlowDisconnect:
+		return false
+	default:
+		atomic.AddUint64(&p.dropped, 1)
+	}
+	return true
+}
+
+// Broker deliver transition events published by machines to subscriptions, it is safe for concurrent use
+type Broker struct {
+	subscriptions map[*Subscription]bool
+	closed        bool
+
+	sync.RWMutex
+}
+
+// NewBroker new a broker without subscriptions
+func NewBroker() *Broker {
+	return &Broker{subscriptions: make(map[*Subscription]bool)}
+}
+
+// Subscribe get a subscription of the events matching the filter
+func (p *Broker) Subscribe(filter SubscriptionFilter, opts ...SubscribeOption) *Subscription {
+	s := &Subscription{filter: filter, buffer: DefaultSubscriptionBuffer, broker: p, done: make(chan struct{})}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.buffer < 0 {
+		s.buffer = 0
+	}
+	s.ch = make(chan *TransitionEvent, s.buffer)
+	s.C = s.ch
+
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		s.close(ErrBrokerClosed)
+		return s
+	}
+	p.subscriptions[s] = true
+	return s
+}
+
+// SubscribeFunc call fn with the events matching the filter in a goroutine of the subscription,
+// fn is not called after Unsubscribe returns, except the call running at that time
+func (p *Broker) SubscribeFunc(filter SubscriptionFilter, fn func(e *TransitionEvent), opts ...SubscribeOption) *Subscription {
+	s := p.Subscribe(filter, opts...)
+	go func() {
+		for e := range s.C {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			fn(e)
+		}
+	}()
+	return s
+}
+
+// Publish deliver the event to the matching subscriptions by their policies
+func (p *Broker) Publish(e *TransitionEvent) {
+	p.RLock()
+	var matched []*Subscription
+	for s := range p.subscriptions {
+		if s.filter.match(e) {
+			matched = append(matched, s)
+		}
+	}
+	p.RUnlock()
+
+	for _, s := range matched {
+		if !s.deliver(e) {
+			p.remove(s)
+			s.close(ErrSlowSubscriber)
+		}
+	}
+}
+
+// Close unsubscribe all subscriptions with ErrBrokerClosed, later subscriptions are closed at once
+func (p *Broker) Close() {
+	p.Lock()
+	p.closed = true
+	subscriptions := p.subscriptions
+	p.subscriptions = make(map[*Subscription]bool)
+	p.Unlock()
+
+	for s := range subscriptions {
+		s.close(ErrBrokerClosed)
+	}
+}
+
+func (p *Broker) remove(s *Subscription) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.subscriptions, s)
+}
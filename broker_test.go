@@ -0,0 +1,105 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// received get the events buffered in the subscription
+func received(s *Subscription) []*TransitionEvent {
+	var events []*TransitionEvent
+	for {
+		select {
+		case e := <-s.C:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestFireInstancePublishAfterPut(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"})
+
+	broker := NewBroker()
+	defer broker.Close()
+	sub := broker.Subscribe(SubscriptionFilter{}, SubscribeBuffer(10))
+
+	store := NewMemoryStore()
+	inst := &Instance{ID: "o1", Namespace: "order", Status: "created"}
+	if err := store.Put(inst); err != nil {
+		t.Fatal(err)
+	}
+	stale := *inst
+	stale.Revision = 0
+	_, _, err := FireInstance(context.Background(), repo, store, &stale, "pay", nil, MachinePublish[string, string](broker))
+	if !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("FireInstance(stale) = %v, want %v", err, ErrRevisionConflict)
+	}
+	if events := received(sub); len(events) != 0 {
+		t.Fatalf("published a transition which was not put: %+v", events[0])
+	}
+
+	if _, _, err = FireInstance(context.Background(), repo, store, inst, "pay", nil,
+		MachinePublish[string, string](broker)); err != nil {
+		t.Fatal(err)
+	}
+	events := received(sub)
+	if len(events) != 1 || events[0].Instance != "o1" || events[0].From != "created" || events[0].To != "paid" ||
+		events[0].Revision != inst.Revision {
+		t.Fatalf("events = %+v", events)
+	}
+}
+
+func TestMachinePublishUndoRedo(t *testing.T) {
+	broker := NewBroker()
+	defer broker.Close()
+	sub := broker.Subscribe(SubscriptionFilter{}, SubscribeBuffer(10))
+
+	m, err := NewMachine(newParityChecker(t), "parity", "even", MachineID[string, string]("p1"),
+		MachinePublish[string, string](broker), MachineHistory[string, string](10, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Fire("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Redo(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := received(sub)
+	want := []struct{ action, from, to string }{{"", "even", "odd"}, {ActionUndo, "odd", "even"}, {ActionRedo, "even", "odd"}}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		if e := events[i]; e.Action != w.action || e.From != w.from || e.To != w.to || e.Event != "1" {
+			t.Errorf("event %d = %+v, want %+v", i, e, w)
+		}
+	}
+}
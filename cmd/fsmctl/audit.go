@@ -0,0 +1,67 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/iTrellis/fsm"
+)
+
+func runAudit(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "verify" {
+		return usageError(stderr, "audit")
+	}
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print the result as json")
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 1 {
+		return usageError(stderr, "audit")
+	}
+
+	report, err := fsm.VerifyAuditLogFile(flags.Arg(0))
+	if err != nil {
+		return fail(stderr, "audit", err)
+	}
+
+	if *asJSON {
+		if err := writeJSON(stdout, report); err != nil {
+			return fail(stderr, "audit", err)
+		}
+	} else {
+		printAuditReport(stdout, report)
+	}
+
+	if !report.Valid() {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func printAuditReport(w io.Writer, report *fsm.AuditReport) {
+	for _, p := range report.Problems {
+		fmt.Fprintf(w, "line %d: %s [%s]\n", p.Line, p.Message, p.Code)
+	}
+	if report.Valid() {
+		fmt.Fprintf(w, "ok: %d entries\n", report.Entries)
+		return
+	}
+	fmt.Fprintf(w, "broken: %d entries, %d problems\n", report.Entries, len(report.Problems))
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iTrellis/fsm"
+)
+
+// writeAuditLog write an audit log of two transitions and get its file and lines
+func writeAuditLog(t *testing.T) (string, []string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := fsm.OpenAuditLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []*fsm.AuditEntry{
+		{Namespace: "order", Instance: "o1", Event: "pay", From: "created", To: "paid"},
+		{Namespace: "order", Instance: "o1", Event: "ship", From: "paid", To: "shipped"},
+	} {
+		if err = sink.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestAuditVerify(t *testing.T) {
+	file, lines := writeAuditLog(t)
+	edited := filepath.Join(t.TempDir(), "edited.log")
+	tampered := strings.Replace(lines[0], `"to":"paid"`, `"to":"shipped"`, 1) + "\n" + lines[1] + "\n"
+	if err := os.WriteFile(edited, []byte(tampered), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+	}{
+		{args: []string{"audit", "verify", file}, code: exitOK, stdout: "ok: 2 entries\n"},
+		{args: []string{"audit", "verify", edited}, code: exitFailure,
+			stdout: "line 1: hash does not match the entry [edited]\nbroken: 2 entries, 1 problems\n"},
+		{args: []string{"audit", "verify", filepath.Join(t.TempDir(), "missing.log")}, code: exitFailure},
+		{args: []string{"audit", file}, code: exitUsage},
+		{args: []string{"audit", "verify"}, code: exitUsage},
+	}
+	for _, test := range tests {
+		code, stdout, _ := runFsmctl(t, test.args...)
+		if code != test.code {
+			t.Errorf("fsmctl %s = %d, want %d", strings.Join(test.args, " "), code, test.code)
+		}
+		if test.stdout != "" && stdout != test.stdout {
+			t.Errorf("fsmctl %s printed %q, want %q", strings.Join(test.args, " "), stdout, test.stdout)
+		}
+	}
+
+	code, stdout, _ := runFsmctl(t, "audit", "verify", "-json", edited)
+	if code != exitFailure {
+		t.Fatalf("audit verify -json = %d, want %d", code, exitFailure)
+	}
+	report := &fsm.AuditReport{}
+	if err := json.Unmarshal([]byte(stdout), report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Entries != 2 || len(report.Problems) != 1 || report.Problems[0].Code != "edited" ||
+		report.Problems[0].Line != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/iTrellis/fsm"
+)
+
+func runDiff(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print the result as json")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+		return usageError(stderr, "diff")
+	}
+
+	old, _, err := readDefinition(flags.Arg(0))
+	if err != nil {
+		return fail(stderr, "diff", err)
+	}
+	updated, _, err := readDefinition(flags.Arg(1))
+	if err != nil {
+		return fail(stderr, "diff", err)
+	}
+	d := fsm.Diff(old, updated)
+
+	if *asJSON {
+		if err := writeJSON(stdout, d); err != nil {
+			return fail(stderr, "diff", err)
+		}
+	} else {
+		printDiff(stdout, d)
+	}
+
+	if d.IsBreaking() {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func printDiff(w io.Writer, d *fsm.DefinitionDiff) {
+	for _, nd := range d.Namespaces {
+		switch {
+		case nd.Added:
+			fmt.Fprintf(w, "namespace %s (added)\n", nd.Namespace)
+		case nd.Removed:
+			fmt.Fprintf(w, "namespace %s (removed)\n", nd.Namespace)
+		default:
+			fmt.Fprintf(w, "namespace %s\n", nd.Namespace)
+		}
+		for _, status := range nd.AddedStatuses {
+			fmt.Fprintf(w, "  + status %s\n", status)
+		}
+		for _, status := range nd.RemovedStatuses {
+			fmt.Fprintf(w, "  - status %s\n", status)
+		}
+		for _, t := range nd.AddedTransactions {
+			fmt.Fprintf(w, "  + %s --%s--> %s\n", t.CurrentStatus, t.Event, t.TargetStatus)
+		}
+		for _, t := range nd.RemovedTransactions {
+			fmt.Fprintf(w, "  - %s --%s--> %s\n", t.CurrentStatus, t.Event, t.TargetStatus)
+		}
+		for _, r := range nd.RetargetedTransactions {
+			fmt.Fprintf(w, "  ~ %s --%s--> %s => %s\n", r.CurrentStatus, r.Event, r.OldTarget, r.NewTarget)
+		}
+		for _, k := range nd.KindChanges {
+			fmt.Fprintf(w, "  ~ %s --%s--> kind %s => %s\n", k.CurrentStatus, k.Event, kindName(k.OldKind), kindName(k.NewKind))
+		}
+		for _, b := range nd.Breaking {
+			fmt.Fprintf(w, "  ! breaking: %s [%s]\n", b.Message, b.Code)
+		}
+	}
+}
+
+func kindName(kind fsm.TransitionKind) string {
+	if kind == fsm.TransitionNormal {
+		return "normal"
+	}
+	return string(kind)
+}
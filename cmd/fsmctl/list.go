@@ -0,0 +1,57 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/iTrellis/fsm"
+)
+
+func runList(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print the result as json")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return usageError(stderr, "list")
+	}
+
+	ts, _, err := readDefinition(flags.Arg(0))
+	if err != nil {
+		return fail(stderr, "list", err)
+	}
+	graphs := fsm.NewNamespaceGraphs(ts)
+
+	if *asJSON {
+		if err := writeJSON(stdout, graphs); err != nil {
+			return fail(stderr, "list", err)
+		}
+		return exitOK
+	}
+	for _, g := range graphs {
+		fmt.Fprintln(stdout, g.Namespace)
+		fmt.Fprintf(stdout, "  statuses:  %s\n", strings.Join(g.Statuses, ", "))
+		fmt.Fprintf(stdout, "  events:    %s\n", strings.Join(g.Events, ", "))
+		fmt.Fprintf(stdout, "  initials:  %s\n", strings.Join(g.Initials, ", "))
+		fmt.Fprintf(stdout, "  terminals: %s\n", strings.Join(g.Terminals, ", "))
+	}
+	return exitOK
+}
@@ -0,0 +1,61 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/iTrellis/fsm"
+)
+
+type lookupResult struct {
+	Found       bool             `json:"found"`
+	Transaction *fsm.Transaction `json:"transaction,omitempty"`
+}
+
+func runLookup(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print the result as json")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 4 {
+		return usageError(stderr, "lookup")
+	}
+
+	if err := loadDefinition(flags.Arg(0)); err != nil {
+		return fail(stderr, "lookup", err)
+	}
+	t := fsm.New().GetTargetTranstion(flags.Arg(1), flags.Arg(2), flags.Arg(3))
+	result := lookupResult{Found: t != nil, Transaction: t}
+
+	if *asJSON {
+		if err := writeJSON(stdout, result); err != nil {
+			return fail(stderr, "lookup", err)
+		}
+	} else if t != nil {
+		fmt.Fprintln(stdout, t.TargetStatus)
+	} else {
+		fmt.Fprintf(stderr, "fsmctl lookup: %s: no transaction from %q by %q\n", flags.Arg(1), flags.Arg(2), flags.Arg(3))
+	}
+
+	if !result.Found {
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,153 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// fsmctl validates, lists, looks up, renders, diffs and simulates fsm definition files,
+// and verifies audit logs.
+//
+// Usage:
+//
+//	fsmctl <command> [flags] <file> [arguments]
+//
+// Exit codes are 0 on success, 1 if the definition is invalid, nothing is found,
+// a diff is breaking or an audit log is broken, and 2 on usage errors.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/iTrellis/fsm"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string, stdout, stderr io.Writer) int
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "validate", usage: "validate [-json] [-strict] <file>", run: runValidate},
+		{name: "render", usage: "render [-format dot|mermaid|plantuml] <file> [namespace...]", run: runRender},
+		{name: "list", usage: "list [-json] <file>", run: runList},
+		{name: "lookup", usage: "lookup [-json] <file> <namespace> <status> <event>", run: runLookup},
+		{name: "diff", usage: "diff [-json] <old file> <new file>", run: runDiff},
+		{name: "simulate", usage: "simulate [-start status] [-trace file] <file> <namespace>", run: runSimulate},
+		{name: "audit", usage: "audit verify [-json] <file>", run: runAudit},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:], stdout, stderr)
+		}
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stdout)
+		return exitOK
+	}
+	fmt.Fprintf(stderr, "fsmctl: unknown command %q\n", args[0])
+	usage(stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: fsmctl <command> [flags] <file> [arguments]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s\n", c.usage)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "files: .yaml, .yml, .json, .xml, .scxml")
+}
+
+// readDefinition read and validate a definition file by its suffix
+func readDefinition(filepath string) ([]*fsm.Transaction, []fsm.SCXMLWarning, error) {
+	if !strings.HasSuffix(filepath, ".scxml") {
+		ts, err := fsm.ParseDefinitionFile(filepath)
+		return ts, nil, err
+	}
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return fsm.ReadSCXML(f, "")
+}
+
+// loadDefinition read a definition file with its status outputs and variables into the repo
+func loadDefinition(filepath string) error {
+	def := &fsm.Definition{}
+	if strings.HasSuffix(filepath, ".scxml") {
+		ts, _, err := readDefinition(filepath)
+		if err != nil {
+			return err
+		}
+		def.Transactions = ts
+	} else {
+		var err error
+		if def, err = fsm.ReadDefinitionFile(filepath); err != nil {
+			return err
+		}
+	}
+	repo := fsm.Default()
+	repo.Remove()
+	return repo.ReplaceDefinition(nil, def)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// fail print the error and return the exit code of failure
+func fail(stderr io.Writer, name string, err error) int {
+	fmt.Fprintf(stderr, "fsmctl %s: %v\n", name, err)
+	return exitFailure
+}
+
+// usageError print the usage of the command and return the exit code of usage error
+func usageError(stderr io.Writer, name string) int {
+	for _, c := range commands {
+		if c.name == name {
+			fmt.Fprintf(stderr, "usage: fsmctl %s\n", c.usage)
+		}
+	}
+	return exitUsage
+}
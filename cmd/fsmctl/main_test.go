@@ -0,0 +1,279 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iTrellis/fsm"
+)
+
+// definitions of the test files by name
+var testDefinitions = map[string]string{
+	// order.yaml pays over 10 with a guard and counts payments, ships with a transaction output
+	"order.yaml": `fsm:
+  order:
+    pay:
+      current: created
+      event: pay
+      target: paid
+      guard: payload.amount > 10
+      assign:
+        paid:
+          add: 1
+    cancel:
+      current: created
+      event: cancel
+      target: canceled
+    ship:
+      current: paid
+      event: ship
+      target: shipped
+      output: label
+outputs:
+  order:
+    paid: invoice
+variables:
+  order:
+    paid:
+      type: int
+`,
+	// order2.yaml drops cancel and ships to delivered
+	"order2.yaml": `fsm:
+  order:
+    pay:
+      current: created
+      event: pay
+      target: paid
+    ship:
+      current: paid
+      event: ship
+      target: delivered
+`,
+	// trap.yaml has a trap status, which is a warning
+	"trap.yaml": `fsm:
+  order:
+    pay:
+      current: created
+      event: pay
+      target: paid
+    retry:
+      current: stuck
+      event: retry
+      target: stuck
+`,
+	// invalid.yaml has a transaction without event and target
+	"invalid.yaml": `fsm:
+  order:
+    pay:
+      current: created
+`,
+}
+
+// writeTestDefinitions write the test definitions into a temporary directory and get it
+func writeTestDefinitions(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range testDefinitions {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runFsmctl run the command line and get its exit code, stdout and stderr
+func runFsmctl(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunUsage(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{args: nil, code: exitUsage},
+		{args: []string{"help"}, code: exitOK},
+		{args: []string{"unknown"}, code: exitUsage},
+	}
+	for _, test := range tests {
+		code, stdout, stderr := runFsmctl(t, test.args...)
+		if code != test.code {
+			t.Errorf("fsmctl %s = %d, want %d", strings.Join(test.args, " "), code, test.code)
+		}
+		if !strings.Contains(stdout+stderr, "usage: fsmctl") {
+			t.Errorf("fsmctl %s printed no usage: %q %q", strings.Join(test.args, " "), stdout, stderr)
+		}
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	dir := writeTestDefinitions(t)
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		// quiet nothing is printed to stdout
+		quiet bool
+	}{
+		{args: []string{"validate", file("order.yaml")}, code: exitOK, stdout: file("order.yaml") + ": ok\n"},
+		{args: []string{"validate", file("trap.yaml")}, code: exitOK},
+		{args: []string{"validate", "-strict", file("trap.yaml")}, code: exitFailure},
+		{args: []string{"validate", file("invalid.yaml")}, code: exitFailure},
+		{args: []string{"validate", file("missing.yaml")}, code: exitFailure},
+		{args: []string{"validate"}, code: exitUsage},
+
+		{args: []string{"render", "-format", "mermaid", file("order2.yaml")}, code: exitOK,
+			stdout: "stateDiagram-v2\n  state \"created\" as s0\n  state \"delivered\" as s1\n  state \"paid\" as s2\n" +
+				"  [*] --> s0\n  s0 --> s2 : pay\n  s2 --> s1 : ship\n  s1 --> [*]\n"},
+		{args: []string{"render", file("order2.yaml"), "missing"}, code: exitFailure},
+		{args: []string{"render", "-format", "svg", file("order2.yaml")}, code: exitUsage},
+		{args: []string{"render", file("invalid.yaml")}, code: exitFailure},
+
+		{args: []string{"list", file("order.yaml")}, code: exitOK,
+			stdout: "order\n  statuses:  canceled, created, paid, shipped\n  events:    cancel, pay, ship\n" +
+				"  initials:  created\n  terminals: canceled, shipped\n"},
+		{args: []string{"list", file("invalid.yaml")}, code: exitFailure},
+		{args: []string{"list"}, code: exitUsage},
+
+		{args: []string{"lookup", file("order.yaml"), "order", "created", "pay"}, code: exitOK, stdout: "paid\n"},
+		{args: []string{"lookup", file("order.yaml"), "order", "paid", "pay"}, code: exitFailure, quiet: true},
+		{args: []string{"lookup", file("order.yaml"), "order"}, code: exitUsage},
+
+		{args: []string{"diff", file("order2.yaml"), file("order2.yaml")}, code: exitOK, quiet: true},
+		{args: []string{"diff", file("order.yaml"), file("order2.yaml")}, code: exitFailure},
+		{args: []string{"diff", file("order.yaml"), file("invalid.yaml")}, code: exitFailure},
+		{args: []string{"diff", file("order.yaml")}, code: exitUsage},
+	}
+	for _, test := range tests {
+		code, stdout, _ := runFsmctl(t, test.args...)
+		name := "fsmctl " + strings.Join(test.args, " ")
+		if code != test.code {
+			t.Errorf("%s = %d, want %d", name, code, test.code)
+		}
+		if test.stdout != "" && stdout != test.stdout {
+			t.Errorf("%s printed %q, want %q", name, stdout, test.stdout)
+		}
+		if test.quiet && stdout != "" {
+			t.Errorf("%s printed %q, want nothing", name, stdout)
+		}
+	}
+}
+
+// runFsmctlJSON run the command line, check its exit code and decode its json output into v
+func runFsmctlJSON(t *testing.T, code int, v interface{}, args ...string) {
+	t.Helper()
+	got, stdout, stderr := runFsmctl(t, args...)
+	if got != code {
+		t.Fatalf("fsmctl %s = %d, want %d: %s", strings.Join(args, " "), got, code, stderr)
+	}
+	if err := json.Unmarshal([]byte(stdout), v); err != nil {
+		t.Fatalf("fsmctl %s printed %q: %v", strings.Join(args, " "), stdout, err)
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	dir := writeTestDefinitions(t)
+
+	invalid := validateResult{}
+	runFsmctlJSON(t, exitFailure, &invalid, "validate", "-json", filepath.Join(dir, "invalid.yaml"))
+	if invalid.Valid || invalid.Key != "fsm.order.pay" || invalid.Error == "" || len(invalid.Issues) != 0 {
+		t.Fatalf("invalid.yaml = %+v", invalid)
+	}
+
+	for strict, code := range map[string]int{"-strict=false": exitOK, "-strict": exitFailure} {
+		trap := validateResult{}
+		runFsmctlJSON(t, code, &trap, "validate", "-json", strict, filepath.Join(dir, "trap.yaml"))
+		var codes []string
+		for _, issue := range trap.Issues {
+			codes = append(codes, issue.Code)
+		}
+		if trap.Valid != (code == exitOK) || !reflect.DeepEqual(codes, []string{"multiple-initials", "trap"}) {
+			t.Fatalf("trap.yaml %s = %+v", strict, trap)
+		}
+	}
+}
+
+func TestListJSON(t *testing.T) {
+	dir := writeTestDefinitions(t)
+	var graphs []*fsm.NamespaceGraph
+	runFsmctlJSON(t, exitOK, &graphs, "list", "-json", filepath.Join(dir, "order2.yaml"))
+	if len(graphs) != 1 {
+		t.Fatalf("graphs = %+v", graphs)
+	}
+	g := graphs[0]
+	if g.Namespace != "order" || !reflect.DeepEqual(g.Statuses, []string{"created", "delivered", "paid"}) ||
+		!reflect.DeepEqual(g.Events, []string{"pay", "ship"}) || !reflect.DeepEqual(g.Initials, []string{"created"}) ||
+		!reflect.DeepEqual(g.Terminals, []string{"delivered"}) || len(g.Transactions) != 2 {
+		t.Fatalf("graph = %+v", g)
+	}
+}
+
+func TestLookupJSON(t *testing.T) {
+	file := filepath.Join(writeTestDefinitions(t), "order.yaml")
+
+	found := lookupResult{}
+	runFsmctlJSON(t, exitOK, &found, "lookup", "-json", file, "order", "created", "pay")
+	if tr := found.Transaction; !found.Found || tr == nil || tr.TargetStatus != "paid" || tr.Guard != "payload.amount > 10" {
+		t.Fatalf("lookup created pay = %+v", found)
+	}
+
+	missing := lookupResult{}
+	runFsmctlJSON(t, exitFailure, &missing, "lookup", "-json", file, "order", "paid", "pay")
+	if missing.Found || missing.Transaction != nil {
+		t.Fatalf("lookup paid pay = %+v", missing)
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	dir := writeTestDefinitions(t)
+
+	d := fsm.DefinitionDiff{}
+	runFsmctlJSON(t, exitFailure, &d, "diff", "-json", filepath.Join(dir, "order.yaml"), filepath.Join(dir, "order2.yaml"))
+	if !d.IsBreaking() || len(d.Namespaces) != 1 {
+		t.Fatalf("diff = %+v", d)
+	}
+	nd := d.Namespaces[0]
+	var codes []string
+	for _, b := range nd.Breaking {
+		codes = append(codes, b.Code)
+	}
+	if !reflect.DeepEqual(nd.AddedStatuses, []string{"delivered"}) ||
+		!reflect.DeepEqual(nd.RemovedStatuses, []string{"canceled", "shipped"}) ||
+		len(nd.RemovedTransactions) != 1 || len(nd.RetargetedTransactions) != 1 ||
+		!reflect.DeepEqual(codes, []string{"removed-status", "removed-status", "removed-transaction"}) {
+		t.Fatalf("namespace diff = %+v", nd)
+	}
+
+	same := fsm.DefinitionDiff{}
+	runFsmctlJSON(t, exitOK, &same, "diff", "-json", filepath.Join(dir, "order2.yaml"), filepath.Join(dir, "order2.yaml"))
+	if same.IsBreaking() || len(same.Namespaces) != 0 {
+		t.Fatalf("diff of the same file = %+v", same)
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"io"
+
+	"github.com/iTrellis/fsm"
+)
+
+func runRender(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", string(fsm.DiagramDOT), "diagram format: dot, mermaid or plantuml")
+	if err := flags.Parse(args); err != nil || flags.NArg() < 1 {
+		return usageError(stderr, "render")
+	}
+
+	switch fsm.DiagramFormat(*format) {
+	case fsm.DiagramDOT, fsm.DiagramMermaid, fsm.DiagramPlantUML:
+	default:
+		return usageError(stderr, "render")
+	}
+
+	if err := loadDefinition(flags.Arg(0)); err != nil {
+		return fail(stderr, "render", err)
+	}
+	if err := fsm.Render(stdout, fsm.DiagramFormat(*format), flags.Args()[1:]...); err != nil {
+		return fail(stderr, "render", err)
+	}
+	return exitOK
+}
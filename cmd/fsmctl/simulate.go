@@ -0,0 +1,343 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/iTrellis/fsm"
+)
+
+// stdin the input of the simulator
+var stdin io.Reader = os.Stdin
+
+// trace the path walked in the simulator
+type trace struct {
+	Namespace string      `json:"namespace"`
+	Start     string      `json:"start"`
+	Steps     []traceStep `json:"steps"`
+}
+
+type traceStep struct {
+	Event string `json:"event"`
+	// Payload the payload read by the guard
+	Payload map[string]interface{} `json:"payload,omitempty"`
+	From    string                 `json:"from"`
+	To      string                 `json:"to"`
+	// Output the Mealy output of the transaction, or the Moore output of the target status
+	Output string `json:"output,omitempty"`
+}
+
+func (p *trace) current() string {
+	if len(p.Steps) == 0 {
+		return p.Start
+	}
+	return p.Steps[len(p.Steps)-1].To
+}
+
+type simulator struct {
+	repo  fsm.TableRepo
+	trace trace
+	// m the machine at the end of the trace, guards and assignments run as they do at runtime
+	m   *fsm.Machine[string, string]
+	out io.Writer
+}
+
+func runSimulate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	start := flags.String("start", "", "start status, the only initial status if empty")
+	traceFile := flags.String("trace", "", "replay a saved trace file before reading commands")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+		return usageError(stderr, "simulate")
+	}
+
+	if err := loadDefinition(flags.Arg(0)); err != nil {
+		return fail(stderr, "simulate", err)
+	}
+	namespace := flags.Arg(1)
+	s := &simulator{repo: fsm.Default(), out: stdout, trace: trace{Namespace: namespace, Start: *start}}
+
+	ts := s.repo.GetTransactions(namespace)
+	if len(ts) == 0 {
+		return fail(stderr, "simulate", &fsm.ConfigError{Key: "fsm." + namespace, Err: fsm.ErrNamespaceNotFound})
+	}
+	g := fsm.NewNamespaceGraph(namespace, ts)
+	switch {
+	case *traceFile != "":
+		if err := s.load(*traceFile); err != nil {
+			return fail(stderr, "simulate", err)
+		}
+	case s.trace.Start == "" && len(g.Initials) == 1:
+		s.trace.Start = g.Initials[0]
+	case s.trace.Start == "":
+		fmt.Fprintf(stderr, "fsmctl simulate: no single initial status, use -start with one of: %s\n",
+			strings.Join(g.Statuses, ", "))
+		return exitUsage
+	default:
+		if !contains(g.Statuses, s.trace.Start) {
+			return fail(stderr, "simulate", fmt.Errorf("%w: %q", fsm.ErrUnknownStatus, s.trace.Start))
+		}
+	}
+
+	if s.m == nil {
+		if err := s.reset(); err != nil {
+			return fail(stderr, "simulate", err)
+		}
+	}
+	s.show()
+	scanner := bufio.NewScanner(stdin)
+	for {
+		fmt.Fprintf(stdout, "%s:%s> ", namespace, s.trace.current())
+		if !scanner.Scan() {
+			fmt.Fprintln(stdout)
+			break
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "exit" {
+			break
+		}
+		if err := s.exec(fields[0], fields[1:]); err != nil {
+			fmt.Fprintf(stdout, "error: %v\n", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(stderr, "simulate", err)
+	}
+	return exitOK
+}
+
+func (p *simulator) exec(name string, args []string) error {
+	switch name {
+	case "help":
+		fmt.Fprintln(p.out, "commands:")
+		fmt.Fprintln(p.out, "  fire <event> [name=value ...]")
+		fmt.Fprintln(p.out, "                 fire an event from current status with the payload read by its guard")
+		fmt.Fprintln(p.out, "  undo           go back one step")
+		fmt.Fprintln(p.out, "  status         show current status and available events")
+		fmt.Fprintln(p.out, "  path           print the path taken")
+		fmt.Fprintln(p.out, "  save <file>    save the trace")
+		fmt.Fprintln(p.out, "  load <file>    replay a saved trace")
+		fmt.Fprintln(p.out, "  quit           leave the simulator")
+	case "fire":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: fire <event> [name=value ...]")
+		}
+		payload, err := parsePayload(args[1:])
+		if err != nil {
+			return err
+		}
+		if err = p.fire(args[0], payload); err != nil {
+			return err
+		}
+		if output := p.trace.Steps[len(p.trace.Steps)-1].Output; output != "" {
+			fmt.Fprintf(p.out, "output: %s\n", output)
+		}
+		p.show()
+	case "undo":
+		if len(p.trace.Steps) == 0 {
+			return fmt.Errorf("nothing to undo")
+		}
+		steps := p.trace.Steps[:len(p.trace.Steps)-1]
+		if err := p.replay(steps); err != nil {
+			return err
+		}
+		p.show()
+	case "status":
+		p.show()
+	case "path":
+		p.path()
+	case "save":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: save <file>")
+		}
+		return p.save(args[0])
+	case "load":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: load <file>")
+		}
+		if err := p.load(args[0]); err != nil {
+			return err
+		}
+		p.show()
+	default:
+		return fmt.Errorf("unknown command %q, try help", name)
+	}
+	return nil
+}
+
+// reset start the trace again with a new machine at its start status
+func (p *simulator) reset() error {
+	m, err := fsm.NewRepoMachine(p.repo, p.trace.Namespace, p.trace.Start)
+	if err != nil {
+		return err
+	}
+	p.m, p.trace.Steps = m, nil
+	return nil
+}
+
+// replay start the trace again and fire the steps, the trace is kept if any step is not permitted
+func (p *simulator) replay(steps []traceStep) error {
+	replay := &simulator{repo: p.repo, trace: trace{Namespace: p.trace.Namespace, Start: p.trace.Start}}
+	if err := replay.reset(); err != nil {
+		return err
+	}
+	for i, step := range steps {
+		if step.From != replay.trace.current() {
+			return fmt.Errorf("step %d: from %q, but current status is %q", i+1, step.From, replay.trace.current())
+		}
+		if err := replay.fire(step.Event, step.Payload); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	p.trace, p.m = replay.trace, replay.m
+	return nil
+}
+
+// fire fire the event with the machine, so rejected guards fail as they do at runtime
+func (p *simulator) fire(event string, payload map[string]interface{}) error {
+	from := p.trace.current()
+	t := p.repo.GetTargetTranstion(p.trace.Namespace, from, event)
+	if t == nil {
+		return fmt.Errorf("%w: %q from %q", fsm.ErrTransactionNotFound, event, from)
+	}
+	if err := p.m.FireWith(event, payload); err != nil {
+		return fmt.Errorf("%q from %q: %w", event, from, err)
+	}
+	to := p.m.Current()
+	output := t.Output
+	if output == "" {
+		output = p.repo.Table().GetOutput(p.trace.Namespace, to)
+	}
+	p.trace.Steps = append(p.trace.Steps, traceStep{Event: event, Payload: payload, From: from, To: to, Output: output})
+	return nil
+}
+
+// parsePayload parse name=value arguments, values are read as json and as strings if they are not json
+func parsePayload(args []string) (map[string]interface{}, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	payload := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		i := strings.Index(arg, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("payload %q is not name=value", arg)
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(arg[i+1:]), &value); err != nil {
+			value = arg[i+1:]
+		}
+		payload[arg[:i]] = value
+	}
+	return payload, nil
+}
+
+func (p *simulator) show() {
+	current := p.trace.current()
+	fmt.Fprintf(p.out, "status: %s\n", current)
+	if data := p.m.Data(); len(data) > 0 {
+		encoded, _ := json.Marshal(data)
+		fmt.Fprintf(p.out, "data: %s\n", encoded)
+	}
+
+	ts := p.repo.Table().GetAvailable(p.trace.Namespace, current)
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].Event < ts[j].Event
+	})
+	var events []string
+	for _, t := range ts {
+		event := t.Event
+		if t.Guard != "" {
+			event += " [" + t.Guard + "]"
+		}
+		if t.IsSelf() {
+			events = append(events, fmt.Sprintf("%s (%s)", event, t.Kind))
+			continue
+		}
+		events = append(events, fmt.Sprintf("%s -> %s", event, t.TargetStatus))
+	}
+	if len(events) == 0 {
+		fmt.Fprintln(p.out, "events: none, terminal status")
+		return
+	}
+	fmt.Fprintf(p.out, "events: %s\n", strings.Join(events, ", "))
+}
+
+func (p *simulator) path() {
+	fmt.Fprint(p.out, p.trace.Start)
+	for _, step := range p.trace.Steps {
+		fmt.Fprintf(p.out, " --%s--> %s", step.Event, step.To)
+	}
+	fmt.Fprintln(p.out)
+}
+
+func (p *simulator) save(filepath string) error {
+	data, err := json.MarshalIndent(p.trace, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath, append(data, '\n'), 0644)
+}
+
+// load replay the trace file, the current trace is kept if any step is not permitted
+func (p *simulator) load(filepath string) error {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+	saved := trace{}
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	if saved.Namespace != p.trace.Namespace {
+		return fmt.Errorf("trace of namespace %q, not %q", saved.Namespace, p.trace.Namespace)
+	}
+
+	g := fsm.NewNamespaceGraph(saved.Namespace, p.repo.GetTransactions(saved.Namespace))
+	if !contains(g.Statuses, saved.Start) {
+		return fmt.Errorf("%w: start %q", fsm.ErrUnknownStatus, saved.Start)
+	}
+
+	replay := &simulator{repo: p.repo, trace: trace{Namespace: saved.Namespace, Start: saved.Start}}
+	if err = replay.replay(saved.Steps); err != nil {
+		return err
+	}
+	p.trace, p.m = replay.trace, replay.m
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
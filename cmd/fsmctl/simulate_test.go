@@ -0,0 +1,150 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// simulate run the simulator with the commands as its input
+func simulate(t *testing.T, commands string, args ...string) (int, string, string) {
+	t.Helper()
+	stdin = strings.NewReader(commands)
+	defer func() { stdin = os.Stdin }()
+	return runFsmctl(t, append([]string{"simulate"}, args...)...)
+}
+
+func TestSimulateSession(t *testing.T) {
+	dir := writeTestDefinitions(t)
+	traceFile := filepath.Join(dir, "trace.json")
+	commands := strings.Join([]string{
+		"fire pay amount=5",
+		"fire pay amount=20",
+		"fire ship",
+		"path",
+		"save " + traceFile,
+		"undo",
+		"undo",
+		"undo",
+		"fire nope",
+		"bogus",
+		"load " + traceFile,
+		"quit",
+	}, "\n")
+	code, stdout, stderr := simulate(t, commands, filepath.Join(dir, "order.yaml"), "order")
+	if code != exitOK {
+		t.Fatalf("simulate = %d: %s", code, stderr)
+	}
+
+	for _, want := range []string{
+		"status: created\ndata: {\"paid\":0}\nevents: cancel -> canceled, pay [payload.amount > 10] -> paid\n",
+		"order:created> error: \"pay\" from \"created\": transition rejected by guard\n",
+		"order:created> output: invoice\nstatus: paid\ndata: {\"paid\":1}\nevents: ship -> shipped\n",
+		"order:paid> output: label\nstatus: shipped\ndata: {\"paid\":1}\nevents: none, terminal status\n",
+		"order:shipped> created --pay--> paid --ship--> shipped\n",
+		"order:shipped> order:shipped> status: paid\ndata: {\"paid\":1}\n",
+		"order:paid> status: created\ndata: {\"paid\":0}\n",
+		"order:created> error: nothing to undo\n",
+		"order:created> error: transaction not found: \"nope\" from \"created\"\n",
+		"order:created> error: unknown command \"bogus\", try help\n",
+		"order:created> status: shipped\ndata: {\"paid\":1}\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("output does not contain %q:\n%s", want, stdout)
+		}
+	}
+
+	data, err := os.ReadFile(traceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := trace{}
+	if err = json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Namespace != "order" || saved.Start != "created" || len(saved.Steps) != 2 ||
+		saved.Steps[0].Payload["amount"] != 20.0 || saved.Steps[0].Output != "invoice" || saved.Steps[1].Output != "label" {
+		t.Fatalf("saved trace = %+v", saved)
+	}
+}
+
+func TestSimulateTrace(t *testing.T) {
+	dir := writeTestDefinitions(t)
+	file := filepath.Join(dir, "order.yaml")
+	writeTrace := func(name string, amount float64) string {
+		t.Helper()
+		data, err := json.Marshal(&trace{Namespace: "order", Start: "created", Steps: []traceStep{
+			{Event: "pay", Payload: map[string]interface{}{"amount": amount}, From: "created", To: "paid"},
+			{Event: "ship", From: "paid", To: "shipped"},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err = os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	code, stdout, stderr := simulate(t, "", "-trace", writeTrace("paid.json", 20), file, "order")
+	if code != exitOK || !strings.HasPrefix(stdout, "status: shipped\n") {
+		t.Fatalf("simulate -trace paid.json = %d %q %q", code, stdout, stderr)
+	}
+
+	code, _, stderr = simulate(t, "", "-trace", writeTrace("rejected.json", 5), file, "order")
+	if code != exitFailure || !strings.Contains(stderr, "step 1: \"pay\" from \"created\": transition rejected by guard") {
+		t.Fatalf("simulate -trace rejected.json = %d %q", code, stderr)
+	}
+
+	// a rejected load keeps the current trace
+	commands := "fire pay amount=20\nload " + filepath.Join(dir, "rejected.json") + "\npath\n"
+	if _, stdout, _ = simulate(t, commands, file, "order"); !strings.Contains(stdout, "error: step 1:") ||
+		!strings.Contains(stdout, "created --pay--> paid\n") {
+		t.Fatalf("load of a rejected trace printed:\n%s", stdout)
+	}
+}
+
+func TestSimulateExitCodes(t *testing.T) {
+	dir := writeTestDefinitions(t)
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{args: []string{filepath.Join(dir, "order.yaml"), "order"}, code: exitOK},
+		{args: []string{"-start", "paid", filepath.Join(dir, "order.yaml"), "order"}, code: exitOK},
+		{args: []string{"-start", "nowhere", filepath.Join(dir, "order.yaml"), "order"}, code: exitFailure},
+		{args: []string{filepath.Join(dir, "order.yaml"), "missing"}, code: exitFailure},
+		{args: []string{filepath.Join(dir, "invalid.yaml"), "order"}, code: exitFailure},
+		{args: []string{"-trace", filepath.Join(dir, "missing.json"), filepath.Join(dir, "order.yaml"), "order"},
+			code: exitFailure},
+		// created and stuck are both initial
+		{args: []string{filepath.Join(dir, "trap.yaml"), "order"}, code: exitUsage},
+		{args: []string{"-start", "stuck", filepath.Join(dir, "trap.yaml"), "order"}, code: exitOK},
+		{args: []string{filepath.Join(dir, "order.yaml")}, code: exitUsage},
+	}
+	for _, test := range tests {
+		if code, _, stderr := simulate(t, "quit\n", test.args...); code != test.code {
+			t.Errorf("fsmctl simulate %s = %d, want %d: %s", strings.Join(test.args, " "), code, test.code, stderr)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/iTrellis/fsm"
+)
+
+type validateResult struct {
+	File   string      `json:"file"`
+	Valid  bool        `json:"valid"`
+	Key    string      `json:"key,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Issues []fsm.Issue `json:"issues"`
+}
+
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print the result as json")
+	strict := flags.Bool("strict", false, "fail on warnings")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return usageError(stderr, "validate")
+	}
+
+	result := validateResult{File: flags.Arg(0), Issues: []fsm.Issue{}}
+	ts, warnings, err := readDefinition(result.File)
+	if err != nil {
+		result.Error = err.Error()
+		var cfgErr *fsm.ConfigError
+		if errors.As(err, &cfgErr) {
+			result.Key = cfgErr.Key
+		}
+	} else {
+		for _, w := range warnings {
+			result.Issues = append(result.Issues, fsm.Issue{
+				Level:   fsm.IssueWarning,
+				Code:    "scxml",
+				Message: w.String(),
+			})
+		}
+		result.Issues = append(result.Issues, fsm.Analyze(ts)...)
+	}
+
+	result.Valid = err == nil
+	for _, issue := range result.Issues {
+		if issue.Level == fsm.IssueError || (*strict && issue.Level == fsm.IssueWarning) {
+			result.Valid = false
+		}
+	}
+
+	if *asJSON {
+		if err := writeJSON(stdout, result); err != nil {
+			return fail(stderr, "validate", err)
+		}
+	} else {
+		if result.Error != "" {
+			fmt.Fprintf(stdout, "%s: error: %s\n", result.File, result.Error)
+		}
+		for _, issue := range result.Issues {
+			fmt.Fprintf(stdout, "%s: %s: %s: %s [%s]\n", result.File, issue.Level, issue.Namespace, issue.Message, issue.Code)
+		}
+		if result.Valid {
+			fmt.Fprintf(stdout, "%s: ok\n", result.File)
+		}
+	}
+
+	if !result.Valid {
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,354 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/iTrellis/fsm"
+)
+
+type genFile struct {
+	Source     string
+	Package    string
+	Namespaces []*genNamespace
+}
+
+type genNamespace struct {
+	Name         string
+	Type         string
+	Statuses     []genConst
+	Events       []genEvent
+	Transactions []*fsm.Transaction
+	Outputs      []*fsm.StatusOutput[string]
+	Variables    []*fsm.Variable
+}
+
+type genConst struct {
+	Ident string
+	Value string
+}
+
+type genEvent struct {
+	genConst
+	Method string
+	// Guarded a transaction of the event has a guard, so its method takes the payload read by the guard
+	Guarded bool
+}
+
+// methods and fields of the generated instance type, event helpers must not use them
+var reservedMethods = map[string]bool{"Fire": true, "Can": true, "Status": true, "Data": true, "Output": true}
+
+// genIdents the identifiers declared in a scope of the generated file and what declares them
+type genIdents map[string]string
+
+// declare declare an identifier, it is an error if another name is converted to the same one
+func (p genIdents) declare(ident, what string) error {
+	if prev, ok := p[ident]; ok {
+		return fmt.Errorf("%s and %s are both named %s", prev, what, ident)
+	}
+	p[ident] = what
+	return nil
+}
+
+func generate(source, pkg string, def *fsm.Definition, namespaces []string) ([]byte, error) {
+	file := &genFile{Source: filepath.Base(source), Package: pkg}
+	idents := genIdents{"Register": "function Register"}
+
+	selected := make(map[string]bool)
+	for _, namespace := range namespaces {
+		selected[strings.TrimSpace(namespace)] = true
+	}
+	outputs := make(map[string][]*fsm.StatusOutput[string])
+	for _, o := range def.Outputs {
+		outputs[o.Namespace] = append(outputs[o.Namespace], o)
+	}
+	variables := make(map[string][]*fsm.Variable)
+	for _, v := range def.Variables {
+		variables[v.Namespace] = append(variables[v.Namespace], v)
+	}
+	for _, g := range fsm.NewNamespaceGraphs(def.Transactions) {
+		if len(selected) > 0 && !selected[g.Namespace] {
+			continue
+		}
+		delete(selected, g.Namespace)
+
+		ns := &genNamespace{
+			Name:         g.Namespace,
+			Type:         exportedIdent(g.Namespace, "Namespace"),
+			Transactions: g.Transactions,
+			Outputs:      outputs[g.Namespace],
+			Variables:    variables[g.Namespace],
+		}
+		what := fmt.Sprintf("namespace %q", g.Namespace)
+		for _, ident := range []string{ns.Type, ns.Type + "Namespace", ns.Type + "Status", ns.Type + "Event"} {
+			if err := idents.declare(ident, what); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, status := range g.Statuses {
+			c := genConst{Ident: ns.Type + "Status" + exportedIdent(status, ""), Value: status}
+			if err := idents.declare(c.Ident, fmt.Sprintf("status %q of %s", status, what)); err != nil {
+				return nil, err
+			}
+			ns.Statuses = append(ns.Statuses, c)
+		}
+		guarded := make(map[string]bool)
+		for _, t := range g.Transactions {
+			if t.Guard != "" {
+				guarded[t.Event] = true
+			}
+		}
+		methods := make(genIdents)
+		for _, event := range g.Events {
+			method := exportedIdent(event, "Event")
+			if reservedMethods[method] {
+				method += "Event"
+			}
+			e := genEvent{
+				genConst: genConst{Ident: ns.Type + "Event" + exportedIdent(event, ""), Value: event},
+				Method:   method,
+				Guarded:  guarded[event],
+			}
+			eventWhat := fmt.Sprintf("event %q of %s", event, what)
+			if err := idents.declare(e.Ident, eventWhat); err != nil {
+				return nil, err
+			}
+			if err := methods.declare(e.Method, eventWhat); err != nil {
+				return nil, err
+			}
+			ns.Events = append(ns.Events, e)
+		}
+		file.Namespaces = append(file.Namespaces, ns)
+	}
+	for namespace := range selected {
+		return nil, fmt.Errorf("%w: %q", fsm.ErrNamespaceNotFound, namespace)
+	}
+
+	var buf bytes.Buffer
+	if err := genTemplate.Execute(&buf, file); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return src, nil
+}
+
+// exportedIdent convert a name to an exported Go identifier, e.g. orders@v3 to OrdersV3,
+// prefix is added if the name starts with a digit or is empty
+func exportedIdent(name, prefix string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	ident := b.String()
+	if ident == "" || !unicode.IsLetter([]rune(ident)[0]) {
+		ident = prefix + ident
+	}
+	return ident
+}
+
+// kindConst the Go expression of a transition kind
+func kindConst(kind fsm.TransitionKind) string {
+	switch kind {
+	case fsm.TransitionInternal:
+		return "fsm.TransitionInternal"
+	case fsm.TransitionExternal:
+		return "fsm.TransitionExternal"
+	default:
+		return "fsm.TransitionKind(" + strconv.Quote(string(kind)) + ")"
+	}
+}
+
+// literal the Go expression of a variable value, typed like values of variables
+func literal(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case int64:
+		return "int64(" + strconv.FormatInt(v, 10) + ")"
+	case float64:
+		return "float64(" + strconv.FormatFloat(v, 'g', -1, 64) + ")"
+	default:
+		return fmt.Sprintf("%#v", v)
+	}
+}
+
+var genTemplate = template.Must(template.New("fsmgen").Funcs(template.FuncMap{
+	"quote":   strconv.Quote,
+	"kind":    kindConst,
+	"literal": literal,
+}).Parse(`// Code generated by fsmgen from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"github.com/iTrellis/fsm"
+)
+
+// registered the repo whose machines fire events of the generated types, set by Register
+var registered fsm.TableRepo = fsm.Default()
+
+// Register add the generated transactions, status outputs and variables into the repo,
+// and fire events of the generated types with machines of it
+func Register(repo fsm.TableRepo) error {
+{{- range .Namespaces}}
+{{- range .Variables}}
+	if err := repo.Table().SetVariable(&fsm.Variable{
+		Namespace: {{quote .Namespace}},
+		Name:      {{quote .Name}},
+		Type:      {{printf "%q" .Type}},
+		{{- if .Default}}
+		Default:   {{literal .Default}},
+		{{- end}}
+	}); err != nil {
+		return err
+	}
+{{- end}}
+{{- range .Transactions}}
+	repo.Add(&fsm.Transaction{
+		Namespace:     {{quote .Namespace}},
+		CurrentStatus: {{quote .CurrentStatus}},
+		Event:         {{quote .Event}},
+		{{- if .IsSelf}}
+		Kind:          {{kind .Kind}},
+		{{- else}}
+		TargetStatus:  {{quote .TargetStatus}},
+		{{- end}}
+		{{- if .Output}}
+		Output:        {{quote .Output}},
+		{{- end}}
+		{{- if .Guard}}
+		Guard:         {{quote .Guard}},
+		{{- end}}
+		{{- if .Assignments}}
+		Assignments: []*fsm.Assignment{
+			{{- range .Assignments}}
+			{Variable: {{quote .Variable}}, Op: {{printf "%q" .Op}}, Value: {{literal .Value}}},
+			{{- end}}
+		},
+		{{- end}}
+	})
+{{- end}}
+{{- range .Outputs}}
+	repo.Table().SetOutput({{quote .Namespace}}, {{quote .Status}}, {{quote .Output}})
+{{- end}}
+{{- end}}
+	registered = repo
+	return nil
+}
+{{range .Namespaces}}{{$ns := .}}
+// {{.Type}}Namespace the namespace {{quote .Name}}
+const {{.Type}}Namespace = {{quote .Name}}
+
+// {{.Type}}Status a status of namespace {{quote .Name}}
+type {{.Type}}Status string
+
+// statuses of namespace {{quote .Name}}
+const (
+{{- range .Statuses}}
+	{{.Ident}} {{$ns.Type}}Status = {{quote .Value}}
+{{- end}}
+)
+
+// {{.Type}}Event an event of namespace {{quote .Name}}
+type {{.Type}}Event string
+
+// events of namespace {{quote .Name}}
+const (
+{{- range .Events}}
+	{{.Ident}} {{$ns.Type}}Event = {{quote .Value}}
+{{- end}}
+)
+
+// {{.Type}} an instance walking in namespace {{quote .Name}}, events are fired by machines of the registered repo
+type {{.Type}} struct {
+	Status {{.Type}}Status
+	// Data values of the variables, missing ones get their defaults
+	Data fsm.Data
+	// Output the output of the last fired event, the Mealy output of its transaction or the Moore output of the status
+	Output string
+}
+
+// machine new a machine at the status and data of the instance
+func (p *{{.Type}}) machine() (*fsm.Machine[string, string], error) {
+	m, err := fsm.NewRepoMachine(registered, {{.Type}}Namespace, string(p.Status))
+	if err != nil {
+		return nil, err
+	}
+	if err = m.Restore(&fsm.Snapshot[string]{Namespace: {{.Type}}Namespace, Status: string(p.Status), Data: p.Data}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Fire fire an event with the payload read by guards, and move to the target status with the assigned data and output
+func (p *{{.Type}}) Fire(event {{.Type}}Event, payload map[string]interface{}) error {
+	m, err := p.machine()
+	if err != nil {
+		return err
+	}
+	t := registered.GetTargetTranstion({{.Type}}Namespace, string(p.Status), string(event))
+	if err = m.FireWith(string(event), payload); err != nil {
+		return err
+	}
+	p.Status, p.Data, p.Output = {{.Type}}Status(m.Current()), m.Data(), m.Output()
+	if t != nil && t.Output != "" {
+		p.Output = t.Output
+	}
+	return nil
+}
+
+// Can judge the event can be fired in current status, guards are evaluated without payload
+func (p *{{.Type}}) Can(event {{.Type}}Event) bool {
+	m, err := p.machine()
+	return err == nil && m.Can(string(event))
+}
+{{range .Events}}
+{{- if .Guarded}}
+// {{.Method}} fire event {{quote .Value}} with the payload read by its guard
+func (p *{{$ns.Type}}) {{.Method}}(payload map[string]interface{}) error {
+	return p.Fire({{.Ident}}, payload)
+}
+{{else}}
+// {{.Method}} fire event {{quote .Value}}
+func (p *{{$ns.Type}}) {{.Method}}() error {
+	return p.Fire({{.Ident}}, nil)
+}
+{{end}}
+{{- end}}
+{{- end}}`))
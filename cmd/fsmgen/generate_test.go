@@ -0,0 +1,248 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iTrellis/fsm"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGenerateGolden(t *testing.T) {
+	def, err := fsm.ReadDefinitionFile("testdata/order.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate("testdata/order.yaml", "orderfsm", def, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "order.golden")
+	if *update {
+		if err = ioutil.WriteFile(golden, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, want) {
+		t.Fatalf("generated code differs from %s, run go test -update to accept it:\n%s", golden, src)
+	}
+}
+
+// registerTest the test of the generated package, all transactions must be registered
+const registerTest = `package orderfsm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/iTrellis/fsm"
+)
+
+func TestRegister(t *testing.T) {
+	repo := fsm.Default()
+	repo.Remove()
+	if err := Register(repo); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(repo.GetTransactions(OrderNamespace)); n != 5 {
+		t.Fatalf("registered %d transactions, want 5", n)
+	}
+	o := &Order{Status: OrderStatusCanceled}
+	if err := o.FireEvent(); err != nil || o.Status != OrderStatusCreated {
+		t.Fatalf("FireEvent() = %v, status %s", err, o.Status)
+	}
+	if got := o.Data["retries"]; got != int64(1) {
+		t.Fatalf("retries = %v, want 1", got)
+	}
+}
+
+func TestFireGuard(t *testing.T) {
+	repo := fsm.Default()
+	repo.Remove()
+	if err := Register(repo); err != nil {
+		t.Fatal(err)
+	}
+	o := &Order{Status: OrderStatusCreated}
+	if o.Can(OrderEventPay) {
+		t.Fatal("Can(pay) without payload = true")
+	}
+	if err := o.Pay(map[string]interface{}{"amount": 0}); !errors.Is(err, fsm.ErrGuardRejected) {
+		t.Fatalf("Pay(0) = %v, want %v", err, fsm.ErrGuardRejected)
+	}
+	if err := o.Pay(map[string]interface{}{"amount": 10}); err != nil || o.Status != OrderStatusPaid {
+		t.Fatalf("Pay(10) = %v, status %s", err, o.Status)
+	}
+	if err := o.Ship(); err != nil || o.Output != "shipping" {
+		t.Fatalf("Ship() = %v, output %q, want shipping", err, o.Output)
+	}
+}
+
+func TestStatusOutput(t *testing.T) {
+	repo := fsm.Default()
+	repo.Remove()
+	if err := Register(repo); err != nil {
+		t.Fatal(err)
+	}
+	p := &Parity{Status: ParityStatusEven}
+	if err := p.Event1(); err != nil || p.Output != "1" {
+		t.Fatalf("Event1() = %v, output %q, want 1", err, p.Output)
+	}
+}
+
+func TestRegisterRepo(t *testing.T) {
+	repo := fsm.Default()
+	repo.Remove()
+	if err := Register(repo); err != nil {
+		t.Fatal(err)
+	}
+	repo.Remove()
+	o := &Order{Status: OrderStatusPaid}
+	if err := o.Ship(); !errors.Is(err, fsm.ErrTransactionNotFound) {
+		t.Fatalf("Ship() after removing = %v, want %v", err, fsm.ErrTransactionNotFound)
+	}
+}
+`
+
+// TestGenerateCompiles build and test the golden file as a package of the module
+func TestGenerateCompiles(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	src, err := ioutil.ReadFile(filepath.Join("testdata", "order.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("testdata", "orderfsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "order_fsm.go"), src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "order_fsm_test.go"), []byte(registerTest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := "./" + filepath.ToSlash(dir)
+	if out, err := exec.Command(gobin, "vet", pkg).CombinedOutput(); err != nil {
+		t.Fatalf("generated code does not compile: %v\n%s", err, out)
+	}
+	if out, err := exec.Command(gobin, "test", pkg).CombinedOutput(); err != nil {
+		t.Fatalf("generated code does not register: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateCollisions(t *testing.T) {
+	tests := []struct {
+		name string
+		ts   []*fsm.Transaction
+		want string
+	}{
+		{
+			name: "namespaces",
+			ts: []*fsm.Transaction{
+				{Namespace: "order", CurrentStatus: "a", Event: "e", TargetStatus: "b"},
+				{Namespace: "order_status", CurrentStatus: "a", Event: "e", TargetStatus: "b"},
+			},
+			want: `namespace "order" and namespace "order_status" are both named OrderStatus`,
+		},
+		{
+			name: "event methods",
+			ts: []*fsm.Transaction{
+				{Namespace: "order", CurrentStatus: "a", Event: "fire", TargetStatus: "b"},
+				{Namespace: "order", CurrentStatus: "b", Event: "fire_event", TargetStatus: "a"},
+			},
+			want: `event "fire" of namespace "order" and event "fire_event" of namespace "order" are both named FireEvent`,
+		},
+		{
+			name: "statuses",
+			ts: []*fsm.Transaction{
+				{Namespace: "order", CurrentStatus: "to-pay", Event: "e", TargetStatus: "to_pay"},
+			},
+			want: `status "to-pay" of namespace "order" and status "to_pay" of namespace "order" are both named OrderStatusToPay`,
+		},
+		{
+			name: "status field",
+			ts: []*fsm.Transaction{
+				{Namespace: "order", CurrentStatus: "a", Event: "status", TargetStatus: "b"},
+				{Namespace: "order", CurrentStatus: "b", Event: "status_event", TargetStatus: "a"},
+			},
+			want: "are both named StatusEvent",
+		},
+		{
+			name: "register",
+			ts: []*fsm.Transaction{
+				{Namespace: "register", CurrentStatus: "a", Event: "e", TargetStatus: "b"},
+			},
+			want: `function Register and namespace "register" are both named Register`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := generate("test.yaml", "fsmdef", &fsm.Definition{Transactions: tt.ts}, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("generate() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunCollision(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "order.yaml")
+	err := ioutil.WriteFile(file, []byte(`fsm:
+  order:
+    t1:
+      current: a
+      event: e
+      target: b
+  order_status:
+    t1:
+      current: a
+      event: e
+      target: b
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{file}, &stdout, &stderr); code != 1 {
+		t.Fatalf("run() = %d, want 1, stderr: %s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "both named OrderStatus") {
+		t.Fatalf("stderr = %q", stderr.String())
+	}
+}
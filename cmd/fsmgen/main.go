@@ -0,0 +1,84 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// fsmgen generates a Go package with typed status and event constants from a definition file.
+//
+// Usage:
+//
+//	fsmgen [-o file] [-package name] [-namespaces a,b] <definition file>
+//
+// It is designed for go generate:
+//
+//	//go:generate fsmgen -o order_fsm.go order.yaml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/iTrellis/fsm"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fsmgen", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	output := flags.String("o", "", "output file, stdout if empty")
+	pkg := flags.String("package", os.Getenv("GOPACKAGE"), "package name, $GOPACKAGE if empty")
+	namespaces := flags.String("namespaces", "", "comma separated namespaces, all if empty")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: fsmgen [-o file] [-package name] [-namespaces a,b] <definition file>")
+		return 2
+	}
+	if *pkg == "" {
+		*pkg = "fsmdef"
+	}
+
+	def, err := fsm.ReadDefinitionFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "fsmgen: %v\n", err)
+		return 1
+	}
+
+	var selected []string
+	if *namespaces != "" {
+		selected = strings.Split(*namespaces, ",")
+	}
+	src, err := generate(flags.Arg(0), *pkg, def, selected)
+	if err != nil {
+		fmt.Fprintf(stderr, "fsmgen: %v\n", err)
+		return 1
+	}
+
+	if *output == "" {
+		_, err = stdout.Write(src)
+	} else {
+		err = ioutil.WriteFile(*output, src, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "fsmgen: %v\n", err)
+		return 1
+	}
+	return 0
+}
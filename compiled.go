@@ -0,0 +1,264 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// NoTarget the value of an empty cell in the compiled transition table
+const NoTarget = -1
+
+// CompiledNamespace a namespace frozen with statuses and events interned to dense integers
+type CompiledNamespace struct {
+	Namespace string
+
+	statuses  []string
+	events    []string
+	statusIDs map[string]int
+	eventIDs  map[string]int
+	// table[status][event] = target status, NoTarget if not exists
+	table [][]int
+	// outputs[status] = Moore output of the status
+	outputs []string
+	// mealy[status][event] = Mealy output of the transition, nil if no transition has one
+	mealy [][]string
+}
+
+// Compile freeze a namespace's transactions and outputs into a compiled namespace,
+// namespaces with guards or assignments are not compiled because compiled machines have no extended state
+func (p *DefaultRepo) Compile(namespace string) (*CompiledNamespace, error) {
+	spaceTrans := p.table.GetTransitions(namespace)
+	if len(spaceTrans) == 0 {
+		return nil, ErrNamespaceNotFound
+	}
+	if err := compilable(spaceTrans); err != nil {
+		return nil, err
+	}
+	return compile(namespace, spaceTrans, p.table.GetOutputs(namespace)), nil
+}
+
+// compilable check the transactions have nothing a compiled machine can not run
+func compilable(spaceTrans []*Transaction) error {
+	sortTransactions(spaceTrans)
+	for _, t := range spaceTrans {
+		if t.Guard != "" {
+			return fmt.Errorf("%w: transaction %q from %q has guard %q, compiled machines do not evaluate guards",
+				ErrNotCompilable, t.Event, t.CurrentStatus, t.Guard)
+		}
+		if len(t.Assignments) > 0 {
+			return fmt.Errorf("%w: transaction %q from %q has assignments, compiled machines have no variables",
+				ErrNotCompilable, t.Event, t.CurrentStatus)
+		}
+	}
+	return nil
+}
+
+func compile(namespace string, spaceTrans []*Transaction, outputs map[string]string) *CompiledNamespace {
+	c := &CompiledNamespace{
+		Namespace: namespace,
+		statusIDs: make(map[string]int),
+		eventIDs:  make(map[string]int),
+	}
+
+	// statuses of the graph leave out the wildcard, and self transitions from it target the status they fire in
+	g := NewNamespaceGraph(namespace, spaceTrans)
+	for _, status := range g.Statuses {
+		c.statusIDs[status] = 0
+	}
+	for _, event := range g.Events {
+		c.eventIDs[event] = 0
+	}
+	c.statuses = internKeys(c.statusIDs)
+	c.events = internKeys(c.eventIDs)
+
+	c.table = make([][]int, len(c.statuses))
+	for i := range c.table {
+		row := make([]int, len(c.events))
+		for j := range row {
+			row[j] = NoTarget
+		}
+		c.table[i] = row
+	}
+
+	c.outputs = make([]string, len(c.statuses))
+	for i, status := range c.statuses {
+		c.outputs[i] = outputs[status]
+	}
+
+	for _, t := range g.Expand() {
+		status, event := c.statusIDs[t.CurrentStatus], c.eventIDs[t.Event]
+		c.table[status][event] = c.statusIDs[t.TargetStatus]
+		if t.Output == "" {
+			continue
+		}
+		if c.mealy == nil {
+			c.mealy = make([][]string, len(c.statuses))
+			for i := range c.mealy {
+				c.mealy[i] = make([]string, len(c.events))
+			}
+		}
+		c.mealy[status][event] = t.Output
+	}
+	return c
+}
+
+// internKeys sort the names and write their ids back into ids
+func internKeys(ids map[string]int) []string {
+	names := make([]string, 0, len(ids))
+	for name := range ids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		ids[name] = i
+	}
+	return names
+}
+
+// NumStatuses get the number of statuses
+func (p *CompiledNamespace) NumStatuses() int {
+	return len(p.statuses)
+}
+
+// NumEvents get the number of events
+func (p *CompiledNamespace) NumEvents() int {
+	return len(p.events)
+}
+
+// StatusID get status's id by name
+func (p *CompiledNamespace) StatusID(name string) (int, bool) {
+	id, ok := p.statusIDs[name]
+	return id, ok
+}
+
+// EventID get event's id by name
+func (p *CompiledNamespace) EventID(name string) (int, bool) {
+	id, ok := p.eventIDs[name]
+	return id, ok
+}
+
+// StatusName get status's name by id, empty if id is out of range
+func (p *CompiledNamespace) StatusName(id int) string {
+	if id < 0 || id >= len(p.statuses) {
+		return ""
+	}
+	return p.statuses[id]
+}
+
+// EventName get event's name by id, empty if id is out of range
+func (p *CompiledNamespace) EventName(id int) string {
+	if id < 0 || id >= len(p.events) {
+		return ""
+	}
+	return p.events[id]
+}
+
+// Target get target status id by current status and event ids
+func (p *CompiledNamespace) Target(status, event int) (int, bool) {
+	if status < 0 || status >= len(p.statuses) ||
+		event < 0 || event >= len(p.events) {
+		return NoTarget, false
+	}
+	target := p.table[status][event]
+	return target, target != NoTarget
+}
+
+// Output get the Moore output of a status, empty if not set or id is out of range
+func (p *CompiledNamespace) Output(status int) string {
+	if status < 0 || status >= len(p.statuses) {
+		return ""
+	}
+	return p.outputs[status]
+}
+
+// TransitionOutput get the Mealy output of the transition by current status and event ids, empty if not set
+func (p *CompiledNamespace) TransitionOutput(status, event int) string {
+	if _, ok := p.Target(status, event); !ok || p.mealy == nil {
+		return ""
+	}
+	return p.mealy[status][event]
+}
+
+// NewMachine new a compiled machine starting at status
+func (p *CompiledNamespace) NewMachine(status int) (*CompiledMachine, error) {
+	if status < 0 || status >= len(p.statuses) {
+		return nil, ErrUnknownStatus
+	}
+	return &CompiledMachine{namespace: p, current: status}, nil
+}
+
+// CompiledMachine a machine firing events by integer ids, it is not safe for concurrent use.
+// It moves between statuses with their outputs: namespaces with guards or assignments are not compiled
+type CompiledMachine struct {
+	namespace *CompiledNamespace
+	current   int
+}
+
+// Namespace get the compiled namespace of the machine
+func (p *CompiledMachine) Namespace() *CompiledNamespace {
+	return p.namespace
+}
+
+// Current get current status id
+func (p *CompiledMachine) Current() int {
+	return p.current
+}
+
+// CurrentName get current status name
+func (p *CompiledMachine) CurrentName() string {
+	return p.namespace.statuses[p.current]
+}
+
+// Can judge the event can be fired in current status
+func (p *CompiledMachine) Can(event int) bool {
+	_, ok := p.namespace.Target(p.current, event)
+	return ok
+}
+
+// Fire fire an event and move to the target status
+func (p *CompiledMachine) Fire(event int) error {
+	if event < 0 || event >= len(p.namespace.events) {
+		return ErrUnknownEvent
+	}
+	target := p.namespace.table[p.current][event]
+	if target == NoTarget {
+		return ErrTransactionNotFound
+	}
+	p.current = target
+	return nil
+}
+
+// Output get the Moore output of current status, empty if not set
+func (p *CompiledMachine) Output() string {
+	return p.namespace.outputs[p.current]
+}
+
+// Step fire an input event and get its output,
+// the Mealy output of the transition if it has one, otherwise the Moore output of the new status
+func (p *CompiledMachine) Step(event int) (string, error) {
+	from := p.current
+	if err := p.Fire(event); err != nil {
+		return "", err
+	}
+	if output := p.namespace.TransitionOutput(from, event); output != "" {
+		return output, nil
+	}
+	return p.Output(), nil
+}
@@ -0,0 +1,246 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompileWildcardSelfTransition(t *testing.T) {
+	c := compile("order", []*Transaction{
+		{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"},
+		{Namespace: "order", CurrentStatus: AnyStatus, Event: "comment", Kind: TransitionInternal},
+		{Namespace: "order", CurrentStatus: AnyStatus, Event: "touch", Kind: TransitionExternal, TargetStatus: AnyStatus},
+	}, nil)
+
+	if _, ok := c.StatusID(AnyStatus); ok {
+		t.Fatal("the wildcard is a compiled status")
+	}
+	if n := c.NumStatuses(); n != 2 {
+		t.Fatalf("NumStatuses() = %d, want 2", n)
+	}
+	for _, status := range []string{"created", "paid"} {
+		id, ok := c.StatusID(status)
+		if !ok {
+			t.Fatalf("StatusID(%s) not found", status)
+		}
+		for _, event := range []string{"comment", "touch"} {
+			eventID, _ := c.EventID(event)
+			if target, ok := c.Target(id, eventID); !ok || target != id {
+				t.Fatalf("Target(%s, %s) = %s, want %s", status, event, c.StatusName(target), status)
+			}
+		}
+	}
+}
+
+func TestCompileRejectGuards(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid",
+		Guard: "payload.amount > 0"})
+
+	if _, err := repo.Compile("order"); !errors.Is(err, ErrNotCompilable) {
+		t.Fatalf("Compile() = %v, want %v", err, ErrNotCompilable)
+	}
+}
+
+func TestCompileOutputs(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	repo.Add(&Transaction{Namespace: "door", CurrentStatus: "closed", Event: "open", TargetStatus: "opened", Output: "creak"})
+	repo.Add(&Transaction{Namespace: "door", CurrentStatus: "opened", Event: "close", TargetStatus: "closed"})
+	repo.Table().SetOutput("door", "closed", "dark")
+
+	c, err := repo.Compile("door")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed, _ := c.StatusID("closed")
+	open, _ := c.EventID("open")
+	closeEvent, _ := c.EventID("close")
+	m, err := c.NewMachine(closed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Output(); got != "dark" {
+		t.Fatalf("Output() = %q, want dark", got)
+	}
+	outputs := []string{}
+	for _, event := range []int{open, closeEvent} {
+		output, err := m.Step(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, output)
+	}
+	if outputs[0] != "creak" || outputs[1] != "dark" {
+		t.Fatalf("Step() outputs = %v, want creak and dark", outputs)
+	}
+}
+
+func TestCompileRejectAssignments(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	if err := repo.Table().SetVariable(&Variable{Namespace: "counter", Name: "n", Type: VariableInt}); err != nil {
+		t.Fatal(err)
+	}
+	repo.Add(&Transaction{Namespace: "counter", CurrentStatus: "on", Event: "tick", Kind: TransitionInternal,
+		Assignments: []*Assignment{{Variable: "n", Op: AssignAdd, Value: 1}}})
+
+	if _, err := repo.Compile("counter"); !errors.Is(err, ErrNotCompilable) {
+		t.Fatalf("Compile() = %v, want %v", err, ErrNotCompilable)
+	}
+}
+
+// addOrderTransactions add created -pay-> paid -ship-> shipped and created -cancel-> canceled to the repo
+func addOrderTransactions(repo *DefaultRepo) {
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"})
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "created", Event: "cancel", TargetStatus: "canceled"})
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "paid", Event: "ship", TargetStatus: "shipped"})
+}
+
+func TestCompileIntern(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	addOrderTransactions(repo)
+
+	if _, err := repo.Compile("missing"); !errors.Is(err, ErrNamespaceNotFound) {
+		t.Fatalf("Compile(missing) = %v, want %v", err, ErrNamespaceNotFound)
+	}
+	c, err := repo.Compile("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ids are interned in order of names
+	statuses := []string{"canceled", "created", "paid", "shipped"}
+	events := []string{"cancel", "pay", "ship"}
+	if c.NumStatuses() != len(statuses) || c.NumEvents() != len(events) {
+		t.Fatalf("compiled %d statuses and %d events, want %d and %d",
+			c.NumStatuses(), c.NumEvents(), len(statuses), len(events))
+	}
+	for want, name := range statuses {
+		if id, ok := c.StatusID(name); !ok || id != want || c.StatusName(id) != name {
+			t.Errorf("StatusID(%s) = %d, %t, want %d", name, id, ok, want)
+		}
+	}
+	for want, name := range events {
+		if id, ok := c.EventID(name); !ok || id != want || c.EventName(id) != name {
+			t.Errorf("EventID(%s) = %d, %t, want %d", name, id, ok, want)
+		}
+	}
+	if _, ok := c.StatusID("missing"); ok {
+		t.Error("StatusID(missing) is found")
+	}
+	if _, ok := c.EventID("missing"); ok {
+		t.Error("EventID(missing) is found")
+	}
+	for _, id := range []int{-1, len(statuses)} {
+		if name := c.StatusName(id); name != "" {
+			t.Errorf("StatusName(%d) = %q, want empty", id, name)
+		}
+		if _, ok := c.Target(id, 0); ok {
+			t.Errorf("Target(%d, 0) is found", id)
+		}
+	}
+	if name := c.EventName(len(events)); name != "" {
+		t.Errorf("EventName(%d) = %q, want empty", len(events), name)
+	}
+
+	// the compiled namespace is frozen
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "shipped", Event: "return", TargetStatus: "returned"})
+	if _, ok := c.EventID("return"); ok || c.NumStatuses() != len(statuses) {
+		t.Fatal("the compiled namespace changes with the repo")
+	}
+}
+
+func TestCompiledMachineFire(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	addOrderTransactions(repo)
+	c, err := repo.Compile("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, _ := c.StatusID("created")
+	pay, _ := c.EventID("pay")
+	ship, _ := c.EventID("ship")
+	cancel, _ := c.EventID("cancel")
+
+	if _, err = c.NewMachine(c.NumStatuses()); !errors.Is(err, ErrUnknownStatus) {
+		t.Fatalf("NewMachine(%d) = %v, want %v", c.NumStatuses(), err, ErrUnknownStatus)
+	}
+	m, err := c.NewMachine(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Namespace() != c || !m.Can(pay) || m.Can(ship) {
+		t.Fatalf("machine at created: Can(pay) = %t, Can(ship) = %t", m.Can(pay), m.Can(ship))
+	}
+	if err = m.Fire(ship); !errors.Is(err, ErrTransactionNotFound) || m.CurrentName() != "created" {
+		t.Fatalf("Fire(ship) = %v at %s, want %v at created", err, m.CurrentName(), ErrTransactionNotFound)
+	}
+	if err = m.Fire(c.NumEvents()); !errors.Is(err, ErrUnknownEvent) {
+		t.Fatalf("Fire(%d) = %v, want %v", c.NumEvents(), err, ErrUnknownEvent)
+	}
+	for _, event := range []int{pay, ship} {
+		if err = m.Fire(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.CurrentName() != "shipped" || m.Can(cancel) {
+		t.Fatalf("machine at %s, want shipped without events", m.CurrentName())
+	}
+	if id, _ := c.StatusID("shipped"); m.Current() != id {
+		t.Fatalf("Current() = %d, want %d", m.Current(), id)
+	}
+}
+
+// TestCompiledMachineMatchesMachine fire every event in every status with both machines
+func TestCompiledMachineMatchesMachine(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	addOrderTransactions(repo)
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: AnyStatus, Event: "note", Kind: TransitionInternal})
+	c, err := repo.Compile("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for status := 0; status < c.NumStatuses(); status++ {
+		for event := 0; event < c.NumEvents(); event++ {
+			compiled, _ := c.NewMachine(status)
+			m, err := NewRepoMachine(repo, "order", c.StatusName(status))
+			if err != nil {
+				t.Fatal(err)
+			}
+			compiledErr, err := compiled.Fire(event), m.Fire(c.EventName(event))
+			if (compiledErr == nil) != (err == nil) || compiled.CurrentName() != m.Current() {
+				t.Errorf("%s --%s--> compiled %s (%v), machine %s (%v)", c.StatusName(status), c.EventName(event),
+					compiled.CurrentName(), compiledErr, m.Current(), err)
+			}
+		}
+	}
+}
@@ -18,33 +18,335 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package fsm
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
 	"github.com/iTrellis/config"
 )
 
-// NewTransactionFromConfig new transactions from config file
+// NewTransactionFromConfig new transactions from config file,
+// supported: .json, .xml, .yaml, .yml
 func NewTransactionFromConfig(filepath string) error {
+	if readerTypeOf(filepath) == config.ReaderTypeXML {
+		return newTransactionsFromXML(filepath)
+	}
+
 	cfg, err := config.NewConfigOptions(config.OptionFile(filepath))
 	if err != nil {
+		getLogger().Error("fsm: config not loaded", "file", filepath, "error", err)
 		return err
 	}
+	getLogger().Info("fsm: config loaded", "file", filepath)
 	return NewTransactions(cfg)
 }
 
-// NewTransactions new transactions
+// NewTransactions new transactions and status outputs
 func NewTransactions(cfg config.Config) (err error) {
-	f := New()
+	f := Default()
+	// variables are declared before the transactions reading them
+	addVariables(f, readVariables(cfg))
+	for _, t := range readTransactions(cfg) {
+		f.Add(t.Transaction)
+	}
+	addOutputs(f, readOutputs(cfg))
+	return
+}
+
+// addOutputs set the valid status outputs in the repo, invalid ones are logged and dropped
+func addOutputs(f *DefaultRepo, outputs []*configOutput) {
+	for _, o := range outputs {
+		if err := o.valid(); err != nil {
+			getLogger().Warn("fsm: status output rejected", "namespace", o.Namespace, "status", o.Status, "error", err)
+			continue
+		}
+		f.Table().SetOutput(o.Namespace, o.Status, o.Output)
+	}
+}
+
+// addVariables set the valid variables in the repo, invalid ones are logged and dropped
+func addVariables(f *DefaultRepo, variables []*configVariable) {
+	for _, v := range variables {
+		if err := f.Table().SetVariable(v.Variable); err != nil {
+			getLogger().Warn("fsm: variable rejected", "namespace", v.Namespace, "variable", v.Name, "error", err)
+		}
+	}
+}
+
+func newTransactionsFromXML(filepath string) error {
+	data, err := ioutil.ReadFile(filepath)
+	var items []*configTransaction
+	var outputs []*configOutput
+	var variables []*configVariable
+	if err == nil {
+		items, outputs, variables, err = readXML(data)
+	}
+	if err != nil {
+		getLogger().Error("fsm: config not loaded", "file", filepath, "error", err)
+		return err
+	}
+	getLogger().Info("fsm: config loaded", "file", filepath)
+	f := Default()
+	addVariables(f, variables)
+	for _, t := range items {
+		f.Add(t.Transaction)
+	}
+	addOutputs(f, outputs)
+	return nil
+}
+
+// ParseTransactions parse transactions from config and validate them all,
+// the error is a *ConfigError pointing at the first invalid key
+func ParseTransactions(cfg config.Config) ([]*Transaction, error) {
+	return validateTransactions(readTransactions(cfg))
+}
+
+// ParseOutputs parse status outputs from config and validate them all,
+// the error is a *ConfigError pointing at the first invalid key
+func ParseOutputs(cfg config.Config) ([]*StatusOutput[string], error) {
+	return validateOutputs(readOutputs(cfg))
+}
+
+func validateOutputs(items []*configOutput) ([]*StatusOutput[string], error) {
+	outputs := make([]*StatusOutput[string], 0, len(items))
+	for _, item := range items {
+		if e := item.valid(); e != nil {
+			return nil, &ConfigError{Key: item.key, Err: e}
+		}
+		outputs = append(outputs, item.StatusOutput)
+	}
+	return outputs, nil
+}
+
+// ParseVariables parse variables from config and validate them all,
+// the error is a *ConfigError pointing at the first invalid key
+func ParseVariables(cfg config.Config) ([]*Variable, error) {
+	return validateVariables(readVariables(cfg))
+}
+
+func validateVariables(items []*configVariable) ([]*Variable, error) {
+	vs := make([]*Variable, 0, len(items))
+	for _, item := range items {
+		if e := item.valid(); e != nil {
+			return nil, &ConfigError{Key: item.key, Err: e}
+		}
+		vs = append(vs, item.Variable)
+	}
+	return vs, nil
+}
+
+// validateVariableUses check assignments and guards of transactions by the declared variables,
+// and convert values of assignments to the types of variables
+func validateVariableUses(items []*configTransaction, vs []*Variable) error {
+	declared := make(map[string]*Variable, len(vs))
+	types := make(map[string]map[string]VariableType)
+	for _, v := range vs {
+		declared[v.Namespace+"::"+v.Name] = v
+		if types[v.Namespace] == nil {
+			types[v.Namespace] = make(map[string]VariableType)
+		}
+		types[v.Namespace][v.Name] = v.Type
+	}
+	for _, item := range items {
+		if item.Guard != "" {
+			spaceTypes := types[item.Namespace]
+			if spaceTypes == nil {
+				spaceTypes = make(map[string]VariableType)
+			}
+			if _, e := CompileGuard(item.Guard, spaceTypes); e != nil {
+				return &ConfigError{Key: item.key + ".guard", Err: e}
+			}
+		}
+		assigned := make(map[string]bool, len(item.Assignments))
+		for _, a := range item.Assignments {
+			key := item.key + ".assign." + a.Variable
+			if assigned[a.Variable] {
+				return &ConfigError{Key: key, Err: ErrInvalidAssignment}
+			}
+			assigned[a.Variable] = true
+			if e := a.check(declared[item.Namespace+"::"+a.Variable]); e != nil {
+				return &ConfigError{Key: key, Err: e}
+			}
+		}
+	}
+	return nil
+}
+
+func validateTransactions(items []*configTransaction) ([]*Transaction, error) {
+	if len(items) == 0 {
+		return nil, ErrEmptyDefinition
+	}
+
+	var ts []*Transaction
+	seen := make(map[string]*Transaction)
+	for _, item := range items {
+		if e := item.valid(); e != nil {
+			key := item.key
+			if errors.Is(e, ErrInvalidExpr) {
+				key += ".guard"
+			}
+			return nil, &ConfigError{Key: key, Err: e}
+		}
+		// items are expanded per current status when read, and their assignments are converted in place later
+		t := item.Transaction
+		if t.IsSelf() {
+			t.TargetStatus = t.CurrentStatus
+		}
+		seenKey := t.Namespace + "::" + t.CurrentStatus + "::" + t.Event
+		if prev, ok := seen[seenKey]; ok &&
+			(prev.TargetStatus != t.TargetStatus || prev.Kind != t.Kind ||
+				prev.Output != t.Output || prev.Guard != t.Guard) {
+			return nil, &ConfigError{Key: item.key, Err: ErrDuplicateTransaction}
+		}
+		seen[seenKey] = t
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+type configTransaction struct {
+	*Transaction
+	// key the full key of the transaction in config: fsm.<namespace>.<key>
+	key string
+}
+
+// readTransactions read all transactions from config in key order, without validation,
+// a transaction of a current status list is read as a transaction per status
+func readTransactions(cfg config.Config) []*configTransaction {
+	var items []*configTransaction
 	fsmConfig := cfg.GetValuesConfig("fsm")
-	for _, namespace := range fsmConfig.GetKeys() {
+	for _, namespace := range sortedKeys(fsmConfig) {
 		nsConfig := fsmConfig.GetValuesConfig(namespace)
-		for _, key := range nsConfig.GetKeys() {
+		for _, key := range sortedKeys(nsConfig) {
 			obj := nsConfig.GetValuesConfig(key)
-			f.Add(&Transaction{
-				Namespace:     namespace,
-				CurrentStatus: obj.GetString("current"),
-				Event:         obj.GetString("event"),
-				TargetStatus:  obj.GetString("target"),
+			// current is a status, "*" for any status, or a list of statuses
+			currents := []string{obj.GetString("current")}
+			if currents[0] == "" {
+				if list := obj.GetStringList("current"); len(list) > 0 {
+					currents = list
+				}
+			}
+			for _, current := range currents {
+				items = append(items, &configTransaction{
+					Transaction: &Transaction{
+						Namespace:     namespace,
+						CurrentStatus: current,
+						Event:         obj.GetString("event"),
+						TargetStatus:  obj.GetString("target"),
+						Kind:          TransitionKind(obj.GetString("kind")),
+						Output:        configScalar(obj, "output"),
+						Assignments:   readAssignments(obj.GetValuesConfig("assign")),
+						Guard:         obj.GetString("guard"),
+					},
+					key: strings.Join([]string{"fsm", namespace, key}, "."),
+				})
+			}
+		}
+	}
+	return items
+}
+
+type configOutput struct {
+	*StatusOutput[string]
+	// key the full key of the output in config: outputs.<namespace>.<status>
+	key string
+}
+
+// readOutputs read all status outputs from config in key order, without validation
+func readOutputs(cfg config.Config) []*configOutput {
+	var items []*configOutput
+	outputsConfig := cfg.GetValuesConfig("outputs")
+	for _, namespace := range sortedKeys(outputsConfig) {
+		nsConfig := outputsConfig.GetValuesConfig(namespace)
+		for _, status := range sortedKeys(nsConfig) {
+			items = append(items, &configOutput{
+				StatusOutput: &StatusOutput[string]{
+					Namespace: namespace,
+					Status:    status,
+					Output:    configScalar(nsConfig, status),
+				},
+				key: strings.Join([]string{"outputs", namespace, status}, "."),
 			})
 		}
 	}
-	return
+	return items
+}
+
+// readAssignments read assignments in the form of <variable>: {<op>: <value>} in order
+func readAssignments(cfg config.Config) []*Assignment {
+	var as []*Assignment
+	for _, name := range sortedKeys(cfg) {
+		opsConfig := cfg.GetValuesConfig(name)
+		for _, op := range sortedKeys(opsConfig) {
+			as = append(as, &Assignment{Variable: name, Op: AssignOp(op), Value: opsConfig.GetInterface(op)})
+		}
+	}
+	return as
+}
+
+type configVariable struct {
+	*Variable
+	// key the full key of the variable in config: variables.<namespace>.<name>
+	key string
+}
+
+// readVariables read all variables from config in key order, without validation
+func readVariables(cfg config.Config) []*configVariable {
+	var items []*configVariable
+	varsConfig := cfg.GetValuesConfig("variables")
+	for _, namespace := range sortedKeys(varsConfig) {
+		nsConfig := varsConfig.GetValuesConfig(namespace)
+		for _, name := range sortedKeys(nsConfig) {
+			obj := nsConfig.GetValuesConfig(name)
+			items = append(items, &configVariable{
+				Variable: &Variable{
+					Namespace: namespace,
+					Name:      name,
+					Type:      VariableType(obj.GetString("type")),
+					Default:   obj.GetInterface("default"),
+				},
+				key: strings.Join([]string{"variables", namespace, name}, "."),
+			})
+		}
+	}
+	return items
+}
+
+// configScalar get a scalar value as string, outputs like 0 and 1 are often not quoted
+func configScalar(cfg config.Config, key string) string {
+	switch v := cfg.GetInterface(key).(type) {
+	case string:
+		return v
+	case bool, int, int64, uint64, float64, json.Number:
+		return fmt.Sprint(v)
+	default:
+		return ""
+	}
+}
+
+func sortedKeys(cfg config.Config) []string {
+	if cfg == nil {
+		return nil
+	}
+	keys := cfg.GetKeys()
+	sort.Strings(keys)
+	return keys
+}
+
+// readerTypeOf judge the config reader type by file's suffix
+func readerTypeOf(filepath string) config.ReaderType {
+	switch {
+	case strings.HasSuffix(filepath, ".json"):
+		return config.ReaderTypeJSON
+	case strings.HasSuffix(filepath, ".xml"):
+		return config.ReaderTypeXML
+	case strings.HasSuffix(filepath, ".yml"),
+		strings.HasSuffix(filepath, ".yaml"):
+		return config.ReaderTypeYAML
+	default:
+		return config.ReaderTypeSuffix
+	}
 }
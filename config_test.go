@@ -0,0 +1,231 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var orderTransactions = []*Transaction{
+	{Namespace: "order", CurrentStatus: "created", Event: "cancel", TargetStatus: "canceled"},
+	{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"},
+	{Namespace: "order", CurrentStatus: "paid", Event: "ship", TargetStatus: "shipped"},
+	{Namespace: "order", CurrentStatus: "shipped", Event: "receive", TargetStatus: "completed"},
+}
+
+func TestNewTransactionFromConfig(t *testing.T) {
+	for _, file := range []string{"sample_order.yaml", "sample_order.json", "sample_order.xml"} {
+		t.Run(file, func(t *testing.T) {
+			repo := Default()
+			repo.Remove()
+			defer repo.Remove()
+
+			if err := NewTransactionFromConfig(file); err != nil {
+				t.Fatalf("NewTransactionFromConfig(%q) = %v", file, err)
+			}
+			if got := repo.GetNamespaces(); !reflect.DeepEqual(got, []string{"order"}) {
+				t.Fatalf("namespaces = %v, want [order]", got)
+			}
+			assertTransactions(t, repo.GetTransactions("order"), orderTransactions)
+		})
+	}
+}
+
+func TestNewTransactionFromConfigSample(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+
+	if err := NewTransactionFromConfig("sample.yaml"); err != nil {
+		t.Fatalf("NewTransactionFromConfig = %v", err)
+	}
+	assertTransactions(t, repo.GetTransactions("namespace3"), []*Transaction{
+		{Namespace: "namespace3", CurrentStatus: "status1", Event: "event1", TargetStatus: "target1"},
+		{Namespace: "namespace3", CurrentStatus: "status1", Event: "event2", TargetStatus: "target2"},
+	})
+	assertTransactions(t, repo.GetTransactions("namespace4"), []*Transaction{
+		{Namespace: "namespace4", CurrentStatus: "status1", Event: "event1", TargetStatus: "target1"},
+	})
+}
+
+func TestLoadFile(t *testing.T) {
+	for _, file := range []string{"sample_order.yaml", "sample_order.json", "sample_order.xml"} {
+		t.Run(file, func(t *testing.T) {
+			repo := Default()
+			repo.Remove()
+			defer repo.Remove()
+
+			if err := LoadFile(file); err != nil {
+				t.Fatalf("LoadFile(%q) = %v", file, err)
+			}
+			assertTransactions(t, repo.GetTransactions("order"), orderTransactions)
+		})
+	}
+}
+
+func assertTransactions(t *testing.T, got, want []*Transaction) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d transactions, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Namespace != want[i].Namespace || got[i].CurrentStatus != want[i].CurrentStatus ||
+			got[i].Event != want[i].Event || got[i].TargetStatus != want[i].TargetStatus {
+			t.Errorf("transaction %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// kindDefinition a yaml definition of lists of current statuses, a wildcard and self transitions
+const kindDefinition = `fsm:
+  order:
+    pay:
+      current: created
+      event: pay
+      target: paid
+    ship:
+      current: paid
+      event: ship
+      target: shipped
+    cancel:
+      current: [created, paid]
+      event: cancel
+      target: canceled
+    note:
+      current: "*"
+      event: note
+      kind: internal
+    touch:
+      current: paid
+      event: touch
+      kind: external
+`
+
+// assertKindTransactions check the transactions of kindDefinition are in the repo
+func assertKindTransactions(t *testing.T, repo *DefaultRepo) {
+	t.Helper()
+	for _, want := range []*Transaction{
+		{CurrentStatus: "created", Event: "cancel", TargetStatus: "canceled"},
+		{CurrentStatus: "paid", Event: "cancel", TargetStatus: "canceled"},
+		{CurrentStatus: "shipped", Event: "note", TargetStatus: "shipped", Kind: TransitionInternal},
+		{CurrentStatus: "paid", Event: "touch", TargetStatus: "paid", Kind: TransitionExternal},
+	} {
+		got := repo.GetTargetTranstion("order", want.CurrentStatus, want.Event)
+		if got == nil || got.TargetStatus != want.TargetStatus || got.Kind != want.Kind {
+			t.Errorf("%s --%s--> %+v, want %s (%s)", want.CurrentStatus, want.Event, got, want.TargetStatus, want.Kind)
+		}
+	}
+	if got := repo.GetTargetTranstion("order", "shipped", "cancel"); got != nil {
+		t.Errorf("shipped --cancel--> %+v, want nil", got)
+	}
+}
+
+func TestLoadCurrents(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "order.yaml")
+	if err := os.WriteFile(file, []byte(kindDefinition), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaders := map[string]func() error{
+		"NewTransactionFromConfig": func() error { return NewTransactionFromConfig(file) },
+		"LoadYAML":                 func() error { return LoadYAML(strings.NewReader(kindDefinition)) },
+	}
+	for name, load := range loaders {
+		t.Run(name, func(t *testing.T) {
+			repo := Default()
+			repo.Remove()
+			defer repo.Remove()
+			if err := load(); err != nil {
+				t.Fatal(err)
+			}
+			assertKindTransactions(t, repo)
+		})
+	}
+}
+
+func TestExportKinds(t *testing.T) {
+	repo := Default()
+	defer repo.Remove()
+	load := func(t *testing.T, r io.Reader, format Format) {
+		t.Helper()
+		repo.Remove()
+		if err := Load(r, format); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []Format{FormatYAML, FormatJSON, FormatXML} {
+		t.Run(string(format), func(t *testing.T) {
+			load(t, strings.NewReader(kindDefinition), FormatYAML)
+			var buf bytes.Buffer
+			if err := Export(&buf, format); err != nil {
+				t.Fatal(err)
+			}
+			for _, kind := range []TransitionKind{TransitionInternal, TransitionExternal} {
+				if !strings.Contains(buf.String(), string(kind)) {
+					t.Fatalf("export does not contain %s:\n%s", kind, buf.String())
+				}
+			}
+			load(t, &buf, format)
+			assertKindTransactions(t, repo)
+		})
+	}
+
+	load(t, strings.NewReader(kindDefinition), FormatYAML)
+	t.Run("dot", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := Render(&buf, DiagramDOT, "order"); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			`"*" -> "*" [label="note (internal)", style=dashed];`,
+			`"paid" -> "paid" [label="touch (external)"];`,
+		} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("dot does not contain %s:\n%s", want, buf.String())
+			}
+		}
+	})
+
+	t.Run("scxml", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := ExportSCXML(&buf, "order"); err != nil {
+			t.Fatal(err)
+		}
+		ts, _, err := ReadSCXML(strings.NewReader(buf.String()), "order")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// internal transitions are targetless, external ones target their status and read back as normal
+		kinds := make(map[string]*Transaction)
+		for _, tr := range ts {
+			kinds[tr.CurrentStatus+" "+tr.Event] = tr
+		}
+		if tr := kinds["shipped note"]; tr == nil || tr.Kind != TransitionInternal {
+			t.Errorf("shipped note = %+v, want internal", tr)
+		}
+		if tr := kinds["paid touch"]; tr == nil || tr.Kind != TransitionNormal || tr.TargetStatus != "paid" {
+			t.Errorf("paid touch = %+v, want paid", tr)
+		}
+	})
+}
@@ -0,0 +1,578 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iTrellis/config"
+	"gopkg.in/yaml.v2"
+)
+
+// Format the format of definition files
+type Format string
+
+// supported formats
+const (
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+	FormatXML  Format = "xml"
+)
+
+// FormatOf judge the format by file's suffix
+func FormatOf(filepath string) (Format, error) {
+	switch readerTypeOf(filepath) {
+	case config.ReaderTypeJSON:
+		return FormatJSON, nil
+	case config.ReaderTypeXML:
+		return FormatXML, nil
+	case config.ReaderTypeYAML:
+		return FormatYAML, nil
+	default:
+		return "", ErrUnsupportedFormat
+	}
+}
+
+// LoadYAML load a yaml definition into the repo
+func LoadYAML(r io.Reader) error {
+	return Load(r, FormatYAML)
+}
+
+// LoadJSON load a json definition into the repo
+func LoadJSON(r io.Reader) error {
+	return Load(r, FormatJSON)
+}
+
+// LoadXML load a xml definition into the repo
+func LoadXML(r io.Reader) error {
+	return Load(r, FormatXML)
+}
+
+// Definition the transactions, status outputs and variables of a definition
+type Definition struct {
+	Transactions []*Transaction
+	Outputs      []*StatusOutput[string]
+	Variables    []*Variable
+}
+
+// Load load a definition into the repo, nothing is added if anything in the definition is invalid
+func Load(r io.Reader, format Format) error {
+	def, err := ReadDefinition(r, format)
+	if err == nil {
+		err = Default().ReplaceDefinition(nil, def)
+	}
+	return loaded("", def, err)
+}
+
+// LoadFile load a definition file into the repo by its suffix,
+// nothing is added if anything in the definition is invalid
+func LoadFile(filepath string) error {
+	def, err := ReadDefinitionFile(filepath)
+	if err == nil {
+		err = Default().ReplaceDefinition(nil, def)
+	}
+	return loaded(filepath, def, err)
+}
+
+// loaded log the result of loading a definition
+func loaded(filepath string, def *Definition, err error) error {
+	if err != nil {
+		getLogger().Error("fsm: definition not loaded", "file", filepath, "error", err)
+		return err
+	}
+	getLogger().Info("fsm: definition loaded", "file", filepath, "namespaces", def.namespaces(),
+		"transactions", len(def.Transactions), "outputs", len(def.Outputs), "variables", len(def.Variables))
+	return nil
+}
+
+// ParseDefinitionFile parse and validate all transactions of a definition file by its suffix
+func ParseDefinitionFile(filepath string) ([]*Transaction, error) {
+	def, err := ReadDefinitionFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return def.Transactions, nil
+}
+
+// ParseDefinition parse and validate all transactions of a definition
+func ParseDefinition(r io.Reader, format Format) ([]*Transaction, error) {
+	def, err := ReadDefinition(r, format)
+	if err != nil {
+		return nil, err
+	}
+	return def.Transactions, nil
+}
+
+// ReadDefinitionFile parse and validate all transactions and outputs of a definition file by its suffix
+func ReadDefinitionFile(filepath string) (*Definition, error) {
+	format, err := FormatOf(filepath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return parseDefinition(format, data)
+}
+
+// ReadDefinition parse and validate all transactions and outputs of a definition
+func ReadDefinition(r io.Reader, format Format) (*Definition, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseDefinition(format, data)
+}
+
+func parseDefinition(format Format, data []byte) (*Definition, error) {
+	var rt config.ReaderType
+	switch format {
+	case FormatYAML:
+		rt = config.ReaderTypeYAML
+	case FormatJSON:
+		rt = config.ReaderTypeJSON
+	case FormatXML:
+		items, outputItems, varItems, err := readXML(data)
+		if err != nil {
+			return nil, err
+		}
+		return validateDefinition(items, outputItems, varItems)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+
+	cfg, err := config.NewConfigOptions(config.OptionString(rt, string(data)))
+	if err != nil {
+		return nil, err
+	}
+	return validateDefinition(readTransactions(cfg), readOutputs(cfg), readVariables(cfg))
+}
+
+func validateDefinition(items []*configTransaction, outputItems []*configOutput, varItems []*configVariable) (*Definition, error) {
+	ts, err := validateTransactions(items)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := validateOutputs(outputItems)
+	if err != nil {
+		return nil, err
+	}
+	vs, err := validateVariables(varItems)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateVariableUses(items, vs); err != nil {
+		return nil, err
+	}
+	return &Definition{Transactions: ts, Outputs: outputs, Variables: vs}, nil
+}
+
+// ExportYAML export all namespaces of the repo as a yaml definition
+func ExportYAML(w io.Writer) error {
+	return Export(w, FormatYAML)
+}
+
+// ExportJSON export all namespaces of the repo as a json definition
+func ExportJSON(w io.Writer) error {
+	return Export(w, FormatJSON)
+}
+
+// ExportXML export all namespaces of the repo as a xml definition
+func ExportXML(w io.Writer) error {
+	return Export(w, FormatXML)
+}
+
+// Export export namespaces of the repo as a definition, all namespaces if none is given.
+// Transactions are ordered by current status and event, and named t1, t2, ... in each namespace,
+// so the same transactions always export the same output, and loading it gives them back.
+// Names which can not be loaded back are rejected with a *ConfigError.
+func Export(w io.Writer, format Format, namespaces ...string) error {
+	repo := Default()
+	if len(namespaces) == 0 {
+		namespaces = repo.GetNamespaces()
+	} else {
+		namespaces = uniqueStrings(namespaces)
+	}
+
+	spaces := make(map[string][]*Transaction, len(namespaces))
+	outputs := make(map[string]map[string]string, len(namespaces))
+	variables := make(map[string][]*Variable, len(namespaces))
+	for _, namespace := range namespaces {
+		ts := repo.GetTransactions(namespace)
+		if len(ts) == 0 {
+			return &ConfigError{Key: "fsm." + namespace, Err: ErrNamespaceNotFound}
+		}
+		// the config reader can not read double quotes, so string literals of guards are written in single quotes
+		for _, t := range ts {
+			if guard, ok := singleQuoted(t.Guard); ok {
+				t.Guard = guard
+			}
+		}
+		if err := checkExportable(namespace, ts); err != nil {
+			return err
+		}
+		spaces[namespace] = ts
+		if spaceOutputs := repo.Table().GetOutputs(namespace); len(spaceOutputs) > 0 {
+			if err := checkOutputsExportable(namespace, spaceOutputs); err != nil {
+				return err
+			}
+			outputs[namespace] = spaceOutputs
+		}
+		vs := repo.Table().GetVariables(namespace)
+		if err := checkVariablesExportable(namespace, vs); err != nil {
+			return err
+		}
+		variables[namespace] = vs
+	}
+	return encodeDefinition(w, format, namespaces, spaces, outputs, variables)
+}
+
+// checkExportable check names survive the config reader:
+// keys are split by '.', "${...}" values are replaced, and quotes confuse the json comment stripper
+func checkExportable(namespace string, ts []*Transaction) error {
+	if strings.Contains(namespace, ".") || !exportableValue(namespace) {
+		return &ConfigError{Key: "fsm." + namespace, Err: ErrNotExportable}
+	}
+	for i, t := range ts {
+		if !exportableValue(t.CurrentStatus) ||
+			!exportableValue(t.Event) ||
+			!exportableValue(t.TargetStatus) ||
+			!exportableValue(t.Output) ||
+			!exportableValue(t.Guard) {
+			return &ConfigError{Key: "fsm." + namespace + "." + definitionKey(i), Err: ErrNotExportable}
+		}
+		for _, a := range t.Assignments {
+			if !exportableName(a.Variable) || !exportableValue(fmt.Sprint(a.Value)) {
+				return &ConfigError{Key: "fsm." + namespace + "." + definitionKey(i) + ".assign." + a.Variable, Err: ErrNotExportable}
+			}
+		}
+	}
+	return nil
+}
+
+// checkVariablesExportable check names of variables survive as keys and defaults as values of the config reader
+func checkVariablesExportable(namespace string, vs []*Variable) error {
+	for _, v := range vs {
+		if !exportableName(v.Name) || (v.Default != nil && !exportableValue(fmt.Sprint(v.Default))) {
+			return &ConfigError{Key: "variables." + namespace + "." + v.Name, Err: ErrNotExportable}
+		}
+	}
+	return nil
+}
+
+func exportableName(name string) bool {
+	return !strings.Contains(name, ".") && exportableValue(name)
+}
+
+// checkOutputsExportable check statuses survive as keys and outputs as values of the config reader
+func checkOutputsExportable(namespace string, outputs map[string]string) error {
+	for status, output := range outputs {
+		if !exportableName(status) || !exportableValue(output) {
+			return &ConfigError{Key: "outputs." + namespace + "." + status, Err: ErrNotExportable}
+		}
+	}
+	return nil
+}
+
+func exportableValue(v string) bool {
+	return !strings.Contains(v, "${") && !strings.ContainsAny(v, "\"\\")
+}
+
+func uniqueStrings(values []string) []string {
+	set := make(map[string]bool, len(values))
+	var result []string
+	for _, v := range values {
+		if !set[v] {
+			set[v] = true
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// definitionTransaction a transaction in json and yaml definitions
+type definitionTransaction struct {
+	Current string         `json:"current" yaml:"current"`
+	Event   string         `json:"event" yaml:"event"`
+	Target  string         `json:"target,omitempty" yaml:"target,omitempty"`
+	Kind    TransitionKind `json:"kind,omitempty" yaml:"kind,omitempty"`
+	Output  string         `json:"output,omitempty" yaml:"output,omitempty"`
+	// Assign operations with values by variable
+	Assign map[string]map[AssignOp]interface{} `json:"assign,omitempty" yaml:"assign,omitempty"`
+	Guard  string                              `json:"guard,omitempty" yaml:"guard,omitempty"`
+}
+
+// definitionVariable a variable in json and yaml definitions
+type definitionVariable struct {
+	Type    VariableType `json:"type" yaml:"type"`
+	Default interface{}  `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+type definition struct {
+	FSM       map[string]map[string]definitionTransaction `json:"fsm" yaml:"fsm"`
+	Outputs   map[string]map[string]string                `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+	Variables map[string]map[string]definitionVariable    `json:"variables,omitempty" yaml:"variables,omitempty"`
+}
+
+type xmlDefinition struct {
+	XMLName    xml.Name       `xml:"fsm"`
+	Namespaces []xmlNamespace `xml:"namespace"`
+}
+
+type xmlNamespace struct {
+	Name         string           `xml:"name,attr"`
+	Transactions []xmlTransaction `xml:"transaction"`
+	Outputs      []xmlOutput      `xml:"output"`
+	Variables    []xmlVariable    `xml:"variable"`
+}
+
+type xmlVariable struct {
+	Name    string       `xml:"name,attr"`
+	Type    VariableType `xml:"type,attr"`
+	Default string       `xml:"default,attr,omitempty"`
+}
+
+type xmlAssignment struct {
+	Variable string   `xml:"variable,attr"`
+	Op       AssignOp `xml:"op,attr"`
+	Value    string   `xml:"value,attr"`
+}
+
+type xmlOutput struct {
+	Status string `xml:"status,attr"`
+	Output string `xml:",chardata"`
+}
+
+type xmlTransaction struct {
+	Key     string `xml:"key,attr,omitempty"`
+	Current string `xml:"current,attr,omitempty"`
+	// Currents a list of current statuses instead of the current attribute
+	Currents []string        `xml:"current,omitempty"`
+	Event    string          `xml:"event,attr"`
+	Target   string          `xml:"target,attr,omitempty"`
+	Kind     TransitionKind  `xml:"kind,attr,omitempty"`
+	Output   string          `xml:"output,attr,omitempty"`
+	Assign   []xmlAssignment `xml:"assign,omitempty"`
+	Guard    string          `xml:"guard,attr,omitempty"`
+}
+
+func encodeDefinition(w io.Writer, format Format, namespaces []string,
+	spaces map[string][]*Transaction, outputs map[string]map[string]string, variables map[string][]*Variable) error {
+	switch format {
+	case FormatXML:
+		def := xmlDefinition{}
+		for _, namespace := range namespaces {
+			ns := xmlNamespace{Name: namespace}
+			for i, t := range spaces[namespace] {
+				ns.Transactions = append(ns.Transactions, xmlTransaction{
+					Key:     definitionKey(i),
+					Current: t.CurrentStatus,
+					Event:   t.Event,
+					Target:  definitionTarget(t),
+					Kind:    t.Kind,
+					Output:  t.Output,
+					Assign:  xmlAssignments(t.Assignments),
+					Guard:   t.Guard,
+				})
+			}
+			for _, status := range sortedStrings(outputs[namespace]) {
+				ns.Outputs = append(ns.Outputs, xmlOutput{Status: status, Output: outputs[namespace][status]})
+			}
+			for _, v := range variables[namespace] {
+				xv := xmlVariable{Name: v.Name, Type: v.Type}
+				if v.Default != nil {
+					xv.Default = fmt.Sprint(v.Default)
+				}
+				ns.Variables = append(ns.Variables, xv)
+			}
+			def.Namespaces = append(def.Namespaces, ns)
+		}
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(def); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	case FormatJSON, FormatYAML:
+	default:
+		return ErrUnsupportedFormat
+	}
+
+	def := definition{FSM: make(map[string]map[string]definitionTransaction)}
+	for _, namespace := range namespaces {
+		ns := make(map[string]definitionTransaction)
+		for i, t := range spaces[namespace] {
+			ns[definitionKey(i)] = definitionTransaction{
+				Current: t.CurrentStatus,
+				Event:   t.Event,
+				Target:  definitionTarget(t),
+				Kind:    t.Kind,
+				Output:  t.Output,
+				Assign:  definitionAssignments(t.Assignments),
+				Guard:   t.Guard,
+			}
+		}
+		def.FSM[namespace] = ns
+		if len(variables[namespace]) > 0 {
+			if def.Variables == nil {
+				def.Variables = make(map[string]map[string]definitionVariable)
+			}
+			nsVars := make(map[string]definitionVariable, len(variables[namespace]))
+			for _, v := range variables[namespace] {
+				nsVars[v.Name] = definitionVariable{Type: v.Type, Default: v.Default}
+			}
+			def.Variables[namespace] = nsVars
+		}
+	}
+	if len(outputs) > 0 {
+		def.Outputs = outputs
+	}
+
+	if format == FormatYAML {
+		return yaml.NewEncoder(w).Encode(def)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(def)
+}
+
+func definitionAssignments(as []*Assignment) map[string]map[AssignOp]interface{} {
+	if len(as) == 0 {
+		return nil
+	}
+	assign := make(map[string]map[AssignOp]interface{}, len(as))
+	for _, a := range as {
+		if assign[a.Variable] == nil {
+			assign[a.Variable] = make(map[AssignOp]interface{})
+		}
+		assign[a.Variable][a.Op] = a.Value
+	}
+	return assign
+}
+
+func xmlAssignments(as []*Assignment) []xmlAssignment {
+	var assign []xmlAssignment
+	for _, a := range as {
+		assign = append(assign, xmlAssignment{Variable: a.Variable, Op: a.Op, Value: fmt.Sprint(a.Value)})
+	}
+	return assign
+}
+
+// definitionTarget the target in exported definitions, it is omitted for internal and external transactions
+func definitionTarget(t *Transaction) string {
+	if t.IsSelf() {
+		return ""
+	}
+	return t.TargetStatus
+}
+
+// definitionKey the key of the i-th transaction of a namespace in exported definitions
+func definitionKey(i int) string {
+	return "t" + strconv.Itoa(i+1)
+}
+
+// readXML read all transactions, status outputs and variables of a xml definition, without validation
+func readXML(data []byte) ([]*configTransaction, []*configOutput, []*configVariable, error) {
+	def := xmlDefinition{}
+	if err := xml.Unmarshal(data, &def); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var items []*configTransaction
+	var outputs []*configOutput
+	var variables []*configVariable
+	for i, ns := range def.Namespaces {
+		if ns.Name == "" {
+			return nil, nil, nil, &ConfigError{Key: fmt.Sprintf("fsm.namespace[%d]", i), Err: ErrInvalidTransaction}
+		}
+		for j, t := range ns.Transactions {
+			key := t.Key
+			if key == "" {
+				key = definitionKey(j)
+			}
+			currents := []string{t.Current}
+			if t.Current == "" && len(t.Currents) > 0 {
+				currents = t.Currents
+			}
+			for _, current := range currents {
+				items = append(items, &configTransaction{
+					Transaction: &Transaction{
+						Namespace:     ns.Name,
+						CurrentStatus: current,
+						Event:         t.Event,
+						TargetStatus:  t.Target,
+						Kind:          t.Kind,
+						Output:        t.Output,
+						Assignments:   readXMLAssignments(t.Assign),
+						Guard:         t.Guard,
+					},
+					key: strings.Join([]string{"fsm", ns.Name, key}, "."),
+				})
+			}
+		}
+		for _, o := range ns.Outputs {
+			outputs = append(outputs, &configOutput{
+				StatusOutput: &StatusOutput[string]{Namespace: ns.Name, Status: o.Status, Output: o.Output},
+				key:          strings.Join([]string{"outputs", ns.Name, o.Status}, "."),
+			})
+		}
+		for _, v := range ns.Variables {
+			variable := &Variable{Namespace: ns.Name, Name: v.Name, Type: v.Type}
+			if v.Default != "" {
+				variable.Default = v.Default
+			}
+			variables = append(variables, &configVariable{
+				Variable: variable,
+				key:      strings.Join([]string{"variables", ns.Name, v.Name}, "."),
+			})
+		}
+	}
+	return items, outputs, variables, nil
+}
+
+// readXMLAssignments read assignments, values are strings converted by types of the declared variables
+func readXMLAssignments(assign []xmlAssignment) []*Assignment {
+	var as []*Assignment
+	for _, a := range assign {
+		as = append(as, &Assignment{Variable: a.Variable, Op: a.Op, Value: a.Value})
+	}
+	return as
+}
+
+func sortedStrings(set map[string]string) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,151 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// roundTripDefinition a definition using every exportable feature
+var roundTripDefinition = &Definition{
+	Transactions: []*Transaction{
+		{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid",
+			Guard: "payload.amount > 0 && state.retries < 3"},
+		{Namespace: "order", CurrentStatus: AnyStatus, Event: "cancel", TargetStatus: "canceled"},
+		{Namespace: "order", CurrentStatus: AnyStatus, Event: "comment", Kind: TransitionInternal},
+		{Namespace: "order", CurrentStatus: "paid", Event: "refresh", Kind: TransitionExternal},
+		{Namespace: "order", CurrentStatus: "paid", Event: "ship", TargetStatus: "shipped", Output: "shipping"},
+		{Namespace: "order", CurrentStatus: "canceled", Event: "retry", TargetStatus: "created",
+			Assignments: []*Assignment{
+				{Variable: "retries", Op: AssignAdd, Value: int64(1)},
+				{Variable: "reason", Op: AssignSet, Value: "retried"},
+			}},
+		{Namespace: "parity", CurrentStatus: "even", Event: "1", TargetStatus: "odd"},
+		{Namespace: "parity", CurrentStatus: "odd", Event: "1", TargetStatus: "even"},
+	},
+	Outputs: []*StatusOutput[string]{
+		{Namespace: "parity", Status: "even", Output: "0"},
+		{Namespace: "parity", Status: "odd", Output: "1"},
+	},
+	Variables: []*Variable{
+		{Namespace: "order", Name: "reason", Type: VariableString},
+		{Namespace: "order", Name: "retries", Type: VariableInt, Default: int64(0)},
+		{Namespace: "order", Name: "rate", Type: VariableFloat, Default: 0.5},
+		{Namespace: "order", Name: "vip", Type: VariableBool, Default: true},
+	},
+}
+
+// repoState the transactions, outputs and variables of namespaces in the repo
+type repoState struct {
+	Transactions map[string][]*Transaction
+	Outputs      map[string]map[string]string
+	Variables    map[string][]*Variable
+}
+
+func stateOf(repo *DefaultRepo) *repoState {
+	s := &repoState{
+		Transactions: make(map[string][]*Transaction),
+		Outputs:      make(map[string]map[string]string),
+		Variables:    make(map[string][]*Variable),
+	}
+	for _, namespace := range repo.GetNamespaces() {
+		ts := repo.GetTransactions(namespace)
+		for _, t := range ts {
+			t.guard = nil
+			// assignments all read the data before the transaction, json and yaml keep them by variable
+			sort.Slice(t.Assignments, func(i, j int) bool {
+				return t.Assignments[i].Variable < t.Assignments[j].Variable
+			})
+		}
+		s.Transactions[namespace] = ts
+		s.Outputs[namespace] = repo.Table().GetOutputs(namespace)
+		s.Variables[namespace] = repo.Table().GetVariables(namespace)
+	}
+	return s
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	for _, format := range []Format{FormatYAML, FormatJSON, FormatXML} {
+		t.Run(string(format), func(t *testing.T) {
+			repo := Default()
+			repo.Remove()
+			defer repo.Remove()
+
+			if err := repo.ReplaceDefinition(nil, roundTripDefinition); err != nil {
+				t.Fatal(err)
+			}
+			want := stateOf(repo)
+
+			var buf bytes.Buffer
+			if err := Export(&buf, format); err != nil {
+				t.Fatalf("Export = %v", err)
+			}
+			exported := buf.String()
+			repo.Remove()
+			if err := Load(&buf, format); err != nil {
+				t.Fatalf("Load = %v\n%s", err, exported)
+			}
+			if got := stateOf(repo); !reflect.DeepEqual(got, want) {
+				t.Fatalf("loaded state differs from the exported one\n%s", exported)
+			}
+		})
+	}
+}
+
+func TestExportDoubleQuotedGuard(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+
+	err := repo.ReplaceDefinition(nil, &Definition{
+		Transactions: []*Transaction{{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid",
+			Guard: `payload.method == "card" && state.note != "it's"`}},
+		Variables: []*Variable{{Namespace: "order", Name: "note", Type: VariableString}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err = Export(&buf, FormatYAML); err == nil {
+		t.Fatal("Export(guard with a quote in a string) succeeded")
+	}
+
+	err = repo.ReplaceDefinition([]string{"order"}, &Definition{
+		Transactions: []*Transaction{{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid",
+			Guard: `payload.method == "card" && state.note != ""`}},
+		Variables: []*Variable{{Namespace: "order", Name: "note", Type: VariableString}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err = Export(&buf, FormatYAML); err != nil {
+		t.Fatalf("Export = %v", err)
+	}
+	def, err := ReadDefinition(&buf, FormatYAML)
+	if err != nil {
+		t.Fatalf("ReadDefinition(exported) = %v", err)
+	}
+	if got, want := def.Transactions[0].Guard, `payload.method == 'card' && state.note != ''`; got != want {
+		t.Fatalf("exported guard = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// breaking change codes
+const (
+	BreakingRemovedNamespace   = "removed-namespace"
+	BreakingRemovedStatus      = "removed-status"
+	BreakingRemovedTransaction = "removed-transaction"
+)
+
+// DefinitionDiff the semantic changes between two definitions
+type DefinitionDiff struct {
+	Namespaces []*NamespaceDiff `json:"namespaces"`
+}
+
+// NamespaceDiff the changes of a namespace
+type NamespaceDiff struct {
+	Namespace string `json:"namespace"`
+	// Added the namespace only exists in the new definition
+	Added bool `json:"added,omitempty"`
+	// Removed the namespace only exists in the old definition
+	Removed bool `json:"removed,omitempty"`
+
+	AddedStatuses          []string         `json:"added_statuses,omitempty"`
+	RemovedStatuses        []string         `json:"removed_statuses,omitempty"`
+	AddedTransactions      []*Transaction   `json:"added_transactions,omitempty"`
+	RemovedTransactions    []*Transaction   `json:"removed_transactions,omitempty"`
+	RetargetedTransactions []*Retarget      `json:"retargeted_transactions,omitempty"`
+	KindChanges            []*KindChange    `json:"kind_changes,omitempty"`
+	Breaking               []BreakingChange `json:"breaking,omitempty"`
+}
+
+// Retarget a transaction whose target status changes
+type Retarget struct {
+	CurrentStatus string `json:"current"`
+	Event         string `json:"event"`
+	OldTarget     string `json:"old_target"`
+	NewTarget     string `json:"new_target"`
+}
+
+// KindChange a transaction whose kind changes, e.g. from internal to external
+type KindChange struct {
+	CurrentStatus string         `json:"current"`
+	Event         string         `json:"event"`
+	OldKind       TransitionKind `json:"old_kind"`
+	NewKind       TransitionKind `json:"new_kind"`
+}
+
+// BreakingChange a change which may break live instances
+type BreakingChange struct {
+	Code    string `json:"code"`
+	Status  string `json:"status,omitempty"`
+	Event   string `json:"event,omitempty"`
+	Message string `json:"message"`
+}
+
+// Diff compare two definitions by namespace, only changed namespaces are reported.
+// Removing a namespace or a status is breaking because instances may be in it,
+// and removing a transaction from a remaining status is breaking because the event is no longer accepted.
+// Transactions from the wildcard are compared as the transactions from every status they apply to.
+func Diff(old, updated []*Transaction) *DefinitionDiff {
+	oldGraphs, newGraphs := graphsByNamespace(old), graphsByNamespace(updated)
+	namespaces := make(map[string]bool)
+	for namespace := range oldGraphs {
+		namespaces[namespace] = true
+	}
+	for namespace := range newGraphs {
+		namespaces[namespace] = true
+	}
+
+	d := &DefinitionDiff{Namespaces: []*NamespaceDiff{}}
+	for _, namespace := range setKeys(namespaces) {
+		nd := diffNamespace(namespace, oldGraphs[namespace], newGraphs[namespace])
+		if !nd.empty() {
+			d.Namespaces = append(d.Namespaces, nd)
+		}
+	}
+	return d
+}
+
+// Empty judge nothing changes
+func (p *DefinitionDiff) Empty() bool {
+	return len(p.Namespaces) == 0
+}
+
+// IsBreaking judge any change is breaking
+func (p *DefinitionDiff) IsBreaking() bool {
+	for _, nd := range p.Namespaces {
+		if len(nd.Breaking) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func graphsByNamespace(ts []*Transaction) map[string]*NamespaceGraph {
+	graphs := make(map[string]*NamespaceGraph)
+	for _, g := range NewNamespaceGraphs(ts) {
+		graphs[g.Namespace] = g
+	}
+	return graphs
+}
+
+func diffNamespace(namespace string, old, updated *NamespaceGraph) *NamespaceDiff {
+	nd := &NamespaceDiff{Namespace: namespace}
+	if old == nil {
+		nd.Added = true
+		old = &NamespaceGraph{Namespace: namespace}
+	}
+	if updated == nil {
+		nd.Removed = true
+		updated = &NamespaceGraph{Namespace: namespace}
+		nd.Breaking = append(nd.Breaking, BreakingChange{
+			Code:    BreakingRemovedNamespace,
+			Message: fmt.Sprintf("namespace %q is removed", namespace),
+		})
+	}
+
+	oldStatuses, newStatuses := stringSet(old.Statuses), stringSet(updated.Statuses)
+	for _, status := range updated.Statuses {
+		if !oldStatuses[status] {
+			nd.AddedStatuses = append(nd.AddedStatuses, status)
+		}
+	}
+	for _, status := range old.Statuses {
+		if newStatuses[status] {
+			continue
+		}
+		nd.RemovedStatuses = append(nd.RemovedStatuses, status)
+		if !nd.Removed {
+			nd.Breaking = append(nd.Breaking, BreakingChange{
+				Code:    BreakingRemovedStatus,
+				Status:  status,
+				Message: fmt.Sprintf("status %q is removed, instances in it are stranded", status),
+			})
+		}
+	}
+
+	// wildcards are expanded, so a wildcard and the same transactions from every status are equal
+	oldExpanded, newExpanded := old.Expand(), updated.Expand()
+	oldTrans, newTrans := transactionsByKey(oldExpanded), transactionsByKey(newExpanded)
+	for _, t := range newExpanded {
+		prev, ok := oldTrans[t.CurrentStatus+"::"+t.Event]
+		if !ok {
+			nd.AddedTransactions = append(nd.AddedTransactions, t)
+			continue
+		}
+		if prev.TargetStatus != t.TargetStatus {
+			nd.RetargetedTransactions = append(nd.RetargetedTransactions, &Retarget{
+				CurrentStatus: t.CurrentStatus,
+				Event:         t.Event,
+				OldTarget:     prev.TargetStatus,
+				NewTarget:     t.TargetStatus,
+			})
+		}
+		if prev.Kind != t.Kind {
+			nd.KindChanges = append(nd.KindChanges, &KindChange{
+				CurrentStatus: t.CurrentStatus,
+				Event:         t.Event,
+				OldKind:       prev.Kind,
+				NewKind:       t.Kind,
+			})
+		}
+	}
+	for _, t := range oldExpanded {
+		if _, ok := newTrans[t.CurrentStatus+"::"+t.Event]; ok {
+			continue
+		}
+		nd.RemovedTransactions = append(nd.RemovedTransactions, t)
+		if !nd.Removed && newStatuses[t.CurrentStatus] {
+			nd.Breaking = append(nd.Breaking, BreakingChange{
+				Code:    BreakingRemovedTransaction,
+				Status:  t.CurrentStatus,
+				Event:   t.Event,
+				Message: fmt.Sprintf("event %q is no longer accepted in status %q", t.Event, t.CurrentStatus),
+			})
+		}
+	}
+	sort.SliceStable(nd.Breaking, func(i, j int) bool {
+		return nd.Breaking[i].Code < nd.Breaking[j].Code
+	})
+	return nd
+}
+
+func (p *NamespaceDiff) empty() bool {
+	return !p.Added && !p.Removed &&
+		len(p.AddedStatuses) == 0 && len(p.RemovedStatuses) == 0 &&
+		len(p.AddedTransactions) == 0 && len(p.RemovedTransactions) == 0 &&
+		len(p.RetargetedTransactions) == 0 && len(p.KindChanges) == 0
+}
+
+func transactionsByKey(ts []*Transaction) map[string]*Transaction {
+	m := make(map[string]*Transaction, len(ts))
+	for _, t := range ts {
+		m[t.CurrentStatus+"::"+t.Event] = t
+	}
+	return m
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
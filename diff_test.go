@@ -0,0 +1,96 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"testing"
+)
+
+// shippingTransactions created -pay-> paid -ship-> shipped, with the extra transactions
+func shippingTransactions(extra ...*Transaction) []*Transaction {
+	return append([]*Transaction{
+		{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"},
+		{Namespace: "order", CurrentStatus: "paid", Event: "ship", TargetStatus: "shipped"},
+	}, extra...)
+}
+
+func TestDiffWildcardReplacedByStatuses(t *testing.T) {
+	old := shippingTransactions(&Transaction{Namespace: "order", CurrentStatus: AnyStatus, Event: "cancel", TargetStatus: "canceled"})
+	var updated []*Transaction
+	for _, status := range []string{"created", "paid", "shipped", "canceled"} {
+		updated = append(updated, &Transaction{Namespace: "order", CurrentStatus: status, Event: "cancel", TargetStatus: "canceled"})
+	}
+	updated = shippingTransactions(updated...)
+
+	if d := Diff(old, updated); !d.Empty() {
+		t.Fatalf("Diff() = %+v, want no change", d.Namespaces[0])
+	}
+	if d := Diff(updated, old); !d.Empty() {
+		t.Fatalf("Diff(reversed) = %+v, want no change", d.Namespaces[0])
+	}
+}
+
+func TestDiffExactRemovedUnderWildcard(t *testing.T) {
+	wildcard := &Transaction{Namespace: "order", CurrentStatus: AnyStatus, Event: "cancel", TargetStatus: "canceled"}
+	old := shippingTransactions(wildcard,
+		&Transaction{Namespace: "order", CurrentStatus: "paid", Event: "cancel", TargetStatus: "canceled"})
+	updated := shippingTransactions(wildcard)
+
+	if d := Diff(old, updated); !d.Empty() {
+		t.Fatalf("Diff() = %+v, want no change", d.Namespaces[0])
+	}
+}
+
+func TestDiffExactRetargetedUnderWildcard(t *testing.T) {
+	wildcard := &Transaction{Namespace: "order", CurrentStatus: AnyStatus, Event: "cancel", TargetStatus: "canceled"}
+	old := shippingTransactions(wildcard,
+		&Transaction{Namespace: "order", CurrentStatus: "paid", Event: "cancel", TargetStatus: "refunding"})
+	updated := shippingTransactions(wildcard,
+		&Transaction{Namespace: "order", CurrentStatus: "refunding", Event: "cancel", TargetStatus: "canceled"})
+
+	d := Diff(old, updated)
+	if d.IsBreaking() || len(d.Namespaces) != 1 {
+		t.Fatalf("Diff() = %+v, want one namespace without breaking changes", d.Namespaces)
+	}
+	nd := d.Namespaces[0]
+	if len(nd.RemovedTransactions) != 0 || len(nd.RetargetedTransactions) != 1 {
+		t.Fatalf("removed %d, retargeted %d, want 0 and 1", len(nd.RemovedTransactions), len(nd.RetargetedTransactions))
+	}
+	if r := nd.RetargetedTransactions[0]; r.CurrentStatus != "paid" || r.OldTarget != "refunding" || r.NewTarget != "canceled" {
+		t.Fatalf("retargeted %+v, want paid cancel from refunding to canceled", r)
+	}
+}
+
+func TestDiffWildcardRemoved(t *testing.T) {
+	old := shippingTransactions(&Transaction{Namespace: "order", CurrentStatus: AnyStatus, Event: "cancel", TargetStatus: "canceled"})
+	updated := shippingTransactions(&Transaction{Namespace: "order", CurrentStatus: "created", Event: "cancel", TargetStatus: "canceled"})
+
+	d := Diff(old, updated)
+	if !d.IsBreaking() {
+		t.Fatal("removing cancel from canceled, paid and shipped is not breaking")
+	}
+	var statuses []string
+	for _, b := range d.Namespaces[0].Breaking {
+		if b.Code == BreakingRemovedTransaction {
+			statuses = append(statuses, b.Status)
+		}
+	}
+	if len(statuses) != 3 || statuses[0] != "canceled" || statuses[1] != "paid" || statuses[2] != "shipped" {
+		t.Fatalf("events no longer accepted in %v, want canceled, paid and shipped", statuses)
+	}
+}
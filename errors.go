@@ -19,10 +19,81 @@ package fsm
 
 import (
 	"errors"
+	"fmt"
+	"unicode/utf8"
 )
 
 // errors
 var (
 	ErrInvalidTransaction = errors.New("invalid transaction")
 	ErrTargetStatusEmpty  = errors.New("empty target status")
+	ErrInvalidOutput      = errors.New("invalid status output")
+	ErrInvalidVariable    = errors.New("invalid variable")
+	ErrInvalidValue       = errors.New("invalid variable value")
+	ErrInvalidAssignment  = errors.New("invalid assignment")
+	ErrGuardRejected      = errors.New("transition rejected by guard")
+	ErrInvalidExpr        = errors.New("invalid expression")
+
+	ErrNamespaceNotFound   = errors.New("namespace not found")
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrUnknownStatus       = errors.New("unknown status")
+	ErrUnknownEvent        = errors.New("unknown event")
+	ErrNotCompilable       = errors.New("namespace can not be compiled")
+
+	ErrEmptyDefinition      = errors.New("empty fsm definition")
+	ErrDuplicateTransaction = errors.New("duplicate transaction with different target, kind or output")
+	ErrUnsupportedFormat    = errors.New("unsupported definition format")
+	ErrNotExportable        = errors.New("name can not be exported")
+	ErrInvalidSCXML         = errors.New("invalid scxml")
+
+	ErrInvalidInstance  = errors.New("invalid instance")
+	ErrInstanceNotFound = errors.New("instance not found")
+	ErrRevisionConflict = errors.New("instance revision conflict")
+	ErrInvalidMigration = errors.New("invalid migration")
+	ErrInvalidSnapshot  = errors.New("invalid snapshot")
+	ErrNoHistory        = errors.New("no history to undo or redo")
+	ErrUndoRefused      = errors.New("undo or redo refused by policy")
+	ErrAuditBroken      = errors.New("broken audit log")
+	ErrSlowSubscriber   = errors.New("subscriber too slow")
+	ErrBrokerClosed     = errors.New("broker closed")
 )
+
+// ConfigError an error at a key of config
+type ConfigError struct {
+	Key string
+	Err error
+}
+
+func (p *ConfigError) Error() string {
+	return p.Key + ": " + p.Err.Error()
+}
+
+// Unwrap get the original error
+func (p *ConfigError) Unwrap() error {
+	return p.Err
+}
+
+// ExprError an error at a position of an expression
+type ExprError struct {
+	Expr string
+	// Pos the byte offset in the expression
+	Pos int
+	Msg string
+}
+
+func (p *ExprError) Error() string {
+	return fmt.Sprintf("%s at column %d: %s", ErrInvalidExpr, p.Column(), p.Msg)
+}
+
+// Unwrap get ErrInvalidExpr
+func (p *ExprError) Unwrap() error {
+	return ErrInvalidExpr
+}
+
+// Column the column of the position counted by characters from 1
+func (p *ExprError) Column() int {
+	if p.Pos > len(p.Expr) {
+		return utf8.RuneCountInString(p.Expr) + 1
+	}
+	return utf8.RuneCountInString(p.Expr[:p.Pos]) + 1
+}
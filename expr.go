@@ -0,0 +1,863 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Expr a compiled guard expression, it reads fields of the payload and variables of the extended state:
+//
+//	payload.amount > 100 && state.retries < 3
+//	contains(lower(payload.note), 'urgent') || !state.approved
+//
+// Operators are || && ! == != < <= > >= + - * / % and parentheses, + also joins strings.
+// Literals are integers, floats, 'single' or "double" quoted strings, true and false.
+// Functions are contains, startsWith, endsWith, lower, upper and len of strings.
+// Expressions have no loops, assignments or access to anything else, so they are safe to load from config.
+type Expr struct {
+	src  string
+	root exprNode
+	typ  exprType
+}
+
+// ExprEnv the values an expression reads
+type ExprEnv struct {
+	// Payload the payload of the fired event, nested maps are read by payload.a.b
+	Payload map[string]interface{}
+	// State the extended state of the instance
+	State Data
+}
+
+// maxExprDepth limits nesting of expressions
+const maxExprDepth = 64
+
+// CompileExpr parse an expression and check its types by the declared variables,
+// state variables are not checked if variables is nil, payload fields are checked when evaluated
+func CompileExpr(src string, variables map[string]VariableType) (*Expr, error) {
+	p := &exprParser{src: src, variables: variables}
+	p.next()
+	root, err := p.parseBinary(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf(p.tok.pos, "unexpected %s", p.tok)
+	}
+	return &Expr{src: src, root: root, typ: root.typ()}, nil
+}
+
+// CompileGuard compile an expression which must be a bool
+func CompileGuard(src string, variables map[string]VariableType) (*Expr, error) {
+	e, err := CompileExpr(src, variables)
+	if err != nil {
+		return nil, err
+	}
+	if e.typ != typeBool && e.typ != typeAny {
+		return nil, &ExprError{Expr: src, Pos: 0, Msg: fmt.Sprintf("guard is %s, not bool", e.typ)}
+	}
+	return e, nil
+}
+
+// String get the source of the expression
+func (p *Expr) String() string {
+	return p.src
+}
+
+// Eval evaluate the expression, the result is an int64, float64, string or bool
+func (p *Expr) Eval(env *ExprEnv) (interface{}, error) {
+	if env == nil {
+		env = &ExprEnv{}
+	}
+	v, err := p.root.eval(env)
+	if e, ok := err.(*ExprError); ok {
+		e.Expr = p.src
+	}
+	return v, err
+}
+
+// EvalBool evaluate the expression which must be a bool
+func (p *Expr) EvalBool(env *ExprEnv) (bool, error) {
+	v, err := p.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, &ExprError{Expr: p.src, Pos: 0, Msg: fmt.Sprintf("result %v is not bool", v)}
+	}
+	return b, nil
+}
+
+type exprType string
+
+const (
+	typeInt    exprType = "int"
+	typeFloat  exprType = "float"
+	typeString exprType = "string"
+	typeBool   exprType = "bool"
+	// typeAny payload fields and undeclared variables, checked when evaluated
+	typeAny exprType = "any"
+)
+
+func (t exprType) numeric() bool {
+	return t == typeInt || t == typeFloat || t == typeAny
+}
+
+func (t exprType) is(other exprType) bool {
+	return t == other || t == typeAny
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokFloat
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// value the value of literals
+	value interface{}
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+type exprParser struct {
+	src       string
+	offset    int
+	tok       token
+	err       error
+	variables map[string]VariableType
+}
+
+func (p *exprParser) errorf(pos int, format string, args ...interface{}) error {
+	return &ExprError{Expr: p.src, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// next scan the next token, a scanning error is kept and reported by the parser
+func (p *exprParser) next() {
+	for p.offset < len(p.src) && (p.src[p.offset] == ' ' || p.src[p.offset] == '\t' ||
+		p.src[p.offset] == '\n' || p.src[p.offset] == '\r') {
+		p.offset++
+	}
+	start := p.offset
+	if start >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	r, size := utf8.DecodeRuneInString(p.src[start:])
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		end := start + size
+		for end < len(p.src) {
+			r, size := utf8.DecodeRuneInString(p.src[end:])
+			if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			end += size
+		}
+		p.offset = end
+		p.tok = token{kind: tokIdent, text: p.src[start:end], pos: start}
+	case r >= '0' && r <= '9':
+		p.scanNumber(start)
+	case r == '"' || r == '\'':
+		p.scanString(start, byte(r))
+	default:
+		for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", ",", "."} {
+			if strings.HasPrefix(p.src[start:], op) {
+				p.offset = start + len(op)
+				p.tok = token{kind: tokOp, text: op, pos: start}
+				return
+			}
+		}
+		p.fail(p.errorf(start, "unexpected character %q", r))
+	}
+}
+
+func (p *exprParser) scanNumber(start int) {
+	end, float := start, false
+	for ; end < len(p.src); end++ {
+		c := p.src[end]
+		if c >= '0' && c <= '9' {
+			continue
+		}
+		if c == '.' && !float && end+1 < len(p.src) && p.src[end+1] >= '0' && p.src[end+1] <= '9' {
+			float = true
+			continue
+		}
+		if c == 'e' || c == 'E' {
+			float = true
+			if end+1 < len(p.src) && (p.src[end+1] == '+' || p.src[end+1] == '-') {
+				end++
+			}
+			continue
+		}
+		break
+	}
+	text := p.src[start:end]
+	p.offset = end
+	if float {
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.fail(p.errorf(start, "invalid number %q", text))
+			return
+		}
+		p.tok = token{kind: tokFloat, text: text, value: v, pos: start}
+		return
+	}
+	v, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		p.fail(p.errorf(start, "invalid number %q", text))
+		return
+	}
+	p.tok = token{kind: tokInt, text: text, value: v, pos: start}
+}
+
+func (p *exprParser) scanString(start int, quote byte) {
+	var b strings.Builder
+	for end := start + 1; end < len(p.src); end++ {
+		c := p.src[end]
+		switch {
+		case c == quote:
+			p.offset = end + 1
+			p.tok = token{kind: tokString, text: p.src[start:p.offset], value: b.String(), pos: start}
+			return
+		case c == '\\' && end+1 < len(p.src):
+			end++
+			switch p.src[end] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '\\', '\'', '"':
+				b.WriteByte(p.src[end])
+			default:
+				p.fail(p.errorf(end-1, "invalid escape \\%c", p.src[end]))
+				return
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	p.fail(p.errorf(start, "string is not closed"))
+}
+
+// singleQuoted rewrite the string literals of an expression in single quotes,
+// false if the expression is invalid or a literal can not be written without escapes
+func singleQuoted(src string) (string, bool) {
+	p := &exprParser{src: src}
+	var b strings.Builder
+	last := 0
+	for p.next(); p.tok.kind != tokEOF; p.next() {
+		if p.tok.kind != tokString {
+			continue
+		}
+		s := p.tok.value.(string)
+		if strings.ContainsAny(s, "'\"\\\n\t") {
+			return "", false
+		}
+		b.WriteString(src[last:p.tok.pos])
+		b.WriteString("'" + s + "'")
+		last = p.offset
+	}
+	if p.err != nil {
+		return "", false
+	}
+	b.WriteString(src[last:])
+	return b.String(), true
+}
+
+func (p *exprParser) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+	p.offset = len(p.src)
+	p.tok = token{kind: tokEOF, pos: len(p.src)}
+}
+
+func (p *exprParser) isOp(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+// binary operators by precedence from low to high
+var binaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) parseBinary(level, depth int) (exprNode, error) {
+	if level == len(binaryOps) {
+		return p.parseUnary(depth)
+	}
+	left, err := p.parseBinary(level+1, depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && containsString(binaryOps[level], p.tok.text) {
+		op := p.tok
+		p.next()
+		right, err := p.parseBinary(level+1, depth)
+		if err != nil {
+			return nil, err
+		}
+		if left, err = p.binary(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary(depth int) (exprNode, error) {
+	if depth > maxExprDepth {
+		return nil, p.errorf(p.tok.pos, "expression is nested too deep")
+	}
+	if p.isOp("!") || p.isOp("-") {
+		op := p.tok
+		p.next()
+		operand, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if op.text == "!" && !operand.typ().is(typeBool) {
+			return nil, p.errorf(op.pos, "operator ! needs bool, not %s", operand.typ())
+		}
+		if op.text == "-" && !operand.typ().numeric() {
+			return nil, p.errorf(op.pos, "operator - needs a number, not %s", operand.typ())
+		}
+		return &unaryNode{op: op.text, pos: op.pos, operand: operand}, nil
+	}
+	return p.parsePrimary(depth)
+}
+
+func (p *exprParser) parsePrimary(depth int) (exprNode, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	tok := p.tok
+	switch tok.kind {
+	case tokInt:
+		p.next()
+		return &literalNode{value: tok.value, t: typeInt}, p.err
+	case tokFloat:
+		p.next()
+		return &literalNode{value: tok.value, t: typeFloat}, p.err
+	case tokString:
+		p.next()
+		return &literalNode{value: tok.value, t: typeString}, p.err
+	case tokIdent:
+		p.next()
+		switch {
+		case tok.text == "true" || tok.text == "false":
+			return &literalNode{value: tok.text == "true", t: typeBool}, p.err
+		case p.isOp("("):
+			return p.parseCall(tok, depth)
+		case tok.text == "payload" || tok.text == "state":
+			return p.parseField(tok)
+		default:
+			return nil, p.errorf(tok.pos, "unknown name %q, use payload.<field> or state.<variable>", tok.text)
+		}
+	case tokOp:
+		if tok.text == "(" {
+			p.next()
+			node, err := p.parseBinary(0, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if !p.isOp(")") {
+				return nil, p.errorf(p.tok.pos, "expected ) instead of %s", p.tok)
+			}
+			p.next()
+			return node, p.err
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return nil, p.errorf(tok.pos, "unexpected %s", tok)
+}
+
+func (p *exprParser) parseField(root token) (exprNode, error) {
+	node := &fieldNode{root: root.text, pos: root.pos, t: typeAny}
+	for p.isOp(".") {
+		p.next()
+		if p.tok.kind != tokIdent {
+			return nil, p.errorf(p.tok.pos, "expected a field name instead of %s", p.tok)
+		}
+		node.path = append(node.path, p.tok.text)
+		p.next()
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(node.path) == 0 {
+		return nil, p.errorf(root.pos, "%s needs a field, like %s.name", root.text, root.text)
+	}
+	if root.text == "state" {
+		if len(node.path) > 1 {
+			return nil, p.errorf(root.pos, "variable %q has no fields", node.path[0])
+		}
+		if p.variables != nil {
+			typ, ok := p.variables[node.path[0]]
+			if !ok {
+				return nil, p.errorf(root.pos, "variable %q is not declared", node.path[0])
+			}
+			node.t = exprType(typ)
+		}
+	}
+	return node, nil
+}
+
+// expression functions with the types of arguments and result
+var exprFuncs = map[string]struct {
+	args   []exprType
+	result exprType
+	fn     func(args []interface{}) interface{}
+}{
+	"contains": {[]exprType{typeString, typeString}, typeBool, func(args []interface{}) interface{} {
+		return strings.Contains(args[0].(string), args[1].(string))
+	}},
+	"startsWith": {[]exprType{typeString, typeString}, typeBool, func(args []interface{}) interface{} {
+		return strings.HasPrefix(args[0].(string), args[1].(string))
+	}},
+	"endsWith": {[]exprType{typeString, typeString}, typeBool, func(args []interface{}) interface{} {
+		return strings.HasSuffix(args[0].(string), args[1].(string))
+	}},
+	"lower": {[]exprType{typeString}, typeString, func(args []interface{}) interface{} {
+		return strings.ToLower(args[0].(string))
+	}},
+	"upper": {[]exprType{typeString}, typeString, func(args []interface{}) interface{} {
+		return strings.ToUpper(args[0].(string))
+	}},
+	"len": {[]exprType{typeString}, typeInt, func(args []interface{}) interface{} {
+		return int64(utf8.RuneCountInString(args[0].(string)))
+	}},
+}
+
+func (p *exprParser) parseCall(name token, depth int) (exprNode, error) {
+	f, ok := exprFuncs[name.text]
+	if !ok {
+		return nil, p.errorf(name.pos, "unknown function %q", name.text)
+	}
+	p.next()
+	node := &callNode{name: name.text, pos: name.pos}
+	for !p.isOp(")") {
+		if len(node.args) > 0 {
+			if !p.isOp(",") {
+				return nil, p.errorf(p.tok.pos, "expected , or ) instead of %s", p.tok)
+			}
+			p.next()
+		}
+		arg, err := p.parseBinary(0, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		node.args = append(node.args, arg)
+	}
+	p.next()
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(node.args) != len(f.args) {
+		return nil, p.errorf(name.pos, "%s needs %d arguments, not %d", name.text, len(f.args), len(node.args))
+	}
+	for i, arg := range node.args {
+		if !arg.typ().is(f.args[i]) {
+			return nil, p.errorf(name.pos, "argument %d of %s needs %s, not %s", i+1, name.text, f.args[i], arg.typ())
+		}
+	}
+	return node, nil
+}
+
+// binary check types of operands and get the node
+func (p *exprParser) binary(op token, left, right exprNode) (exprNode, error) {
+	node := &binaryNode{op: op.text, pos: op.pos, left: left, right: right}
+	lt, rt := left.typ(), right.typ()
+	mismatch := func() error {
+		return p.errorf(op.pos, "operator %s can not be used on %s and %s", op.text, lt, rt)
+	}
+	switch op.text {
+	case "||", "&&":
+		if !lt.is(typeBool) || !rt.is(typeBool) {
+			return nil, mismatch()
+		}
+		node.t = typeBool
+	case "==", "!=":
+		if lt != typeAny && rt != typeAny && lt != rt && !(lt.numeric() && rt.numeric()) {
+			return nil, mismatch()
+		}
+		node.t = typeBool
+	case "<", "<=", ">", ">=":
+		if !(lt.numeric() && rt.numeric()) && !(lt.is(typeString) && rt.is(typeString)) {
+			return nil, mismatch()
+		}
+		node.t = typeBool
+	case "+":
+		if lt == typeString || rt == typeString {
+			if !lt.is(typeString) || !rt.is(typeString) {
+				return nil, mismatch()
+			}
+			node.t = typeString
+			break
+		}
+		fallthrough
+	default:
+		if !lt.numeric() || !rt.numeric() || (op.text == "%" && (lt == typeFloat || rt == typeFloat)) {
+			return nil, mismatch()
+		}
+		node.t = numericType(lt, rt)
+	}
+	return node, nil
+}
+
+func numericType(lt, rt exprType) exprType {
+	switch {
+	case lt == typeAny || rt == typeAny:
+		return typeAny
+	case lt == typeFloat || rt == typeFloat:
+		return typeFloat
+	default:
+		return typeInt
+	}
+}
+
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+type exprNode interface {
+	typ() exprType
+	eval(env *ExprEnv) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+	t     exprType
+}
+
+func (p *literalNode) typ() exprType { return p.t }
+
+func (p *literalNode) eval(*ExprEnv) (interface{}, error) { return p.value, nil }
+
+type fieldNode struct {
+	root string
+	path []string
+	pos  int
+	t    exprType
+}
+
+func (p *fieldNode) typ() exprType { return p.t }
+
+func (p *fieldNode) name() string {
+	return p.root + "." + strings.Join(p.path, ".")
+}
+
+func (p *fieldNode) eval(env *ExprEnv) (interface{}, error) {
+	var v interface{}
+	if p.root == "state" {
+		v = env.State[p.path[0]]
+	} else {
+		v = env.Payload
+		for _, field := range p.path {
+			switch m := v.(type) {
+			case map[string]interface{}:
+				v = m[field]
+			case Data:
+				v = m[field]
+			default:
+				v = nil
+			}
+			if v == nil {
+				break
+			}
+		}
+	}
+	if v == nil {
+		return nil, &ExprError{Pos: p.pos, Msg: p.name() + " is missing"}
+	}
+	value, ok := exprValue(v)
+	if !ok {
+		return nil, &ExprError{Pos: p.pos, Msg: fmt.Sprintf("%s is %T, not a number, string or bool", p.name(), v)}
+	}
+	return value, nil
+}
+
+// exprValue convert a value of payload or state into int64, float64, string or bool
+func exprValue(v interface{}) (interface{}, bool) {
+	switch n := v.(type) {
+	case int64, float64, string, bool:
+		return v, true
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case uint64:
+		if n <= math.MaxInt64 {
+			return int64(n), true
+		}
+		return float64(n), true
+	case uint:
+		return exprValue(uint64(n))
+	case uint32:
+		return int64(n), true
+	case float32:
+		return float64(n), true
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, true
+		}
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return nil, false
+	}
+}
+
+type unaryNode struct {
+	op      string
+	pos     int
+	operand exprNode
+}
+
+func (p *unaryNode) typ() exprType {
+	if p.op == "!" {
+		return typeBool
+	}
+	return p.operand.typ()
+}
+
+func (p *unaryNode) eval(env *ExprEnv) (interface{}, error) {
+	v, err := p.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n := v.(type) {
+	case bool:
+		if p.op == "!" {
+			return !n, nil
+		}
+	case int64:
+		if p.op == "-" {
+			return -n, nil
+		}
+	case float64:
+		if p.op == "-" {
+			return -n, nil
+		}
+	}
+	return nil, &ExprError{Pos: p.pos, Msg: fmt.Sprintf("operator %s can not be used on %v", p.op, v)}
+}
+
+type callNode struct {
+	name string
+	pos  int
+	args []exprNode
+}
+
+func (p *callNode) typ() exprType { return exprFuncs[p.name].result }
+
+func (p *callNode) eval(env *ExprEnv) (interface{}, error) {
+	f := exprFuncs[p.name]
+	args := make([]interface{}, 0, len(p.args))
+	for i, arg := range p.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if valueType(v) != f.args[i] {
+			return nil, &ExprError{Pos: p.pos, Msg: fmt.Sprintf("argument %d of %s needs %s, not %v", i+1, p.name, f.args[i], v)}
+		}
+		args = append(args, v)
+	}
+	return f.fn(args), nil
+}
+
+type binaryNode struct {
+	op          string
+	pos         int
+	left, right exprNode
+	t           exprType
+}
+
+func (p *binaryNode) typ() exprType { return p.t }
+
+func (p *binaryNode) eval(env *ExprEnv) (interface{}, error) {
+	left, err := p.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if p.op == "&&" || p.op == "||" {
+		b, ok := left.(bool)
+		if !ok {
+			return nil, p.mismatch(left, nil)
+		}
+		if b == (p.op == "||") {
+			return b, nil
+		}
+		right, err := p.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := right.(bool); !ok {
+			return nil, p.mismatch(left, right)
+		}
+		return right, nil
+	}
+
+	right, err := p.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	lt, rt := valueType(left), valueType(right)
+	switch {
+	case lt == typeString && rt == typeString:
+		return p.evalStrings(left.(string), right.(string))
+	case lt == typeBool && rt == typeBool && (p.op == "==" || p.op == "!="):
+		return (left == right) == (p.op == "=="), nil
+	case lt == typeInt && rt == typeInt:
+		return p.evalInts(left.(int64), right.(int64))
+	case lt.numeric() && rt.numeric() && lt != typeAny && rt != typeAny:
+		return p.evalFloats(toFloat(left), toFloat(right))
+	case p.op == "==" || p.op == "!=":
+		return p.op == "!=", nil
+	default:
+		return nil, p.mismatch(left, right)
+	}
+}
+
+func (p *binaryNode) mismatch(left, right interface{}) error {
+	return &ExprError{Pos: p.pos, Msg: fmt.Sprintf("operator %s can not be used on %v and %v", p.op, left, right)}
+}
+
+func (p *binaryNode) evalStrings(l, r string) (interface{}, error) {
+	switch p.op {
+	case "+":
+		return l + r, nil
+	case "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	}
+	return nil, p.mismatch(l, r)
+}
+
+func (p *binaryNode) evalInts(l, r int64) (interface{}, error) {
+	switch p.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return nil, &ExprError{Pos: p.pos, Msg: "division by zero"}
+		}
+		if p.op == "/" {
+			return l / r, nil
+		}
+		return l % r, nil
+	}
+	return p.evalFloats(float64(l), float64(r))
+}
+
+func (p *binaryNode) evalFloats(l, r float64) (interface{}, error) {
+	switch p.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, &ExprError{Pos: p.pos, Msg: "division by zero"}
+		}
+		return l / r, nil
+	case "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	}
+	return nil, p.mismatch(l, r)
+}
+
+func valueType(v interface{}) exprType {
+	switch v.(type) {
+	case int64:
+		return typeInt
+	case float64:
+		return typeFloat
+	case string:
+		return typeString
+	case bool:
+		return typeBool
+	default:
+		return typeAny
+	}
+}
+
+func toFloat(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExprPrecedence(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"1 + 2 * 3", int64(7)},
+		{"(1 + 2) * 3", int64(9)},
+		{"10 - 4 - 3", int64(3)},
+		{"7 / 2 * 2", int64(6)},
+		{"7 % 4 + 1", int64(4)},
+		{"1 + 2.5", 3.5},
+		{"-2 * -3", int64(6)},
+		{"1 + 2 < 4 == true", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && 1 > 2", false},
+		{"'a' + \"b\" == 'ab'", true},
+		{"len(upper('ab') + 'c') * 2", int64(6)},
+		{"state.count + 1 >= 3 && payload.amount > 10.5", true},
+	}
+	env := &ExprEnv{State: Data{"count": int64(2)}, Payload: map[string]interface{}{"amount": 20}}
+	for _, tt := range tests {
+		e, err := CompileExpr(tt.src, map[string]VariableType{"count": VariableInt})
+		if err != nil {
+			t.Errorf("CompileExpr(%q) = %v", tt.src, err)
+			continue
+		}
+		got, err := e.Eval(env)
+		if err != nil || got != tt.want {
+			t.Errorf("Eval(%q) = %v (%T), %v, want %v (%T)", tt.src, got, got, err, tt.want, tt.want)
+		}
+	}
+}
+
+func TestExprTypeErrors(t *testing.T) {
+	tests := []struct {
+		src    string
+		column int
+		msg    string
+	}{
+		{"1 + 'a'", 3, "operator + can not be used on int and string"},
+		{"true && 1", 6, "operator && can not be used on bool and int"},
+		{"1.5 % 2", 5, "operator % can not be used on float and int"},
+		{"!1", 1, "operator ! needs bool, not int"},
+		{"-'a'", 1, "operator - needs a number, not string"},
+		{"state.count == 'x'", 13, "operator == can not be used on int and string"},
+		{"state.missing > 1", 1, `variable "missing" is not declared`},
+		{"len(1)", 1, "argument 1 of len needs string, not int"},
+		{"contains('a')", 1, "contains needs 2 arguments, not 1"},
+		{"amount > 1", 1, `unknown name "amount"`},
+		{"(1 + 2", 7, "expected ) instead of"},
+		{"1 2", 3, "unexpected"},
+		{"'ab", 1, ""},
+	}
+	for _, tt := range tests {
+		_, err := CompileExpr(tt.src, map[string]VariableType{"count": VariableInt})
+		var e *ExprError
+		if !errors.As(err, &e) {
+			t.Errorf("CompileExpr(%q) = %v, want an *ExprError", tt.src, err)
+			continue
+		}
+		if !errors.Is(err, ErrInvalidExpr) {
+			t.Errorf("CompileExpr(%q) = %v, want %v", tt.src, err, ErrInvalidExpr)
+		}
+		if e.Column() != tt.column || !strings.Contains(e.Msg, tt.msg) {
+			t.Errorf("CompileExpr(%q) = column %d %q, want column %d %q", tt.src, e.Column(), e.Msg, tt.column, tt.msg)
+		}
+	}
+
+	if _, err := CompileGuard("1 + 2", nil); !errors.Is(err, ErrInvalidExpr) || !strings.Contains(err.Error(), "guard is int, not bool") {
+		t.Errorf("CompileGuard(1 + 2) = %v", err)
+	}
+}
+
+func TestExprDivisionByZero(t *testing.T) {
+	for _, src := range []string{"1 / 0", "1 % 0", "1.5 / 0", "10 / (payload.n - 2)"} {
+		e, err := CompileExpr(src, nil)
+		if err != nil {
+			t.Fatalf("CompileExpr(%q) = %v", src, err)
+		}
+		_, err = e.Eval(&ExprEnv{Payload: map[string]interface{}{"n": 2}})
+		var ee *ExprError
+		if !errors.As(err, &ee) || ee.Msg != "division by zero" || ee.Expr != src {
+			t.Errorf("Eval(%q) = %v, want division by zero", src, err)
+		}
+	}
+}
+
+func TestExprDepthLimit(t *testing.T) {
+	ok := strings.Repeat("(", maxExprDepth) + "1" + strings.Repeat(")", maxExprDepth)
+	if _, err := CompileExpr(ok, nil); err != nil {
+		t.Fatalf("CompileExpr(%d parentheses) = %v", maxExprDepth, err)
+	}
+	for _, src := range []string{
+		strings.Repeat("(", maxExprDepth+1) + "1" + strings.Repeat(")", maxExprDepth+1),
+		strings.Repeat("!", maxExprDepth+1) + "true",
+		strings.Repeat("len(", maxExprDepth+1) + "'a'" + strings.Repeat(")", maxExprDepth+1),
+	} {
+		_, err := CompileExpr(src, nil)
+		if !errors.Is(err, ErrInvalidExpr) || !strings.Contains(err.Error(), "nested too deep") {
+			t.Errorf("CompileExpr(%.10s...) = %v, want nested too deep", src, err)
+		}
+	}
+}
+
+func TestExprPayloadTypes(t *testing.T) {
+	e, err := CompileGuard("payload.order.amount > 10", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := e.EvalBool(&ExprEnv{Payload: map[string]interface{}{"order": map[string]interface{}{"amount": 12.5}}})
+	if err != nil || !ok {
+		t.Fatalf("EvalBool(12.5) = %v, %v, want true", ok, err)
+	}
+	_, err = e.EvalBool(&ExprEnv{Payload: map[string]interface{}{"order": map[string]interface{}{"amount": "12"}}})
+	if !errors.Is(err, ErrInvalidExpr) {
+		t.Fatalf("EvalBool('12') = %v, want %v", err, ErrInvalidExpr)
+	}
+}
@@ -18,106 +18,126 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package fsm
 
 import (
-	"sync"
+	"sort"
 )
 
-type fsm struct {
-	Transations map[string]map[string]*Transaction
+// AnyStatus the wildcard current status of the repo,
+// transactions from it apply to every status without a transaction of the same event
+const AnyStatus = "*"
 
-	sync.RWMutex
+// DefaultRepo the default repo of string statuses and events,
+// with the functions beyond Repo
+type DefaultRepo struct {
+	table *Table[string, string]
 }
 
-var defaultFSM *fsm
+var defaultFSM *DefaultRepo
 
 // New get default fsm
 func New() Repo {
+	return Default()
+}
+
+// Default get default fsm as its concrete type
+func Default() *DefaultRepo {
 	if defaultFSM == nil {
-		defaultFSM = &fsm{
-			Transations: make(map[string]map[string]*Transaction),
+		defaultFSM = &DefaultRepo{
+			table: NewTable(TableWildcard[string, string](AnyStatus)),
 		}
 	}
 	return defaultFSM
 }
 
-// Add add a transaction
-func (p *fsm) Add(t *Transaction) {
-	if e := t.valid(); e != nil {
+// Add add a transaction, an invalid or conflicting one is logged and dropped
+func (p *DefaultRepo) Add(t *Transaction) {
+	if err := p.table.Add(t); err != nil {
+		getLogger().Warn("fsm: transaction rejected", append(transactionFields(t), "error", err)...)
 		return
 	}
-
-	p.Lock()
-	defer p.Unlock()
-	p.add(t)
+	getLogger().Debug("fsm: transaction added", transactionFields(t)...)
 }
 
-func (p *fsm) add(t *Transaction) {
-
-	spaceTrans := p.Transations[t.Namespace]
+// GetTargetTranstion get trans by current information
+func (p *DefaultRepo) GetTargetTranstion(namespace, curStatus, event string) *Transaction {
+	return p.table.GetTransition(namespace, curStatus, event)
+}
 
-	if spaceTrans == nil {
-		spaceTrans = make(map[string]*Transaction)
-	}
+// GetNamespaces get all namespaces in order
+func (p *DefaultRepo) GetNamespaces() []string {
+	return p.table.GetNamespaces()
+}
 
-	spaceTrans[p.genKey(t.CurrentStatus, t.Event)] = t
-	p.Transations[t.Namespace] = spaceTrans
+// GetTransactions get copies of namespace's transactions ordered by current status and event
+func (p *DefaultRepo) GetTransactions(namespace string) []*Transaction {
+	ts := p.table.GetTransitions(namespace)
+	sortTransactions(ts)
+	return ts
 }
 
-// GetTargetTranstion get trans by current information
-func (p *fsm) GetTargetTranstion(namespace, curStatus, event string) *Transaction {
-	p.RLock()
-	defer p.RUnlock()
-	return p.getTransaction(namespace, curStatus, event)
+func sortTransactions(ts []*Transaction) {
+	sort.Slice(ts, func(i, j int) bool {
+		if ts[i].Namespace != ts[j].Namespace {
+			return ts[i].Namespace < ts[j].Namespace
+		}
+		if ts[i].CurrentStatus != ts[j].CurrentStatus {
+			return ts[i].CurrentStatus < ts[j].CurrentStatus
+		}
+		return ts[i].Event < ts[j].Event
+	})
 }
 
-// Remove remove all transactions
-func (p *fsm) Remove() {
-	p.Lock()
-	defer p.Unlock()
-	p.remove()
+// Table get the table of string statuses and events behind the repo
+func (p *DefaultRepo) Table() *Table[string, string] {
+	return p.table
 }
 
-func (p *fsm) remove() {
-	p.Transations = make(map[string]map[string]*Transaction)
+// Remove remove all transactions
+func (p *DefaultRepo) Remove() {
+	p.table.Remove()
+	getLogger().Info("fsm: transactions removed")
 }
 
 // RemoveNamespace remove namespace's transactions
-func (p *fsm) RemoveNamespace(namespace string) {
+func (p *DefaultRepo) RemoveNamespace(namespace string) {
 	if namespace == "" {
 		return
 	}
-	p.Lock()
-	defer p.Unlock()
-	p.removeNamespace(namespace)
+	p.table.RemoveNamespace(namespace)
+	getLogger().Info("fsm: namespace removed", "namespace", namespace)
 }
 
-func (p *fsm) removeNamespace(namespace string) {
-	delete(p.Transations, namespace)
-}
-
-// RemoveByTransaction remove a transaction by current information
-func (p *fsm) RemoveByTransaction(t *Transaction) {
-	if e := t.validCurrent(); e != nil {
-		return
-	}
-	p.Lock()
-	defer p.Unlock()
-	p.removeByTransaction(t)
+// ReplaceNamespaces remove the namespaces and add the transactions and outputs in one lock,
+// nothing changes if any transaction or output is invalid
+func (p *DefaultRepo) ReplaceNamespaces(namespaces []string, ts []*Transaction, outputs ...*StatusOutput[string]) error {
+	return p.replaced(namespaces, len(ts), p.table.ReplaceNamespaces(namespaces, ts, outputs...))
 }
 
-func (p *fsm) removeByTransaction(t *Transaction) {
-	spaceTrans := p.Transations[t.Namespace]
-	delete(spaceTrans, p.genKey(t.CurrentStatus, t.Event))
-	p.Transations[t.Namespace] = spaceTrans
+// ReplaceDefinition remove the namespaces and add the definition in one lock,
+// nothing changes if anything in the definition is invalid
+func (p *DefaultRepo) ReplaceDefinition(namespaces []string, def *Definition) error {
+	err := p.table.ReplaceDefinition(namespaces, &TableDefinition[string, string]{
+		Transitions: def.Transactions,
+		Outputs:     def.Outputs,
+		Variables:   def.Variables,
+	})
+	return p.replaced(namespaces, len(def.Transactions), err)
 }
 
-func (p *fsm) getTransaction(namespace, curStatus, event string) *Transaction {
-	spaceTrans := p.Transations[namespace]
-	if spaceTrans == nil {
-		return nil
+// replaced log the result of replacing namespaces
+func (p *DefaultRepo) replaced(namespaces []string, transactions int, err error) error {
+	if err != nil {
+		getLogger().Warn("fsm: replacement rejected", "namespaces", namespaces, "error", err)
+		return err
 	}
-	return spaceTrans[p.genKey(curStatus, event)]
+	getLogger().Info("fsm: namespaces replaced", "namespaces", namespaces, "transactions", transactions)
+	return nil
 }
 
-func (p *fsm) genKey(curStatus, event string) string {
-	return curStatus + "::" + event
+// RemoveByTransaction remove a transaction by current information
+func (p *DefaultRepo) RemoveByTransaction(t *Transaction) {
+	if err := p.table.RemoveTransition(t); err != nil {
+		getLogger().Warn("fsm: transaction not removed", append(transactionFields(t), "error", err)...)
+		return
+	}
+	getLogger().Info("fsm: transaction removed", transactionFields(t)...)
 }
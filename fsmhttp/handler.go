@@ -0,0 +1,487 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package fsmhttp serves the fsm repo and instances of a store over http with json bodies.
+//
+// Routes:
+//
+//	GET  /namespaces                                   list namespaces
+//	GET  /namespaces/{namespace}/transitions           get transactions of a namespace
+//	GET  /namespaces/{namespace}/lookup?status=&event= look up the transaction of an event
+//	POST /instances                                    create an instance
+//	GET  /instances/{id}                               get an instance
+//	POST /instances/{id}/events                        fire an event
+//	GET  /instances/{id}/history                       get fired transitions of an instance
+//
+// Errors are {"error": message, "code": code} with 400 for bad requests, 404 for missing namespaces,
+// transactions and instances, 409 for revision conflicts and 422 for rejected events and invalid instances.
+package fsmhttp
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/iTrellis/fsm"
+)
+
+// DefaultHistorySize default number of fired transitions kept per instance
+const DefaultHistorySize = 100
+
+// DefaultHistoryInstances default number of instances whose fired transitions are kept
+const DefaultHistoryInstances = 1000
+
+// Handler the http handler of a repo and a store, it is safe for concurrent use
+type Handler struct {
+	repo        fsm.TableRepo
+	store       fsm.Store
+	newID       func() string
+	historySize int
+	maxHistory  int
+	machineOpts []fsm.MachineOption[string, string]
+
+	// history elements of fired transitions by instance id, kept in memory
+	history map[string]*list.Element
+	// recent instances with history, the most recently fired first
+	recent *list.List
+	sync.Mutex
+}
+
+// instanceHistory fired transitions of an instance
+type instanceHistory struct {
+	id      string
+	records []fsm.HistoryRecord[string, string]
+}
+
+// Option handler option function
+type Option func(*Handler)
+
+// HandlerHistory set the number of fired transitions kept per instance, 0 to keep nothing
+func HandlerHistory(size int) Option {
+	return func(h *Handler) {
+		h.historySize = size
+	}
+}
+
+// HandlerHistoryInstances set the number of instances whose fired transitions are kept,
+// the history of the least recently fired instance is dropped over it, 0 for no limit
+func HandlerHistoryInstances(n int) Option {
+	return func(h *Handler) {
+		h.maxHistory = n
+	}
+}
+
+// HandlerIDs set the function generating ids of instances created without one
+func HandlerIDs(fn func() string) Option {
+	return func(h *Handler) {
+		h.newID = fn
+	}
+}
+
+// HandlerMachineOptions set options of the machines firing events, e.g. metrics, tracer or audit log
+func HandlerMachineOptions(opts ...fsm.MachineOption[string, string]) Option {
+	return func(h *Handler) {
+		h.machineOpts = append(h.machineOpts, opts...)
+	}
+}
+
+// NewHandler new a handler of the repo and the store
+func NewHandler(repo fsm.TableRepo, store fsm.Store, opts ...Option) *Handler {
+	h := &Handler{
+		repo:        repo,
+		store:       store,
+		newID:       randomID,
+		historySize: DefaultHistorySize,
+		maxHistory:  DefaultHistoryInstances,
+		history:     make(map[string]*list.Element),
+		recent:      list.New(),
+	}
+	for _, o := range opts {
+		o(h)
+	}
+	return h
+}
+
+// ServeHTTP route the request
+func (p *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts, err := pathParts(r.URL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "namespaces":
+		if allow(w, r, http.MethodGet) {
+			p.listNamespaces(w)
+		}
+	case len(parts) == 3 && parts[0] == "namespaces" && parts[2] == "transitions":
+		if allow(w, r, http.MethodGet) {
+			p.getTransitions(w, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "namespaces" && parts[2] == "lookup":
+		if allow(w, r, http.MethodGet) {
+			p.lookup(w, parts[1], r.URL.Query().Get("status"), r.URL.Query().Get("event"))
+		}
+	case len(parts) == 1 && parts[0] == "instances":
+		if allow(w, r, http.MethodPost) {
+			p.createInstance(w, r)
+		}
+	case len(parts) == 2 && parts[0] == "instances":
+		if allow(w, r, http.MethodGet) {
+			p.getInstance(w, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "instances" && parts[2] == "events":
+		if allow(w, r, http.MethodPost) {
+			p.fire(w, r, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "instances" && parts[2] == "history":
+		if allow(w, r, http.MethodGet) {
+			p.getHistory(w, parts[1])
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not_found", errors.New("route not found"))
+	}
+}
+
+// NamespacesResponse the body of listing namespaces
+type NamespacesResponse struct {
+	Namespaces []string `json:"namespaces"`
+}
+
+// TransitionsResponse the body of getting transactions of a namespace
+type TransitionsResponse struct {
+	Namespace   string             `json:"namespace"`
+	Transitions []*fsm.Transaction `json:"transitions"`
+}
+
+// FireRequest the body of firing an event
+type FireRequest struct {
+	Event   string                 `json:"event"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+	// Revision the revision of the instance the event is fired at, 0 for any
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// FireResponse the body of a fired event
+type FireResponse struct {
+	Instance   *fsm.Instance    `json:"instance"`
+	Transition *fsm.Transaction `json:"transition"`
+	// Output the Mealy output of the transition, or the Moore output of the new status
+	Output string `json:"output,omitempty"`
+}
+
+// HistoryResponse the body of getting fired transitions of an instance, the oldest first
+type HistoryResponse struct {
+	ID      string                              `json:"id"`
+	History []fsm.HistoryRecord[string, string] `json:"history"`
+}
+
+// ErrorResponse the body of errors
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func (p *Handler) listNamespaces(w http.ResponseWriter) {
+	namespaces := p.repo.GetNamespaces()
+	if namespaces == nil {
+		namespaces = []string{}
+	}
+	writeJSON(w, http.StatusOK, &NamespacesResponse{Namespaces: namespaces})
+}
+
+func (p *Handler) getTransitions(w http.ResponseWriter, namespace string) {
+	ts := p.repo.GetTransactions(namespace)
+	if len(ts) == 0 {
+		writeFailure(w, fsm.ErrNamespaceNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, &TransitionsResponse{Namespace: namespace, Transitions: ts})
+}
+
+func (p *Handler) lookup(w http.ResponseWriter, namespace, status, event string) {
+	if status == "" || event == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", errors.New("status and event are required"))
+		return
+	}
+	t := p.repo.GetTargetTranstion(namespace, status, event)
+	if t == nil {
+		writeFailure(w, fsm.ErrTransactionNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// createInstance create an instance at its status, or the only initial status of its namespace,
+// data is converted to types of the declared variables
+func (p *Handler) createInstance(w http.ResponseWriter, r *http.Request) {
+	inst := &fsm.Instance{}
+	if !readJSON(w, r, inst) {
+		return
+	}
+	ts := p.repo.GetTransactions(inst.Namespace)
+	if len(ts) == 0 {
+		writeFailure(w, fsm.ErrNamespaceNotFound)
+		return
+	}
+	g := fsm.NewNamespaceGraph(inst.Namespace, ts)
+	if inst.Status == "" && len(g.Initials) == 1 {
+		inst.Status = g.Initials[0]
+	}
+	if !contains(g.Statuses, inst.Status) {
+		writeFailure(w, fsm.ErrUnknownStatus)
+		return
+	}
+	if inst.ID == "" {
+		inst.ID = p.newID()
+	}
+	inst.Revision = 0
+
+	m, err := p.machine(inst)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if err = m.Restore(inst.Snapshot()); err != nil {
+		writeFailure(w, err)
+		return
+	}
+	inst.SetSnapshot(m.Snapshot())
+	if err = p.store.Put(inst); err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, inst)
+}
+
+func (p *Handler) getInstance(w http.ResponseWriter, id string) {
+	inst, err := p.store.Get(id)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, inst)
+}
+
+// fire fire an event at the stored instance and put it back with an outbox record if the store has an outbox,
+// the store rejects the put if the instance changed in the meantime, and nothing is audited then
+func (p *Handler) fire(w http.ResponseWriter, r *http.Request, id string) {
+	req := &FireRequest{}
+	if !readJSON(w, r, req) {
+		return
+	}
+	if req.Event == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", errors.New("event is required"))
+		return
+	}
+	inst, err := p.store.Get(id)
+	if err != nil {
+		if errors.Is(err, fsm.ErrInstanceNotFound) {
+			p.forget(id)
+		}
+		writeFailure(w, err)
+		return
+	}
+	if req.Revision != 0 && req.Revision != inst.Revision {
+		writeFailure(w, fsm.ErrRevisionConflict)
+		return
+	}
+
+	opts := append(append([]fsm.MachineOption[string, string]{}, p.machineOpts...),
+		fsm.MachineHistory[string, string](1, nil))
+	m, t, err := fsm.FireInstance(r.Context(), p.repo, p.store, inst, req.Event, req.Payload, opts...)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	p.record(inst.ID, m.History())
+
+	resp := &FireResponse{Instance: inst, Transition: t}
+	if t != nil {
+		resp.Output = t.Output
+	}
+	if resp.Output == "" {
+		resp.Output = m.Output()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (p *Handler) getHistory(w http.ResponseWriter, id string) {
+	if _, err := p.store.Get(id); err != nil {
+		if errors.Is(err, fsm.ErrInstanceNotFound) {
+			p.forget(id)
+		}
+		writeFailure(w, err)
+		return
+	}
+	records := []fsm.HistoryRecord[string, string]{}
+	p.Lock()
+	if e, ok := p.history[id]; ok {
+		records = append(records, e.Value.(*instanceHistory).records...)
+	}
+	p.Unlock()
+	writeJSON(w, http.StatusOK, &HistoryResponse{ID: id, History: records})
+}
+
+// machine new a machine of the instance with the options of the handler
+func (p *Handler) machine(inst *fsm.Instance, opts ...fsm.MachineOption[string, string]) (*fsm.Machine[string, string], error) {
+	opts = append(append(append([]fsm.MachineOption[string, string]{}, p.machineOpts...),
+		fsm.MachineID[string, string](inst.ID)), opts...)
+	return fsm.NewRepoMachine(p.repo, inst.Namespace, inst.Status, opts...)
+}
+
+// record keep the fired transitions of the instance, dropping the oldest ones over the size,
+// and the history of the least recently fired instances over the number of instances
+func (p *Handler) record(id string, records []fsm.HistoryRecord[string, string]) {
+	if p.historySize <= 0 {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	e, ok := p.history[id]
+	if ok {
+		p.recent.MoveToFront(e)
+	} else {
+		e = p.recent.PushFront(&instanceHistory{id: id})
+		p.history[id] = e
+	}
+	h := e.Value.(*instanceHistory)
+	h.records = append(h.records, records...)
+	if len(h.records) > p.historySize {
+		h.records = append([]fsm.HistoryRecord[string, string]{}, h.records[len(h.records)-p.historySize:]...)
+	}
+
+	for p.maxHistory > 0 && p.recent.Len() > p.maxHistory {
+		oldest := p.recent.Back()
+		p.recent.Remove(oldest)
+		delete(p.history, oldest.Value.(*instanceHistory).id)
+	}
+}
+
+// forget drop the history of an instance no longer in the store
+func (p *Handler) forget(id string) {
+	p.Lock()
+	defer p.Unlock()
+	if e, ok := p.history[id]; ok {
+		p.recent.Remove(e)
+		delete(p.history, id)
+	}
+}
+
+// statusOf get the http status and code of an error of the repo, the store or a machine
+func statusOf(err error) (int, string) {
+	switch {
+	case errors.Is(err, fsm.ErrNamespaceNotFound):
+		return http.StatusNotFound, "namespace_not_found"
+	case errors.Is(err, fsm.ErrTransactionNotFound):
+		return http.StatusNotFound, "transaction_not_found"
+	case errors.Is(err, fsm.ErrInstanceNotFound):
+		return http.StatusNotFound, "instance_not_found"
+	case errors.Is(err, fsm.ErrRevisionConflict):
+		return http.StatusConflict, "revision_conflict"
+	case errors.Is(err, fsm.ErrGuardRejected):
+		return http.StatusUnprocessableEntity, "guard_rejected"
+	case errors.Is(err, fsm.ErrInvalidExpr):
+		return http.StatusUnprocessableEntity, "invalid_expression"
+	case errors.Is(err, fsm.ErrUnknownStatus):
+		return http.StatusUnprocessableEntity, "unknown_status"
+	case errors.Is(err, fsm.ErrInvalidInstance), errors.Is(err, fsm.ErrInvalidSnapshot):
+		return http.StatusUnprocessableEntity, "invalid_instance"
+	case errors.Is(err, fsm.ErrInvalidValue), errors.Is(err, fsm.ErrInvalidAssignment):
+		return http.StatusUnprocessableEntity, "invalid_value"
+	default:
+		return http.StatusInternalServerError, "internal"
+	}
+}
+
+func writeFailure(w http.ResponseWriter, err error) {
+	status, code := statusOf(err)
+	writeError(w, status, code, err)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, err error) {
+	writeJSON(w, status, &ErrorResponse{Error: err.Error(), Code: code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(v)
+}
+
+// maxBodySize the limit of request bodies
+const maxBodySize = 1 << 20
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err)
+		return false
+	}
+	return true
+}
+
+// allow judge the method of the request is allowed, or write 405
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method || (method == http.MethodGet && r.Method == http.MethodHead) {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", errors.New("method not allowed"))
+	return false
+}
+
+// pathParts split the escaped path into unescaped segments, so namespaces and ids may contain slashes
+func pathParts(u *url.URL) ([]string, error) {
+	var parts []string
+	for _, part := range strings.Split(strings.Trim(u.EscapedPath(), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, unescaped)
+	}
+	return parts, nil
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsmhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iTrellis/fsm"
+)
+
+// newTestServer serve a handler of the order namespace, created -pay-> paid -ship-> shipped,
+// paying is allowed for amounts over 10
+func newTestServer(t *testing.T, store fsm.Store) *httptest.Server {
+	t.Helper()
+	repo := fsm.Default()
+	repo.Remove()
+	t.Cleanup(repo.Remove)
+	repo.Add(&fsm.Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid",
+		Guard: "payload.amount > 10"})
+	repo.Add(&fsm.Transaction{Namespace: "order", CurrentStatus: "paid", Event: "ship", TargetStatus: "shipped"})
+
+	srv := httptest.NewServer(NewHandler(repo, store, HandlerIDs(func() string { return "o1" })))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// call send the request with the body encoded as json, and decode the response body into out if it is not nil
+func call(t *testing.T, srv *httptest.Server, method, path string, body, out interface{}) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, srv.URL+path, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// expectError check the status and the code of an error response
+func expectError(t *testing.T, srv *httptest.Server, method, path string, body interface{}, status int, code string) {
+	t.Helper()
+	resp := &ErrorResponse{}
+	if got := call(t, srv, method, path, body, resp); got != status || resp.Code != code {
+		t.Errorf("%s %s = %d %q, want %d %q", method, path, got, resp.Code, status, code)
+	}
+}
+
+func TestCreateFireHistory(t *testing.T) {
+	srv := newTestServer(t, fsm.NewMemoryStore())
+
+	inst := &fsm.Instance{}
+	if status := call(t, srv, http.MethodPost, "/instances", &fsm.Instance{Namespace: "order"}, inst); status != http.StatusCreated {
+		t.Fatalf("create = %d", status)
+	}
+	if inst.ID != "o1" || inst.Status != "created" || inst.Revision != 1 {
+		t.Fatalf("created %+v, want o1 at created with revision 1", inst)
+	}
+
+	fired := &FireResponse{}
+	req := &FireRequest{Event: "pay", Payload: map[string]interface{}{"amount": 20}, Revision: 1}
+	if status := call(t, srv, http.MethodPost, "/instances/o1/events", req, fired); status != http.StatusOK {
+		t.Fatalf("fire pay = %d", status)
+	}
+	if fired.Instance.Status != "paid" || fired.Instance.Revision != 2 || fired.Transition.TargetStatus != "paid" {
+		t.Fatalf("fired %+v, want paid with revision 2", fired.Instance)
+	}
+	if status := call(t, srv, http.MethodPost, "/instances/o1/events", &FireRequest{Event: "ship"}, fired); status != http.StatusOK {
+		t.Fatalf("fire ship = %d", status)
+	}
+
+	got := &fsm.Instance{}
+	if status := call(t, srv, http.MethodGet, "/instances/o1", nil, got); status != http.StatusOK || got.Status != "shipped" {
+		t.Fatalf("get = %d %+v, want shipped", status, got)
+	}
+
+	history := &HistoryResponse{}
+	if status := call(t, srv, http.MethodGet, "/instances/o1/history", nil, history); status != http.StatusOK {
+		t.Fatalf("history = %d", status)
+	}
+	if len(history.History) != 2 ||
+		history.History[0].Event != "pay" || history.History[0].From != "created" || history.History[0].To != "paid" ||
+		history.History[1].Event != "ship" || history.History[1].From != "paid" || history.History[1].To != "shipped" {
+		t.Fatalf("history = %+v, want pay then ship", history.History)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	srv := newTestServer(t, fsm.NewMemoryStore())
+	call(t, srv, http.MethodPost, "/instances", &fsm.Instance{Namespace: "order"}, nil)
+
+	expectError(t, srv, http.MethodGet, "/namespaces/missing/transitions", nil, http.StatusNotFound, "namespace_not_found")
+	expectError(t, srv, http.MethodPost, "/instances", &fsm.Instance{Namespace: "missing"}, http.StatusNotFound, "namespace_not_found")
+	expectError(t, srv, http.MethodGet, "/namespaces/order/lookup?status=created&event=ship", nil,
+		http.StatusNotFound, "transaction_not_found")
+	expectError(t, srv, http.MethodPost, "/instances/o1/events", &FireRequest{Event: "ship"},
+		http.StatusNotFound, "transaction_not_found")
+	expectError(t, srv, http.MethodGet, "/instances/missing", nil, http.StatusNotFound, "instance_not_found")
+	expectError(t, srv, http.MethodGet, "/instances/missing/history", nil, http.StatusNotFound, "instance_not_found")
+}
+
+// racingStore a store where another writer puts the instance between getting and putting it
+type racingStore struct {
+	fsm.Store
+}
+
+func (p *racingStore) Get(id string) (*fsm.Instance, error) {
+	inst, err := p.Store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	other := *inst
+	if err = p.Store.Put(&other); err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+func TestRevisionConflict(t *testing.T) {
+	store := fsm.NewMemoryStore()
+	srv := newTestServer(t, store)
+	call(t, srv, http.MethodPost, "/instances", &fsm.Instance{Namespace: "order"}, nil)
+
+	req := &FireRequest{Event: "pay", Payload: map[string]interface{}{"amount": 20}, Revision: 2}
+	expectError(t, srv, http.MethodPost, "/instances/o1/events", req, http.StatusConflict, "revision_conflict")
+
+	racing := httptest.NewServer(NewHandler(fsm.Default(), &racingStore{Store: store}))
+	defer racing.Close()
+	req.Revision = 0
+	expectError(t, racing, http.MethodPost, "/instances/o1/events", req, http.StatusConflict, "revision_conflict")
+
+	inst, err := store.Get("o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.Status != "created" {
+		t.Fatalf("status after conflict = %q, want created", inst.Status)
+	}
+}
+
+func TestGuardRejected(t *testing.T) {
+	srv := newTestServer(t, fsm.NewMemoryStore())
+	call(t, srv, http.MethodPost, "/instances", &fsm.Instance{Namespace: "order"}, nil)
+
+	req := &FireRequest{Event: "pay", Payload: map[string]interface{}{"amount": 5}}
+	expectError(t, srv, http.MethodPost, "/instances/o1/events", req, http.StatusUnprocessableEntity, "guard_rejected")
+
+	history := &HistoryResponse{}
+	call(t, srv, http.MethodGet, "/instances/o1/history", nil, history)
+	if len(history.History) != 0 {
+		t.Fatalf("history after rejection = %+v, want none", history.History)
+	}
+}
+
+func TestHistoryInstances(t *testing.T) {
+	store := fsm.NewMemoryStore()
+	newTestServer(t, store)
+	h := NewHandler(fsm.Default(), store, HandlerHistoryInstances(2))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	pay := &FireRequest{Event: "pay", Payload: map[string]interface{}{"amount": 20}}
+	for _, id := range []string{"o1", "o2", "o3"} {
+		call(t, srv, http.MethodPost, "/instances", &fsm.Instance{ID: id, Namespace: "order"}, nil)
+		if status := call(t, srv, http.MethodPost, "/instances/"+id+"/events", pay, nil); status != http.StatusOK {
+			t.Fatalf("fire pay on %s = %d", id, status)
+		}
+	}
+	// o2 is fired again, o3 is the least recently fired then
+	if status := call(t, srv, http.MethodPost, "/instances/o2/events", &FireRequest{Event: "ship"}, nil); status != http.StatusOK {
+		t.Fatalf("fire ship on o2 = %d", status)
+	}
+	call(t, srv, http.MethodPost, "/instances", &fsm.Instance{ID: "o4", Namespace: "order"}, nil)
+	call(t, srv, http.MethodPost, "/instances/o4/events", pay, nil)
+
+	for id, want := range map[string]int{"o1": 0, "o2": 2, "o3": 0, "o4": 1} {
+		history := &HistoryResponse{}
+		if status := call(t, srv, http.MethodGet, "/instances/"+id+"/history", nil, history); status != http.StatusOK {
+			t.Fatalf("history of %s = %d", id, status)
+		}
+		if len(history.History) != want {
+			t.Errorf("history of %s = %+v, want %d records", id, history.History, want)
+		}
+	}
+
+	if err := store.Delete("o4"); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, srv, http.MethodGet, "/instances/o4/history", nil, http.StatusNotFound, "instance_not_found")
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.history["o4"]; ok || len(h.history) != 1 || h.recent.Len() != 1 {
+		t.Fatalf("history of %d instances after deleting o4, want only o2", len(h.history))
+	}
+}
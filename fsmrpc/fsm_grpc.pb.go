@@ -0,0 +1,234 @@
+// Copyright © 2016 Henry Huang <hhh@rutcode.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: fsm.proto
+
+package fsmrpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	FSM_Lookup_FullMethodName        = "/fsm.v1.FSM/Lookup"
+	FSM_Fire_FullMethodName          = "/fsm.v1.FSM/Fire"
+	FSM_WatchInstance_FullMethodName = "/fsm.v1.FSM/WatchInstance"
+)
+
+// FSMClient is the client API for FSM service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FSMClient interface {
+	// Lookup gets the transaction of an event in a status, NOT_FOUND if there is none.
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error)
+	// Fire fires an event at a stored instance and stores it back,
+	// ABORTED if the revision does not match, FAILED_PRECONDITION if a guard rejects the event.
+	Fire(ctx context.Context, in *FireRequest, opts ...grpc.CallOption) (*FireResponse, error)
+	// WatchInstance sends the instance, then every event fired at it through the service.
+	WatchInstance(ctx context.Context, in *WatchInstanceRequest, opts ...grpc.CallOption) (FSM_WatchInstanceClient, error)
+}
+
+type fSMClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFSMClient(cc grpc.ClientConnInterface) FSMClient {
+	return &fSMClient{cc}
+}
+
+func (c *fSMClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error) {
+	out := new(LookupResponse)
+	err := c.cc.Invoke(ctx, FSM_Lookup_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fSMClient) Fire(ctx context.Context, in *FireRequest, opts ...grpc.CallOption) (*FireResponse, error) {
+	out := new(FireResponse)
+	err := c.cc.Invoke(ctx, FSM_Fire_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fSMClient) WatchInstance(ctx context.Context, in *WatchInstanceRequest, opts ...grpc.CallOption) (FSM_WatchInstanceClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FSM_ServiceDesc.Streams[0], FSM_WatchInstance_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fSMWatchInstanceClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FSM_WatchInstanceClient interface {
+	Recv() (*InstanceEvent, error)
+	grpc.ClientStream
+}
+
+type fSMWatchInstanceClient struct {
+	grpc.ClientStream
+}
+
+func (x *fSMWatchInstanceClient) Recv() (*InstanceEvent, error) {
+	m := new(InstanceEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FSMServer is the server API for FSM service.
+// All implementations must embed UnimplementedFSMServer
+// for forward compatibility
+type FSMServer interface {
+	// Lookup gets the transaction of an event in a status, NOT_FOUND if there is none.
+	Lookup(context.Context, *LookupRequest) (*LookupResponse, error)
+	// Fire fires an event at a stored instance and stores it back,
+	// ABORTED if the revision does not match, FAILED_PRECONDITION if a guard rejects the event.
+	Fire(context.Context, *FireRequest) (*FireResponse, error)
+	// WatchInstance sends the instance, then every event fired at it through the service.
+	WatchInstance(*WatchInstanceRequest, FSM_WatchInstanceServer) error
+	mustEmbedUnimplementedFSMServer()
+}
+
+// UnimplementedFSMServer must be embedded to have forward compatible implementations.
+type UnimplementedFSMServer struct {
+}
+
+func (UnimplementedFSMServer) Lookup(context.Context, *LookupRequest) (*LookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedFSMServer) Fire(context.Context, *FireRequest) (*FireResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Fire not implemented")
+}
+func (UnimplementedFSMServer) WatchInstance(*WatchInstanceRequest, FSM_WatchInstanceServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchInstance not implemented")
+}
+func (UnimplementedFSMServer) mustEmbedUnimplementedFSMServer() {}
+
+// UnsafeFSMServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FSMServer will
+// result in compilation errors.
+type UnsafeFSMServer interface {
+	mustEmbedUnimplementedFSMServer()
+}
+
+func RegisterFSMServer(s grpc.ServiceRegistrar, srv FSMServer) {
+	s.RegisterService(&FSM_ServiceDesc, srv)
+}
+
+func _FSM_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FSMServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FSM_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FSMServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FSM_Fire_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FireRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FSMServer).Fire(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FSM_Fire_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FSMServer).Fire(ctx, req.(*FireRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FSM_WatchInstance_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchInstanceRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FSMServer).WatchInstance(m, &fSMWatchInstanceServer{stream})
+}
+
+type FSM_WatchInstanceServer interface {
+	Send(*InstanceEvent) error
+	grpc.ServerStream
+}
+
+type fSMWatchInstanceServer struct {
+	grpc.ServerStream
+}
+
+func (x *fSMWatchInstanceServer) Send(m *InstanceEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// FSM_ServiceDesc is the grpc.ServiceDesc for FSM service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FSM_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fsm.v1.FSM",
+	HandlerType: (*FSMServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler:    _FSM_Lookup_Handler,
+		},
+		{
+			MethodName: "Fire",
+			Handler:    _FSM_Fire_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchInstance",
+			Handler:       _FSM_WatchInstance_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "fsm.proto",
+}
@@ -0,0 +1,239 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package fsmrpc serves the fsm repo and instances of a store with the FSM gRPC service of fsm.proto.
+//
+// fsm.pb.go and fsm_grpc.pb.go are generated by protoc-gen-go and protoc-gen-go-grpc, see generate.go.
+package fsmrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/iTrellis/fsm"
+)
+
+// DefaultWatchBuffer default number of events buffered per watcher
+const DefaultWatchBuffer = 16
+
+// Service the FSM service backed by a repo and an instance store, it is safe for concurrent use
+type Service struct {
+	UnimplementedFSMServer
+
+	repo        fsm.TableRepo
+	store       fsm.Store
+	machineOpts []fsm.MachineOption[string, string]
+	watchBuffer int
+	broker      *fsm.Broker
+}
+
+// ServiceOption service option function
+type ServiceOption func(*Service)
+
+// ServiceMachineOptions set options of the machines firing events, e.g. metrics, tracer or audit log
+func ServiceMachineOptions(opts ...fsm.MachineOption[string, string]) ServiceOption {
+	return func(s *Service) {
+		s.machineOpts = append(s.machineOpts, opts...)
+	}
+}
+
+// ServiceBroker set the broker which fired events are published to and watchers subscribe to,
+// share it with other FireInstance callers of the store so their events are watched too,
+// the service has a broker of its own by default
+func ServiceBroker(broker *fsm.Broker) ServiceOption {
+	return func(s *Service) {
+		s.broker = broker
+	}
+}
+
+// ServiceWatchBuffer set the number of events buffered per watcher,
+// a watcher whose buffer is full is ended with codes.ResourceExhausted
+func ServiceWatchBuffer(size int) ServiceOption {
+	return func(s *Service) {
+		s.watchBuffer = size
+	}
+}
+
+// NewService new the FSM service of the repo and the store
+func NewService(repo fsm.TableRepo, store fsm.Store, opts ...ServiceOption) *Service {
+	s := &Service{
+		repo:        repo,
+		store:       store,
+		watchBuffer: DefaultWatchBuffer,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.watchBuffer <= 0 {
+		s.watchBuffer = DefaultWatchBuffer
+	}
+	if s.broker == nil {
+		s.broker = fsm.NewBroker()
+	}
+	return s
+}
+
+// Lookup get the transaction of an event in a status
+func (p *Service) Lookup(ctx context.Context, req *LookupRequest) (*LookupResponse, error) {
+	if req.GetNamespace() == "" || req.GetStatus() == "" || req.GetEvent() == "" {
+		return nil, status.Error(codes.InvalidArgument, "namespace, status and event are required")
+	}
+	t := p.repo.GetTargetTranstion(req.GetNamespace(), req.GetStatus(), req.GetEvent())
+	if t == nil {
+		return nil, statusOf(fsm.ErrTransactionNotFound)
+	}
+	return &LookupResponse{Transaction: transactionOf(t)}, nil
+}
+
+// Fire fire an event at the stored instance and put it back with an outbox record if the store has an outbox,
+// the store rejects the put if the instance changed in the meantime, and nothing is audited then
+func (p *Service) Fire(ctx context.Context, req *FireRequest) (*FireResponse, error) {
+	if req.GetInstanceId() == "" || req.GetEvent() == "" {
+		return nil, status.Error(codes.InvalidArgument, "instance id and event are required")
+	}
+	inst, err := p.store.Get(req.GetInstanceId())
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	if req.GetRevision() != 0 && req.GetRevision() != inst.Revision {
+		return nil, statusOf(fsm.ErrRevisionConflict)
+	}
+
+	// AsMap of a nil struct is an empty map, which would be audited as a payload
+	var payload map[string]interface{}
+	if req.GetPayload() != nil {
+		payload = req.GetPayload().AsMap()
+	}
+	opts := append(append([]fsm.MachineOption[string, string]{}, p.machineOpts...),
+		fsm.MachinePublish[string, string](p.broker))
+	m, t, err := fsm.FireInstance(ctx, p.repo, p.store, inst, req.GetEvent(), payload, opts...)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	pb, err := instanceOf(inst)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	resp := &FireResponse{Instance: pb, Transaction: transactionOf(t)}
+	if t != nil {
+		resp.Output = t.Output
+	}
+	if resp.Output == "" {
+		resp.Output = m.Output()
+	}
+	return resp, nil
+}
+
+// WatchInstance send the instance, then every event fired at it by FireInstance with the service's broker,
+// through this service or any other, until the client goes away
+func (p *Service) WatchInstance(req *WatchInstanceRequest, stream FSM_WatchInstanceServer) error {
+	if req.GetInstanceId() == "" {
+		return status.Error(codes.InvalidArgument, "instance id is required")
+	}
+	// subscribe before getting the instance, so no event after it is missed
+	sub := p.broker.Subscribe(fsm.SubscriptionFilter{Instances: []string{req.GetInstanceId()}},
+		fsm.SubscribeBuffer(p.watchBuffer), fsm.SubscribePolicy(fsm.SlowDisconnect))
+	defer sub.Unsubscribe()
+
+	inst, err := p.store.Get(req.GetInstanceId())
+	if err != nil {
+		return statusOf(err)
+	}
+	pb, err := instanceOf(inst)
+	if err != nil {
+		return statusOf(err)
+	}
+	if err = stream.Send(&InstanceEvent{Instance: pb}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return statusOf(stream.Context().Err())
+		case e, ok := <-sub.C:
+			if !ok {
+				return statusOf(sub.Err())
+			}
+			// events fired before the instance was got are skipped, so are those of machines without a store
+			if e.Revision <= inst.Revision {
+				continue
+			}
+			ie, err := p.instanceEvent(e)
+			if err != nil {
+				return statusOf(err)
+			}
+			if err = stream.Send(ie); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// instanceEvent convert a transition event published by FireInstance into its message
+func (p *Service) instanceEvent(e *fsm.TransitionEvent) (*InstanceEvent, error) {
+	pb, err := instanceOf(&fsm.Instance{
+		ID:        e.Instance,
+		Namespace: e.Namespace,
+		Status:    e.To,
+		Data:      e.Data,
+		Revision:  e.Revision,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &InstanceEvent{
+		Instance:    pb,
+		Transaction: transactionOf(p.repo.GetTargetTranstion(e.Namespace, e.From, e.Event)),
+	}, nil
+}
+
+func transactionOf(t *fsm.Transaction) *Transaction {
+	if t == nil {
+		return nil
+	}
+	return &Transaction{
+		Namespace: t.Namespace,
+		Current:   t.CurrentStatus,
+		Event:     t.Event,
+		Target:    t.TargetStatus,
+		Kind:      string(t.Kind),
+		Output:    t.Output,
+		Guard:     t.Guard,
+	}
+}
+
+// instanceOf convert the instance into its message, with data as a struct
+func instanceOf(inst *fsm.Instance) (*Instance, error) {
+	var data *structpb.Struct
+	if len(inst.Data) > 0 {
+		var err error
+		if data, err = structpb.NewStruct(inst.Data); err != nil {
+			return nil, err
+		}
+	}
+	return &Instance{
+		Id:        inst.ID,
+		Namespace: inst.Namespace,
+		Status:    inst.Status,
+		Data:      data,
+		Revision:  inst.Revision,
+	}, nil
+}
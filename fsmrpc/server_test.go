@@ -0,0 +1,290 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsmrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/iTrellis/fsm"
+)
+
+// newTestClient serve the service of the order namespace, created -pay-> paid -ship-> shipped,
+// over an in-memory listener, paying is allowed for amounts over 10
+func newTestClient(t *testing.T, store fsm.Store, opts ...ServiceOption) FSMClient {
+	t.Helper()
+	repo := fsm.Default()
+	repo.Remove()
+	t.Cleanup(repo.Remove)
+	repo.Add(&fsm.Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid",
+		Guard: "payload.amount > 10"})
+	repo.Add(&fsm.Transaction{Namespace: "order", CurrentStatus: "paid", Event: "ship", TargetStatus: "shipped"})
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	RegisterFSMServer(s, NewService(repo, store, opts...))
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cc.Close()
+	})
+	return NewFSMClient(cc)
+}
+
+// newTestStore new a store with the order o1 created
+func newTestStore(t *testing.T) fsm.Store {
+	t.Helper()
+	store := fsm.NewMemoryStore()
+	if err := store.Put(&fsm.Instance{ID: "o1", Namespace: "order", Status: "created"}); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func payload(t *testing.T, amount float64) *structpb.Struct {
+	t.Helper()
+	s, err := structpb.NewStruct(map[string]interface{}{"amount": amount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestLookup(t *testing.T) {
+	client := newTestClient(t, newTestStore(t))
+	ctx := testContext(t)
+
+	resp, err := client.Lookup(ctx, &LookupRequest{Namespace: "order", Status: "created", Event: "pay"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr := resp.GetTransaction(); tr.GetTarget() != "paid" || tr.GetGuard() != "payload.amount > 10" {
+		t.Fatalf("Lookup() = %v, want created -pay-> paid", tr)
+	}
+
+	_, err = client.Lookup(ctx, &LookupRequest{Namespace: "order", Status: "created", Event: "ship"})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("Lookup(missing) code = %v, want %v", code, codes.NotFound)
+	}
+	_, err = client.Lookup(ctx, &LookupRequest{Namespace: "order"})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Fatalf("Lookup(empty) code = %v, want %v", code, codes.InvalidArgument)
+	}
+}
+
+func TestFire(t *testing.T) {
+	store := newTestStore(t)
+	client := newTestClient(t, store)
+	ctx := testContext(t)
+
+	resp, err := client.Fire(ctx, &FireRequest{InstanceId: "o1", Event: "pay", Payload: payload(t, 20), Revision: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst := resp.GetInstance(); inst.GetStatus() != "paid" || inst.GetRevision() != 2 {
+		t.Fatalf("Fire() instance = %v, want paid with revision 2", inst)
+	}
+	if resp.GetTransaction().GetTarget() != "paid" {
+		t.Fatalf("Fire() transaction = %v, want created -pay-> paid", resp.GetTransaction())
+	}
+
+	_, err = client.Fire(ctx, &FireRequest{InstanceId: "missing", Event: "pay"})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("Fire(missing) code = %v, want %v", code, codes.NotFound)
+	}
+}
+
+func TestFireGuardRejected(t *testing.T) {
+	store := newTestStore(t)
+	client := newTestClient(t, store)
+
+	_, err := client.Fire(testContext(t), &FireRequest{InstanceId: "o1", Event: "pay", Payload: payload(t, 5)})
+	if code := status.Code(err); code != codes.FailedPrecondition {
+		t.Fatalf("Fire() code = %v, want %v", code, codes.FailedPrecondition)
+	}
+	if inst, _ := store.Get("o1"); inst.Status != "created" || inst.Revision != 1 {
+		t.Fatalf("instance after rejection = %+v, want created with revision 1", inst)
+	}
+}
+
+func TestFireRevisionConflict(t *testing.T) {
+	store := newTestStore(t)
+	client := newTestClient(t, store)
+
+	_, err := client.Fire(testContext(t), &FireRequest{InstanceId: "o1", Event: "pay", Payload: payload(t, 20), Revision: 2})
+	if code := status.Code(err); code != codes.Aborted {
+		t.Fatalf("Fire() code = %v, want %v", code, codes.Aborted)
+	}
+	if inst, _ := store.Get("o1"); inst.Status != "created" {
+		t.Fatalf("status after conflict = %q, want created", inst.Status)
+	}
+}
+
+func TestFireAuditPayload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := fsm.OpenAuditLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	client := newTestClient(t, newTestStore(t), ServiceMachineOptions(fsm.MachineAudit[string, string](sink)))
+	ctx := testContext(t)
+
+	if _, err = client.Fire(ctx, &FireRequest{InstanceId: "o1", Event: "pay", Payload: payload(t, 20)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Fire(ctx, &FireRequest{InstanceId: "o1", Event: "ship"}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []fsm.AuditEntry
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var e fsm.AuditEntry
+		if err = json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("audit log has %d entries, want 2", len(entries))
+	}
+	if entries[0].PayloadDigest == "" {
+		t.Fatal("pay with a payload has no payload digest")
+	}
+	if entries[1].PayloadDigest != "" {
+		t.Fatalf("ship without payload has payload digest %q", entries[1].PayloadDigest)
+	}
+}
+
+func TestWatchInstance(t *testing.T) {
+	client := newTestClient(t, newTestStore(t))
+	ctx := testContext(t)
+
+	stream, err := client.WatchInstance(ctx, &WatchInstanceRequest{InstanceId: "o1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.GetInstance().GetStatus() != "created" || first.GetTransaction() != nil {
+		t.Fatalf("first event = %v, want the instance at created", first)
+	}
+
+	if _, err = client.Fire(ctx, &FireRequest{InstanceId: "o1", Event: "pay", Payload: payload(t, 20)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Fire(ctx, &FireRequest{InstanceId: "o1", Event: "ship"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"paid", "shipped"} {
+		e, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.GetInstance().GetStatus() != want || e.GetTransaction().GetTarget() != want {
+			t.Fatalf("event = %v, want %s", e, want)
+		}
+	}
+
+	for id, want := range map[string]codes.Code{"": codes.InvalidArgument, "missing": codes.NotFound} {
+		stream, err = client.WatchInstance(ctx, &WatchInstanceRequest{InstanceId: id})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if code := status.Code(err); code != want {
+			t.Fatalf("WatchInstance(%q) code = %v, want %v", id, code, want)
+		}
+	}
+}
+
+func TestWatchInstanceBroker(t *testing.T) {
+	store := newTestStore(t)
+	broker := fsm.NewBroker()
+	client := newTestClient(t, store, ServiceBroker(broker))
+	ctx := testContext(t)
+
+	stream, err := client.WatchInstance(ctx, &WatchInstanceRequest{InstanceId: "o1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	// fired beside the service, e.g. by fsmhttp, with the same broker
+	inst, err := store.Get("o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst.Data = fsm.Data{"note": "fragile"}
+	_, _, err = fsm.FireInstance(ctx, fsm.Default(), store, inst, "pay", map[string]interface{}{"amount": 20.0},
+		fsm.MachinePublish[string, string](broker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetInstance().GetStatus() != "paid" || e.GetInstance().GetRevision() != 2 ||
+		e.GetInstance().GetData().AsMap()["note"] != "fragile" || e.GetTransaction().GetTarget() != "paid" {
+		t.Fatalf("event = %v, want paid with revision 2 and the note", e)
+	}
+
+	broker.Close()
+	if _, err = stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Recv() after closing the broker = %v, want %v", err, codes.Unavailable)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsmrpc
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/iTrellis/fsm"
+)
+
+// statusOf convert an error of the repo, the store or a machine into a status error
+func statusOf(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code := codes.Internal
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, fsm.ErrNamespaceNotFound), errors.Is(err, fsm.ErrTransactionNotFound),
+		errors.Is(err, fsm.ErrInstanceNotFound):
+		code = codes.NotFound
+	case errors.Is(err, fsm.ErrRevisionConflict):
+		code = codes.Aborted
+	case errors.Is(err, fsm.ErrGuardRejected):
+		code = codes.FailedPrecondition
+	case errors.Is(err, fsm.ErrSlowSubscriber):
+		code = codes.ResourceExhausted
+	case errors.Is(err, fsm.ErrBrokerClosed):
+		code = codes.Unavailable
+	case errors.Is(err, fsm.ErrInvalidExpr), errors.Is(err, fsm.ErrInvalidValue),
+		errors.Is(err, fsm.ErrInvalidAssignment), errors.Is(err, fsm.ErrInvalidSnapshot),
+		errors.Is(err, fsm.ErrInvalidInstance):
+		code = codes.InvalidArgument
+	}
+	return status.Error(code, err.Error())
+}
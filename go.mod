@@ -1,5 +1,19 @@
 module github.com/iTrellis/fsm
 
-go 1.13
+go 1.18
 
-require github.com/iTrellis/config v0.21.1
+require (
+	github.com/iTrellis/config v0.21.1
+	google.golang.org/grpc v1.55.0
+	google.golang.org/protobuf v1.30.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/iTrellis/common v0.21.1 // indirect
+	golang.org/x/net v0.8.0 // indirect
+	golang.org/x/sys v0.6.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
+	google.golang.org/genproto v0.0.0-20230306155012-7f2fa6fef1f4 // indirect
+)
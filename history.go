@@ -0,0 +1,102 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// HistoryRecord a transition fired by a machine
+type HistoryRecord[S, E comparable] struct {
+	Event E         `json:"event"`
+	From  S         `json:"from"`
+	To    S         `json:"to"`
+	Time  time.Time `json:"time"`
+	// PayloadDigest the sha256 of the payload in json, empty without payload
+	PayloadDigest string `json:"payload_digest,omitempty"`
+
+	// before and after the extended state, restored by undo and redo
+	before, after Data
+}
+
+// UndoPolicy judge whether a machine can move back along a record, or forward again when redo is true
+type UndoPolicy[S, E comparable] func(record *HistoryRecord[S, E], redo bool) bool
+
+// UndoAny allow to undo and redo every record
+func UndoAny[S, E comparable]() UndoPolicy[S, E] {
+	return func(*HistoryRecord[S, E], bool) bool {
+		return true
+	}
+}
+
+// UndoReversible allow to undo a record if the table has a transition from its target back to its source,
+// and to redo it if the recorded transition still exists
+func UndoReversible[S, E comparable](table *Table[S, E], namespace string) UndoPolicy[S, E] {
+	return func(record *HistoryRecord[S, E], redo bool) bool {
+		if redo {
+			t := table.GetTransition(namespace, record.From, record.Event)
+			return t != nil && t.TargetStatus == record.To
+		}
+		if record.From == record.To {
+			return true
+		}
+		for _, t := range table.GetAvailable(namespace, record.To) {
+			if t.TargetStatus == record.From {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// history a ring of records, records after cursor are undone and can be redone
+type history[S, E comparable] struct {
+	records []*HistoryRecord[S, E]
+	size    int
+	cursor  int
+	policy  UndoPolicy[S, E]
+}
+
+func (p *history[S, E]) record(r *HistoryRecord[S, E]) {
+	// a new transition drops the undone records
+	p.records = append(p.records[:p.cursor], r)
+	if len(p.records) > p.size {
+		p.records = p.records[len(p.records)-p.size:]
+	}
+	p.cursor = len(p.records)
+}
+
+func (p *history[S, E]) reset() {
+	p.records, p.cursor = nil, 0
+}
+
+// payloadDigest the sha256 of payload in json, whose map keys are ordered
+func payloadDigest(payload map[string]interface{}) string {
+	if payload == nil {
+		return ""
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,164 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"errors"
+	"testing"
+)
+
+// newReversibleOrder new a table of created -pay-> paid -ship-> shipped, with paid -refund-> created back
+func newReversibleOrder(t *testing.T) *Table[string, string] {
+	t.Helper()
+	table := NewTable[string, string]()
+	for _, tr := range []*Transaction{
+		{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"},
+		{Namespace: "order", CurrentStatus: "paid", Event: "refund", TargetStatus: "created"},
+		{Namespace: "order", CurrentStatus: "paid", Event: "ship", TargetStatus: "shipped"},
+	} {
+		if err := table.Add(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return table
+}
+
+func TestUndoReversible(t *testing.T) {
+	table := newReversibleOrder(t)
+	m, err := NewMachine(table, "order", "created",
+		MachineHistory(10, UndoReversible(table, "order")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// paid goes back to created by refund
+	if err = m.Fire("pay"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Undo(); err != nil || m.Current() != "created" {
+		t.Fatalf("Undo(pay) = %v at %s, want created", err, m.Current())
+	}
+	if err = m.Redo(); err != nil || m.Current() != "paid" {
+		t.Fatalf("Redo(pay) = %v at %s, want paid", err, m.Current())
+	}
+
+	// nothing goes back from shipped
+	if err = m.Fire("ship"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Undo(); !errors.Is(err, ErrUndoRefused) || m.Current() != "shipped" || len(m.History()) != 2 {
+		t.Fatalf("Undo(ship) = %v at %s with %d records, want %v at shipped", err, m.Current(), len(m.History()),
+			ErrUndoRefused)
+	}
+}
+
+func TestRedoReversibleRemoved(t *testing.T) {
+	table := newReversibleOrder(t)
+	m, err := NewMachine(table, "order", "created",
+		MachineHistory(10, UndoReversible(table, "order")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Fire("pay"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Undo(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a recorded transition which no longer exists is not redone
+	if err = table.RemoveTransition(&Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Redo(); !errors.Is(err, ErrUndoRefused) || m.Current() != "created" {
+		t.Fatalf("Redo() = %v at %s, want %v at created", err, m.Current(), ErrUndoRefused)
+	}
+}
+
+func TestRedoAfterFire(t *testing.T) {
+	m, err := NewMachine(newParityChecker(t), "parity", "even", MachineHistory[string, string](10, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []string{"1", "1"} {
+		if err = m.Fire(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = m.Undo(); err != nil || m.Current() != "odd" {
+		t.Fatalf("Undo() = %v at %s, want odd", err, m.Current())
+	}
+
+	// a new transition drops the undone one
+	if err = m.Fire("0"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Redo(); !errors.Is(err, ErrNoHistory) || m.Current() != "odd" {
+		t.Fatalf("Redo() after Fire = %v at %s, want %v at odd", err, m.Current(), ErrNoHistory)
+	}
+	records := m.History()
+	if len(records) != 2 || records[1].Event != "0" || records[1].From != "odd" || records[1].To != "odd" {
+		t.Fatalf("history = %+v, want 1 and 0", records)
+	}
+}
+
+func TestHistoryRing(t *testing.T) {
+	m, err := NewMachine(newParityChecker(t), "parity", "even", MachineHistory[string, string](3, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// even -1-> odd -0-> odd -1-> even -1-> odd -0-> odd
+	events := []string{"1", "0", "1", "1", "0"}
+	for _, event := range events {
+		if err = m.Fire(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records := m.History()
+	want := []struct{ event, from, to string }{{"1", "odd", "even"}, {"1", "even", "odd"}, {"0", "odd", "odd"}}
+	if len(records) != len(want) {
+		t.Fatalf("history has %d records, want %d", len(records), len(want))
+	}
+	for i, w := range want {
+		if r := records[i]; r.Event != w.event || r.From != w.from || r.To != w.to {
+			t.Errorf("record %d = %s %s -> %s, want %+v", i, r.Event, r.From, r.To, w)
+		}
+	}
+
+	for range want {
+		if err = m.Undo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the oldest transitions are dropped, so undoing stops after the second one
+	if err = m.Undo(); !errors.Is(err, ErrNoHistory) || m.Current() != "odd" {
+		t.Fatalf("Undo() past the ring = %v at %s, want %v at odd", err, m.Current(), ErrNoHistory)
+	}
+	if len(m.History()) != 0 {
+		t.Fatalf("history after undoing all = %+v", m.History())
+	}
+	for range want {
+		if err = m.Redo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = m.Redo(); !errors.Is(err, ErrNoHistory) || m.Current() != "odd" {
+		t.Fatalf("Redo() past the ring = %v at %s, want %v at odd", err, m.Current(), ErrNoHistory)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"sync"
+)
+
+// Logger a leveled logger with key and value pairs, which *slog.Logger implements
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// NopLogger a logger dropping everything, the default one
+type NopLogger struct{}
+
+// Debug drop the message
+func (NopLogger) Debug(msg string, keyvals ...interface{}) {}
+
+// Info drop the message
+func (NopLogger) Info(msg string, keyvals ...interface{}) {}
+
+// Warn drop the message
+func (NopLogger) Warn(msg string, keyvals ...interface{}) {}
+
+// Error drop the message
+func (NopLogger) Error(msg string, keyvals ...interface{}) {}
+
+var (
+	defaultLogger     Logger = NopLogger{}
+	defaultLoggerLock sync.RWMutex
+)
+
+// SetLogger set the logger of the repo, loading, watchers and machines created after it,
+// nil restores the no-op logger
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = NopLogger{}
+	}
+	defaultLoggerLock.Lock()
+	defer defaultLoggerLock.Unlock()
+	defaultLogger = logger
+}
+
+// getLogger get the logger set by SetLogger
+func getLogger() Logger {
+	defaultLoggerLock.RLock()
+	defer defaultLoggerLock.RUnlock()
+	return defaultLogger
+}
+
+// transactionFields the key and value pairs of a transaction in logs
+func transactionFields[S, E comparable](t *Transition[S, E]) []interface{} {
+	if t == nil {
+		return nil
+	}
+	return []interface{}{"namespace", t.Namespace, "current", t.CurrentStatus, "event", t.Event, "target", t.TargetStatus}
+}
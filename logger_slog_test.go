@@ -0,0 +1,70 @@
+//go:build go1.21
+
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	SetLogger(NewSlogLogger(slog.New(handler)))
+	defer SetLogger(nil)
+
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"})
+
+	m, err := NewRepoMachine(repo, "order", "created", MachineID[string, string]("o1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Fire("ship"); err == nil {
+		t.Fatal("Fire(ship) = nil, want an error")
+	}
+
+	want := []string{
+		`level=INFO msg="fsm: transactions removed"`,
+		`level=DEBUG msg="fsm: transaction added" namespace=order current=created event=pay target=paid`,
+		`level=WARN msg="fsm: transition failed" namespace=order event=ship from=created instance=o1 error="transaction not found"`,
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("slog output =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSlogLoggerDefault(t *testing.T) {
+	if logger := NewSlogLogger(nil); logger != Logger(slog.Default()) {
+		t.Fatalf("NewSlogLogger(nil) = %v, want slog.Default()", logger)
+	}
+}
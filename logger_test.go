@@ -0,0 +1,134 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger a logger which records every message as "level msg key=value ..."
+type recordingLogger struct {
+	lines []string
+
+	sync.Mutex
+}
+
+func (p *recordingLogger) record(level, msg string, keyvals []interface{}) {
+	p.Lock()
+	defer p.Unlock()
+	line := level + " " + msg
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		line += fmt.Sprintf(" %v=%v", keyvals[i], keyvals[i+1])
+	}
+	p.lines = append(p.lines, line)
+}
+
+func (p *recordingLogger) Debug(msg string, keyvals ...interface{}) { p.record("DEBUG", msg, keyvals) }
+func (p *recordingLogger) Info(msg string, keyvals ...interface{})  { p.record("INFO", msg, keyvals) }
+func (p *recordingLogger) Warn(msg string, keyvals ...interface{})  { p.record("WARN", msg, keyvals) }
+func (p *recordingLogger) Error(msg string, keyvals ...interface{}) { p.record("ERROR", msg, keyvals) }
+
+// find get the recorded lines containing all parts
+func (p *recordingLogger) find(parts ...string) []string {
+	p.Lock()
+	defer p.Unlock()
+	var found []string
+	for _, line := range p.lines {
+		matched := true
+		for _, part := range parts {
+			if !strings.Contains(line, part) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			found = append(found, line)
+		}
+	}
+	return found
+}
+
+// setRecordingLogger set a recording logger until the test ends
+func setRecordingLogger(t *testing.T) *recordingLogger {
+	t.Helper()
+	logger := &recordingLogger{}
+	SetLogger(logger)
+	t.Cleanup(func() { SetLogger(nil) })
+	return logger
+}
+
+func TestRepoLogging(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	logger := setRecordingLogger(t)
+
+	pay := &Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"}
+	repo.Add(pay)
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "created", TargetStatus: "canceled"})
+	repo.RemoveByTransaction(pay)
+	repo.RemoveByTransaction(&Transaction{CurrentStatus: "created", Event: "cancel"})
+	repo.RemoveNamespace("order")
+
+	dir := t.TempDir()
+	valid, invalid := filepath.Join(dir, "order.yaml"), filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(valid, []byte("fsm:\n  order:\n    pay:\n      current: created\n      event: pay\n"+
+		"      target: paid\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(invalid, []byte("fsm:\n  order:\n    pay:\n      current: created\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(valid); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(invalid); err == nil {
+		t.Fatal("LoadFile(invalid) = nil, want an error")
+	}
+	w := NewWatcher(valid)
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(valid, []byte("fsm: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reload(); err == nil {
+		t.Fatal("Reload(invalid) = nil, want an error")
+	}
+
+	for _, want := range [][]string{
+		{"DEBUG fsm: transaction added", "namespace=order", "event=pay", "target=paid"},
+		{"WARN fsm: transaction rejected", "target=canceled", "error="},
+		{"INFO fsm: transaction removed", "event=pay"},
+		{"WARN fsm: transaction not removed", "event=cancel", "error="},
+		{"INFO fsm: namespace removed", "namespace=order"},
+		{"INFO fsm: definition loaded", "file=" + valid, "namespaces=[order]", "transactions=1"},
+		{"ERROR fsm: definition not loaded", "file=" + invalid, "error="},
+		{"INFO fsm: definition reloaded", "file=" + valid, "namespaces=[order]"},
+		{"ERROR fsm: reload failed, the old definition is kept", "file=" + valid, "error="},
+	} {
+		if found := logger.find(want...); len(found) != 1 {
+			t.Errorf("found %d lines with %q in\n%s", len(found), want, strings.Join(logger.lines, "\n"))
+		}
+	}
+}
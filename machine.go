@@ -0,0 +1,576 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Machine an instance walking in a namespace of a table, it is safe for concurrent use
+type Machine[S, E comparable] struct {
+	table     *Table[S, E]
+	namespace string
+	id        string
+	current   S
+	// data the extended state, replaced as a whole by transitions
+	data     Data
+	revision int64
+
+	guard   func(t *Transition[S, E], data Data) bool
+	onExit  func(status S, t *Transition[S, E])
+	onEnter func(status S, t *Transition[S, E])
+	history *history[S, E]
+	audit   *AuditSink
+	metrics Metrics
+	tracer  Tracer
+	logger  Logger
+	broker  *Broker
+
+	sync.RWMutex
+}
+
+// MachineOption machine option function
+type MachineOption[S, E comparable] func(*Machine[S, E])
+
+// MachineGuard set the function judging whether a transition can be fired with the extended state,
+// a rejected event fails with ErrGuardRejected, the function must not change data or call the machine
+func MachineGuard[S, E comparable](fn func(t *Transition[S, E], data Data) bool) MachineOption[S, E] {
+	return func(m *Machine[S, E]) {
+		m.guard = fn
+	}
+}
+
+// MachineOnExit set the function called before leaving a status,
+// it is not called by internal transitions and must not call the machine
+func MachineOnExit[S, E comparable](fn func(status S, t *Transition[S, E])) MachineOption[S, E] {
+	return func(m *Machine[S, E]) {
+		m.onExit = fn
+	}
+}
+
+// MachineOnEnter set the function called after entering a status,
+// it is not called by internal transitions and must not call the machine
+func MachineOnEnter[S, E comparable](fn func(status S, t *Transition[S, E])) MachineOption[S, E] {
+	return func(m *Machine[S, E]) {
+		m.onEnter = fn
+	}
+}
+
+// MachineID set the id of the instance the machine walks for, which is written in audit logs
+func MachineID[S, E comparable](id string) MachineOption[S, E] {
+	return func(m *Machine[S, E]) {
+		m.id = id
+	}
+}
+
+// MachineAudit write every fired transition to the audit log before the status changes,
+// the transition fails if it can not be written
+func MachineAudit[S, E comparable](sink *AuditSink) MachineOption[S, E] {
+	return func(m *Machine[S, E]) {
+		m.audit = sink
+	}
+}
+
+// MachineMetrics observe lookups, fired transitions and guard rejections of the machine,
+// statuses and events are formatted with fmt.Sprint
+func MachineMetrics[S, E comparable](metrics Metrics) MachineOption[S, E] {
+	return func(m *Machine[S, E]) {
+		m.metrics = metrics
+	}
+}
+
+// MachineTracer start a span for every fired event, with child spans for guards and exit and entry functions,
+// statuses and events are formatted with fmt.Sprint
+func MachineTracer[S, E comparable](tracer Tracer) MachineOption[S, E] {
+	return func(m *Machine[S, E]) {
+		m.tracer = tracer
+	}
+}
+
+// MachineLogger set the logger of fired and failed transitions, the one set by SetLogger by default
+func MachineLogger[S, E comparable](logger Logger) MachineOption[S, E] {
+	return func(m *Machine[S, E]) {
+		m.logger = logger
+	}
+}
+
+// MachinePublish publish every fired, undone and redone transition to the broker after the status changes,
+// FireInstance publishes only after the instance is put
+func MachinePublish[S, E comparable](broker *Broker) MachineOption[S, E] {
+	return func(m *Machine[S, E]) {
+		m.broker = broker
+	}
+}
+
+// MachineHistory record the last size fired transitions for undo and redo,
+// the policy judges which records can be undone and redone, all of them if it is nil
+func MachineHistory[S, E comparable](size int, policy UndoPolicy[S, E]) MachineOption[S, E] {
+	return func(m *Machine[S, E]) {
+		if size <= 0 {
+			m.history = nil
+			return
+		}
+		if policy == nil {
+			policy = UndoAny[S, E]()
+		}
+		m.history = &history[S, E]{size: size, policy: policy}
+	}
+}
+
+// NewMachine new a machine at status in namespace of the table,
+// variables of the namespace start with their default values
+func NewMachine[S, E comparable](table *Table[S, E], namespace string, status S, opts ...MachineOption[S, E]) (*Machine[S, E], error) {
+	data, err := initialData(table.variablesOf(namespace), nil)
+	if err != nil {
+		return nil, err
+	}
+	m := &Machine[S, E]{table: table, namespace: namespace, current: status, data: data, logger: getLogger()}
+	for _, o := range opts {
+		o(m)
+	}
+	if _, ok := m.logger.(NopLogger); ok {
+		m.logger = nil
+	}
+	return m, nil
+}
+
+// NewRepoMachine new a machine of string statuses and events at status in namespace of the repo
+func NewRepoMachine(repo TableRepo, namespace, status string, opts ...MachineOption[string, string]) (*Machine[string, string], error) {
+	return NewMachine(repo.Table(), namespace, status, opts...)
+}
+
+// Namespace get the namespace of the machine
+func (p *Machine[S, E]) Namespace() string {
+	return p.namespace
+}
+
+// ID get the id of the instance the machine walks for
+func (p *Machine[S, E]) ID() string {
+	return p.id
+}
+
+// Current get current status
+func (p *Machine[S, E]) Current() S {
+	p.RLock()
+	defer p.RUnlock()
+	return p.current
+}
+
+// Data get a copy of the extended state
+func (p *Machine[S, E]) Data() Data {
+	p.RLock()
+	defer p.RUnlock()
+	return p.data.Copy()
+}
+
+// Snapshot get the status and extended state of the machine
+func (p *Machine[S, E]) Snapshot() *Snapshot[S] {
+	p.RLock()
+	defer p.RUnlock()
+	return p.snapshot()
+}
+
+func (p *Machine[S, E]) snapshot() *Snapshot[S] {
+	return &Snapshot[S]{
+		Namespace: p.namespace,
+		Status:    p.current,
+		Data:      p.data.Copy(),
+		Revision:  p.revision,
+	}
+}
+
+// Restore move the machine to a snapshot of the same namespace,
+// values are converted to types of the declared variables, and missing ones get their defaults
+func (p *Machine[S, E]) Restore(s *Snapshot[S]) error {
+	if s == nil || (s.Namespace != "" && s.Namespace != p.namespace) {
+		return ErrInvalidSnapshot
+	}
+	data, err := initialData(p.table.variablesOf(p.namespace), s.Data)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.current, p.data, p.revision = s.Status, data, s.Revision
+	if p.history != nil {
+		p.history.reset()
+	}
+	return nil
+}
+
+// History get copies of the recorded transitions which can be undone, the oldest first
+func (p *Machine[S, E]) History() []HistoryRecord[S, E] {
+	p.RLock()
+	defer p.RUnlock()
+	if p.history == nil {
+		return nil
+	}
+	records := make([]HistoryRecord[S, E], 0, p.history.cursor)
+	for _, r := range p.history.records[:p.history.cursor] {
+		records = append(records, *r)
+	}
+	return records
+}
+
+// Undo move back to the source status and extended state of the last transition,
+// hooks are not called, and it fails with ErrUndoRefused if the policy does not allow it.
+// It is audited and published as an undo action of the transition's event
+func (p *Machine[S, E]) Undo() error {
+	p.Lock()
+	defer p.Unlock()
+	h := p.history
+	if h == nil || h.cursor == 0 {
+		return ErrNoHistory
+	}
+	r := h.records[h.cursor-1]
+	if !h.policy(r, false) {
+		return ErrUndoRefused
+	}
+	if p.audit != nil {
+		if err := p.audit.Write(p.auditEntry(ActionUndo, r.Event, p.current, r.From, time.Now(), "")); err != nil {
+			return err
+		}
+	}
+	h.cursor--
+	from := p.current
+	p.current, p.data = r.From, r.before.Copy()
+	p.revision++
+	if p.broker != nil {
+		p.broker.Publish(p.transitionEvent(ActionUndo, r.Event, from, p.current))
+	}
+	return nil
+}
+
+// Redo fire the last undone transition again as it was recorded,
+// hooks are not called, and it fails with ErrUndoRefused if the policy does not allow it.
+// It is audited and published as a redo action of the transition's event
+func (p *Machine[S, E]) Redo() error {
+	p.Lock()
+	defer p.Unlock()
+	h := p.history
+	if h == nil || h.cursor == len(h.records) {
+		return ErrNoHistory
+	}
+	r := h.records[h.cursor]
+	if !h.policy(r, true) {
+		return ErrUndoRefused
+	}
+	if p.audit != nil {
+		if err := p.audit.Write(p.auditEntry(ActionRedo, r.Event, p.current, r.To, time.Now(), "")); err != nil {
+			return err
+		}
+	}
+	h.cursor++
+	from := p.current
+	p.current, p.data = r.To, r.after.Copy()
+	p.revision++
+	if p.broker != nil {
+		p.broker.Publish(p.transitionEvent(ActionRedo, r.Event, from, p.current))
+	}
+	return nil
+}
+
+// Can judge the event can be fired in current status, guards are evaluated without payload
+func (p *Machine[S, E]) Can(event E) bool {
+	p.RLock()
+	defer p.RUnlock()
+	t := p.table.GetTransition(p.namespace, p.current, event)
+	return t != nil && p.allow(t, nil) == nil
+}
+
+// Available get transitions which can be fired in current status
+func (p *Machine[S, E]) Available() []*Transition[S, E] {
+	p.RLock()
+	defer p.RUnlock()
+	return p.table.GetAvailable(p.namespace, p.current)
+}
+
+// Fire fire an event and move to the target status,
+// exit and entry functions run for normal and external transitions
+func (p *Machine[S, E]) Fire(event E) error {
+	return p.FireWith(event, nil)
+}
+
+// FireWith fire an event with the payload read by the guard expression,
+// a false guard fails with ErrGuardRejected, and a guard which can not be evaluated with an *ExprError
+func (p *Machine[S, E]) FireWith(event E, payload map[string]interface{}) error {
+	return p.FireContext(context.Background(), event, payload)
+}
+
+// FireContext fire an event with the payload, spans of the tracer are children of the span in ctx
+func (p *Machine[S, E]) FireContext(ctx context.Context, event E, payload map[string]interface{}) error {
+	p.Lock()
+	defer p.Unlock()
+	_, err := p.fire(ctx, event, payload)
+	return err
+}
+
+// Output get the Moore output of current status, empty if not set
+func (p *Machine[S, E]) Output() string {
+	p.RLock()
+	defer p.RUnlock()
+	return p.table.GetOutput(p.namespace, p.current)
+}
+
+// Step fire an input event and get its output,
+// the Mealy output of the transition if it has one, otherwise the Moore output of the new status
+func (p *Machine[S, E]) Step(input E) (string, error) {
+	p.Lock()
+	defer p.Unlock()
+	t, err := p.fire(context.Background(), input, nil)
+	if err != nil {
+		return "", err
+	}
+	if t.Output != "" {
+		return t.Output, nil
+	}
+	return p.table.GetOutput(p.namespace, p.current), nil
+}
+
+// Transduce step the inputs in order and get an output per input,
+// it stops at the first input which can not be fired, and returns the outputs before it
+func (p *Machine[S, E]) Transduce(inputs []E) ([]string, error) {
+	outputs := make([]string, 0, len(inputs))
+	for i, input := range inputs {
+		output, err := p.Step(input)
+		if err != nil {
+			return outputs, fmt.Errorf("input %d: %w", i, err)
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// allow check the guard expression and function of the transition
+func (p *Machine[S, E]) allow(t *Transition[S, E], payload map[string]interface{}) error {
+	if t.guard != nil {
+		ok, err := t.guard.EvalBool(&ExprEnv{Payload: payload, State: p.data})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrGuardRejected
+		}
+	}
+	if p.guard != nil && !p.guard(t, p.data) {
+		return ErrGuardRejected
+	}
+	return nil
+}
+
+func (p *Machine[S, E]) fire(ctx context.Context, event E, payload map[string]interface{}) (*Transition[S, E], error) {
+	if p.metrics == nil && p.tracer == nil && p.logger == nil && p.broker == nil {
+		return p.transit(ctx, event, payload)
+	}
+	var span Span
+	if p.tracer != nil {
+		ctx, span = p.tracer.Start(ctx, SpanFire, p.attributes(event, p.current)...)
+	}
+	from, start := p.current, time.Now()
+	t, err := p.transit(ctx, event, payload)
+
+	p.observe(event, from, t, err, time.Since(start))
+	if p.broker != nil && err == nil {
+		p.broker.Publish(p.transitionEvent("", event, from, t.TargetStatus))
+	}
+	if span != nil {
+		if t != nil {
+			span.SetAttributes(Attribute{Key: AttributeTo, Value: fmt.Sprint(t.TargetStatus)})
+		}
+		if t != nil && t.Kind != TransitionNormal {
+			span.SetAttributes(Attribute{Key: AttributeKind, Value: string(t.Kind)})
+		}
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
+	}
+	return t, err
+}
+
+// observe record the metrics and the log of firing the event from the status
+func (p *Machine[S, E]) observe(event E, from S, t *Transition[S, E], err error, elapsed time.Duration) {
+	if p.metrics != nil {
+		switch {
+		case err == nil:
+			p.metrics.ObserveTransition(p.namespace, fmt.Sprint(event), fmt.Sprint(from), fmt.Sprint(t.TargetStatus),
+				elapsed)
+		case errors.Is(err, ErrGuardRejected):
+			p.metrics.ObserveGuardRejection(p.namespace, fmt.Sprint(event), fmt.Sprint(from))
+		}
+	}
+	if p.logger != nil {
+		p.log(event, from, t, err)
+	}
+}
+
+// auditEntry get the audit entry of an action of the event's transition from the status to the status
+func (p *Machine[S, E]) auditEntry(action string, event E, from, to S, now time.Time, digest string) *AuditEntry {
+	return &AuditEntry{
+		Time:          now,
+		Namespace:     p.namespace,
+		Instance:      p.id,
+		Event:         fmt.Sprint(event),
+		Action:        action,
+		From:          fmt.Sprint(from),
+		To:            fmt.Sprint(to),
+		PayloadDigest: digest,
+	}
+}
+
+// transitionEvent get the published event of an action of the event's transition from the status to the status
+func (p *Machine[S, E]) transitionEvent(action string, event E, from, to S) *TransitionEvent {
+	return &TransitionEvent{
+		Namespace: p.namespace,
+		Instance:  p.id,
+		Event:     fmt.Sprint(event),
+		Action:    action,
+		From:      fmt.Sprint(from),
+		To:        fmt.Sprint(to),
+		Time:      time.Now(),
+	}
+}
+
+// log log a fired transition, or the error of firing the event
+func (p *Machine[S, E]) log(event E, from S, t *Transition[S, E], err error) {
+	fields := []interface{}{"namespace", p.namespace, "event", fmt.Sprint(event), "from", fmt.Sprint(from)}
+	if p.id != "" {
+		fields = append(fields, "instance", p.id)
+	}
+	if err != nil {
+		p.logger.Warn("fsm: transition failed", append(fields, "error", err)...)
+		return
+	}
+	p.logger.Debug("fsm: transition fired", append(fields, "to", fmt.Sprint(t.TargetStatus))...)
+}
+
+// attributes get the span attributes of firing the event from the status
+func (p *Machine[S, E]) attributes(event E, from S) []Attribute {
+	attrs := []Attribute{
+		{Key: AttributeNamespace, Value: p.namespace},
+		{Key: AttributeEvent, Value: fmt.Sprint(event)},
+		{Key: AttributeFrom, Value: fmt.Sprint(from)},
+	}
+	if p.id != "" {
+		attrs = append(attrs, Attribute{Key: AttributeInstance, Value: p.id})
+	}
+	return attrs
+}
+
+// check check the guards of the transition in a span of the tracer
+func (p *Machine[S, E]) check(ctx context.Context, t *Transition[S, E], payload map[string]interface{}) error {
+	if p.tracer == nil || (t.guard == nil && p.guard == nil) {
+		return p.allow(t, payload)
+	}
+	attrs := append(p.attributes(t.Event, p.current), Attribute{Key: AttributeTo, Value: fmt.Sprint(t.TargetStatus)})
+	if t.Guard != "" {
+		attrs = append(attrs, Attribute{Key: AttributeGuard, Value: t.Guard})
+	}
+	_, span := p.tracer.Start(ctx, SpanGuard, attrs...)
+	err := p.allow(t, payload)
+	if err != nil {
+		span.SetError(err)
+	}
+	span.End()
+	return err
+}
+
+// act call the exit or entry function with the status in a span of the tracer
+func (p *Machine[S, E]) act(ctx context.Context, action string, fn func(status S, t *Transition[S, E]),
+	status, from S, t *Transition[S, E]) {
+	if fn == nil {
+		return
+	}
+	if p.tracer == nil {
+		fn(status, t)
+		return
+	}
+	attrs := append(p.attributes(t.Event, from),
+		Attribute{Key: AttributeTo, Value: fmt.Sprint(t.TargetStatus)}, Attribute{Key: AttributeAction, Value: action})
+	_, span := p.tracer.Start(ctx, SpanAction, attrs...)
+	defer span.End()
+	fn(status, t)
+}
+
+// lookup get the transition of the event in current status
+func (p *Machine[S, E]) lookup(event E) *Transition[S, E] {
+	if p.metrics == nil {
+		return p.table.GetTransition(p.namespace, p.current, event)
+	}
+	start := time.Now()
+	t := p.table.GetTransition(p.namespace, p.current, event)
+	p.metrics.ObserveLookup(p.namespace, fmt.Sprint(p.current), fmt.Sprint(event), t != nil, time.Since(start))
+	return t
+}
+
+// transit move to the target status of the event
+func (p *Machine[S, E]) transit(ctx context.Context, event E, payload map[string]interface{}) (*Transition[S, E], error) {
+	t := p.lookup(event)
+	if t == nil {
+		return nil, ErrTransactionNotFound
+	}
+	if err := p.check(ctx, t, payload); err != nil {
+		return nil, err
+	}
+	data, err := assign(p.table.variablesOf(p.namespace), p.data, t.Assignments)
+	if err != nil {
+		return nil, err
+	}
+	now, digest := time.Now(), payloadDigest(payload)
+	if p.audit != nil {
+		if err = p.audit.Write(p.auditEntry("", event, p.current, t.TargetStatus, now, digest)); err != nil {
+			return nil, err
+		}
+	}
+	if p.history != nil {
+		p.history.record(&HistoryRecord[S, E]{
+			Event:         event,
+			From:          p.current,
+			To:            t.TargetStatus,
+			Time:          now,
+			PayloadDigest: digest,
+			before:        p.data,
+			after:         data,
+		})
+	}
+	p.data = data
+	p.revision++
+	if t.Kind == TransitionInternal {
+		return t, nil
+	}
+
+	from := p.current
+	p.act(ctx, "exit", p.onExit, from, from, t)
+	p.current = t.TargetStatus
+	p.act(ctx, "enter", p.onEnter, p.current, from, t)
+	return t, nil
+}
+
+// Snapshot the status and extended state of a machine, which are persisted together
+type Snapshot[S comparable] struct {
+	Namespace string `json:"namespace"`
+	Status    S      `json:"status"`
+	Data      Data   `json:"data,omitempty"`
+	// Revision the revision of the stored instance the snapshot comes from,
+	// increased by every transition, undo and redo of the machine since
+	Revision int64 `json:"revision"`
+}
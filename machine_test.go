@@ -0,0 +1,180 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// newEdgeDetector a Mealy machine which emits rise and fall on edges of a bit stream
+func newEdgeDetector(t *testing.T) *Table[string, string] {
+	t.Helper()
+	table := NewTable[string, string]()
+	for _, tr := range []*Transaction{
+		{Namespace: "edge", CurrentStatus: "low", Event: "0", TargetStatus: "low", Output: "-"},
+		{Namespace: "edge", CurrentStatus: "low", Event: "1", TargetStatus: "high", Output: "rise"},
+		{Namespace: "edge", CurrentStatus: "high", Event: "1", TargetStatus: "high", Output: "-"},
+		{Namespace: "edge", CurrentStatus: "high", Event: "0", TargetStatus: "low", Output: "fall"},
+	} {
+		if err := table.Add(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return table
+}
+
+// newParityChecker a Moore machine whose status output is the parity of 1s read so far
+func newParityChecker(t *testing.T) *Table[string, string] {
+	t.Helper()
+	table := NewTable[string, string]()
+	for _, tr := range []*Transaction{
+		{Namespace: "parity", CurrentStatus: "even", Event: "0", TargetStatus: "even"},
+		{Namespace: "parity", CurrentStatus: "even", Event: "1", TargetStatus: "odd"},
+		{Namespace: "parity", CurrentStatus: "odd", Event: "0", TargetStatus: "odd"},
+		{Namespace: "parity", CurrentStatus: "odd", Event: "1", TargetStatus: "even"},
+	} {
+		if err := table.Add(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table.SetOutput("parity", "even", "0")
+	table.SetOutput("parity", "odd", "1")
+	return table
+}
+
+func TestMachineTransduceMealy(t *testing.T) {
+	m, err := NewMachine(newEdgeDetector(t), "edge", "low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Transduce([]string{"0", "1", "1", "0", "1", "0", "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"-", "rise", "-", "fall", "rise", "fall", "-"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Transduce = %v, want %v", got, want)
+	}
+	if m.Current() != "low" {
+		t.Fatalf("Current = %q, want low", m.Current())
+	}
+}
+
+func TestMachineTransduceMoore(t *testing.T) {
+	m, err := NewMachine(newParityChecker(t), "parity", "even")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Output(); got != "0" {
+		t.Fatalf("Output = %q, want 0", got)
+	}
+	got, err := m.Transduce([]string{"1", "0", "1", "1", "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1", "1", "0", "1", "1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Transduce = %v, want %v", got, want)
+	}
+	if m.Current() != "odd" {
+		t.Fatalf("Current = %q, want odd", m.Current())
+	}
+}
+
+func TestMachineTransduceError(t *testing.T) {
+	m, err := NewMachine(newParityChecker(t), "parity", "even")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Transduce([]string{"1", "2", "1"})
+	if !errors.Is(err, ErrTransactionNotFound) {
+		t.Fatalf("Transduce error = %v, want %v", err, ErrTransactionNotFound)
+	}
+	if want := []string{"1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Transduce = %v, want the outputs before the failed input %v", got, want)
+	}
+	if m.Current() != "odd" {
+		t.Fatalf("Current = %q, want odd", m.Current())
+	}
+}
+
+func TestMachineRevision(t *testing.T) {
+	m, err := NewMachine(newParityChecker(t), "parity", "even",
+		MachineHistory[string, string](10, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Restore(&Snapshot[string]{Status: "even", Revision: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []func() error{
+		func() error { return m.Fire("1") },
+		func() error { return m.Fire("0") },
+		m.Undo,
+		m.Redo,
+	} {
+		if err = step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := m.Snapshot().Revision; got != 7 {
+		t.Fatalf("Revision = %d, want 7", got)
+	}
+	if err = m.Fire("2"); err == nil {
+		t.Fatal("Fire(2) succeeded")
+	}
+	if got := m.Snapshot().Revision; got != 7 {
+		t.Fatalf("Revision after a failed fire = %d, want 7", got)
+	}
+
+	inst := &Instance{ID: "p1", Namespace: "parity", Status: "even", Revision: 3}
+	inst.SetSnapshot(m.Snapshot())
+	if inst.Revision != 3 || inst.Status != "odd" {
+		t.Fatalf("SetSnapshot = %+v, want status odd at revision 3", inst)
+	}
+}
+
+func TestTableAssignmentsCopied(t *testing.T) {
+	table := NewTable[string, string]()
+	if err := table.SetVariable(&Variable{Namespace: "order", Name: "retries", Type: VariableInt}); err != nil {
+		t.Fatal(err)
+	}
+	tr := &Transaction{Namespace: "order", CurrentStatus: "failed", Event: "retry", TargetStatus: "paying",
+		Assignments: []*Assignment{{Variable: "retries", Op: AssignAdd, Value: int64(1)}}}
+	if err := table.Add(tr); err != nil {
+		t.Fatal(err)
+	}
+	tr.Assignments[0].Value = int64(100)
+
+	ts := table.GetTransitions("order")
+	ts[0].Assignments[0].Value = "changed"
+	table.GetAvailable("order", "failed")[0].Assignments[0].Value = "changed"
+
+	m, err := NewMachine(table, "order", "failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Fire("retry"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Data()["retries"]; got != int64(1) {
+		t.Fatalf("retries = %v, want 1", got)
+	}
+}
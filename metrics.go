@@ -0,0 +1,308 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics the observer of lookups and transitions, implementations must be safe for concurrent use
+type Metrics interface {
+	// ObserveLookup observe a lookup of the transition of an event, found is false on misses
+	ObserveLookup(namespace, status, event string, found bool, elapsed time.Duration)
+	// ObserveTransition observe a fired transition, elapsed is the time of firing it
+	ObserveTransition(namespace, event, from, to string, elapsed time.Duration)
+	// ObserveGuardRejection observe an event rejected by guards
+	ObserveGuardRejection(namespace, event, from string)
+}
+
+// MetricsUnknownNamespace the namespace label of lookups in namespaces which are not in the repo
+const MetricsUnknownNamespace = "<unknown>"
+
+// DefaultMetricsBuckets the default upper bounds of latency histograms in seconds
+var DefaultMetricsBuckets = []float64{0.000001, 0.00001, 0.0001, 0.001, 0.01, 0.1, 1}
+
+// MetricsRegistry the metrics kept in memory and exposed in the prometheus text format,
+// it is an http.Handler of the exposition
+type MetricsRegistry struct {
+	prefix  string
+	buckets []float64
+
+	lookups     *metricFamily
+	misses      *metricFamily
+	lookupTime  *metricFamily
+	transitions *metricFamily
+	fireTime    *metricFamily
+	rejections  *metricFamily
+
+	sync.Mutex
+}
+
+// MetricsOption metrics registry option function
+type MetricsOption func(*MetricsRegistry)
+
+// MetricsPrefix set the prefix of metric names, fsm by default
+func MetricsPrefix(prefix string) MetricsOption {
+	return func(r *MetricsRegistry) {
+		r.prefix = prefix
+	}
+}
+
+// MetricsBuckets set the upper bounds of latency histograms in seconds
+func MetricsBuckets(buckets ...float64) MetricsOption {
+	return func(r *MetricsRegistry) {
+		r.buckets = append([]float64(nil), buckets...)
+		sort.Float64s(r.buckets)
+	}
+}
+
+// NewMetricsRegistry new a metrics registry
+func NewMetricsRegistry(opts ...MetricsOption) *MetricsRegistry {
+	r := &MetricsRegistry{prefix: "fsm", buckets: DefaultMetricsBuckets}
+	for _, o := range opts {
+		o(r)
+	}
+	name := func(s string) string {
+		if r.prefix == "" {
+			return s
+		}
+		return r.prefix + "_" + s
+	}
+	r.lookups = newMetricFamily(name("lookups_total"), "counter",
+		"Lookups of transitions by result.", "namespace", "result")
+	r.misses = newMetricFamily(name("lookup_misses_total"), "counter",
+		"Lookups which found no transition.", "namespace")
+	r.lookupTime = newMetricFamily(name("lookup_duration_seconds"), "histogram",
+		"Latency of lookups of transitions.", "namespace")
+	r.transitions = newMetricFamily(name("transitions_total"), "counter",
+		"Fired transitions.", "namespace", "event", "from", "to")
+	r.fireTime = newMetricFamily(name("transition_duration_seconds"), "histogram",
+		"Latency of firing transitions.", "namespace", "event")
+	r.rejections = newMetricFamily(name("guard_rejections_total"), "counter",
+		"Events rejected by guards.", "namespace", "event", "from")
+	return r
+}
+
+// ObserveLookup observe a lookup of the transition of an event,
+// status and event are not labels because they may come from clients without bound
+func (p *MetricsRegistry) ObserveLookup(namespace, status, event string, found bool, elapsed time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	result := "hit"
+	if !found {
+		result = "miss"
+		p.misses.series(namespace).count++
+	}
+	p.lookups.series(namespace, result).count++
+	p.lookupTime.series(namespace).observe(p.buckets, elapsed.Seconds())
+}
+
+// ObserveTransition observe a fired transition
+func (p *MetricsRegistry) ObserveTransition(namespace, event, from, to string, elapsed time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	p.transitions.series(namespace, event, from, to).count++
+	p.fireTime.series(namespace, event).observe(p.buckets, elapsed.Seconds())
+}
+
+// ObserveGuardRejection observe an event rejected by guards
+func (p *MetricsRegistry) ObserveGuardRejection(namespace, event, from string) {
+	p.Lock()
+	defer p.Unlock()
+	p.rejections.series(namespace, event, from).count++
+}
+
+// WriteTo write the metrics in the prometheus text exposition format
+func (p *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	p.Lock()
+	defer p.Unlock()
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range []*metricFamily{p.lookups, p.misses, p.lookupTime, p.transitions, p.fireTime, p.rejections} {
+		f.write(bw, p.buckets)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serve the metrics in the prometheus text exposition format
+func (p *MetricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = p.WriteTo(w)
+}
+
+type metricFamily struct {
+	name   string
+	typ    string
+	help   string
+	labels []string
+	values map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labels []string
+	// count the value of counters, or the number of observations of histograms
+	count   float64
+	sum     float64
+	buckets []uint64
+}
+
+func newMetricFamily(name, typ, help string, labels ...string) *metricFamily {
+	return &metricFamily{name: name, typ: typ, help: help, labels: labels, values: make(map[string]*metricSeries)}
+}
+
+// series get the series of the label values, it is created at the first time
+func (p *metricFamily) series(labels ...string) *metricSeries {
+	key := strings.Join(labels, "\xff")
+	s, ok := p.values[key]
+	if !ok {
+		s = &metricSeries{labels: labels}
+		p.values[key] = s
+	}
+	return s
+}
+
+func (p *metricSeries) observe(buckets []float64, v float64) {
+	if p.buckets == nil {
+		p.buckets = make([]uint64, len(buckets))
+	}
+	for i, upper := range buckets {
+		if v <= upper {
+			p.buckets[i]++
+		}
+	}
+	p.count++
+	p.sum += v
+}
+
+func (p *metricFamily) write(w *bufio.Writer, buckets []float64) {
+	if len(p.values) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(p.values))
+	for key := range p.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w.WriteString("# HELP " + p.name + " " + p.help + "\n")
+	w.WriteString("# TYPE " + p.name + " " + p.typ + "\n")
+	for _, key := range keys {
+		s := p.values[key]
+		if p.typ != "histogram" {
+			w.WriteString(p.name + p.labelSet(s.labels, "", 0) + " " + formatMetric(s.count) + "\n")
+			continue
+		}
+		for i, upper := range buckets {
+			w.WriteString(p.name + "_bucket" + p.labelSet(s.labels, "le", upper) + " " +
+				strconv.FormatUint(s.buckets[i], 10) + "\n")
+		}
+		w.WriteString(p.name + "_bucket" + p.labelSet(s.labels, "le", math.Inf(1)) + " " + formatMetric(s.count) + "\n")
+		w.WriteString(p.name + "_sum" + p.labelSet(s.labels, "", 0) + " " + formatMetric(s.sum) + "\n")
+		w.WriteString(p.name + "_count" + p.labelSet(s.labels, "", 0) + " " + formatMetric(s.count) + "\n")
+	}
+}
+
+// labelSet format the labels of a series, with the le label of histogram buckets if it is not empty
+func (p *metricFamily) labelSet(values []string, le string, upper float64) string {
+	var b strings.Builder
+	for i, value := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(p.labels[i] + `="` + escapeLabel(value) + `"`)
+	}
+	if le != "" {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(le + `="` + formatMetric(upper) + `"`)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "{" + b.String() + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatMetric(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (p *countWriter) Write(data []byte) (int, error) {
+	n, err := p.w.Write(data)
+	p.n += int64(n)
+	return n, err
+}
+
+// InstrumentRepo wrap a repo to observe its lookups of target transactions
+func InstrumentRepo(repo TableRepo, metrics Metrics) TableRepo {
+	return &instrumentedRepo{TableRepo: repo, metrics: metrics}
+}
+
+type instrumentedRepo struct {
+	TableRepo
+	metrics Metrics
+}
+
+// GetTargetTranstion get trans by current information and observe the lookup,
+// a namespace which is not in the repo is observed as MetricsUnknownNamespace
+func (p *instrumentedRepo) GetTargetTranstion(namespace, curStatus, event string) *Transaction {
+	start := time.Now()
+	t := p.TableRepo.GetTargetTranstion(namespace, curStatus, event)
+	elapsed := time.Since(start)
+	if t == nil && !p.Table().hasNamespace(namespace) {
+		namespace = MetricsUnknownNamespace
+	}
+	p.metrics.ObserveLookup(namespace, curStatus, event, t != nil, elapsed)
+	return t
+}
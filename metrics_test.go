@@ -0,0 +1,60 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentRepoMissLabels(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"})
+
+	metrics := NewMetricsRegistry()
+	instrumented := InstrumentRepo(repo, metrics)
+	instrumented.GetTargetTranstion("order", "created", "pay")
+	for _, event := range []string{"a", "b", "c"} {
+		instrumented.GetTargetTranstion("order", "created", event)
+		instrumented.GetTargetTranstion("order-"+event, "created", event)
+	}
+
+	var buf bytes.Buffer
+	if _, err := metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var misses []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "fsm_lookup_misses_total{") {
+			misses = append(misses, line)
+		}
+	}
+	want := []string{
+		`fsm_lookup_misses_total{namespace="<unknown>"} 3`,
+		`fsm_lookup_misses_total{namespace="order"} 3`,
+	}
+	if strings.Join(misses, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("misses =\n%s\nwant\n%s", strings.Join(misses, "\n"), strings.Join(want, "\n"))
+	}
+	if !strings.Contains(buf.String(), `fsm_lookups_total{namespace="order",result="hit"} 1`) {
+		t.Fatalf("hit not counted:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"fmt"
+)
+
+// Migration how instances move from a namespace version to another
+type Migration struct {
+	// From the old versioned namespace, e.g. orders@v2
+	From string `json:"from" yaml:"from"`
+	// To the new versioned namespace, e.g. orders@v3
+	To string `json:"to" yaml:"to"`
+	// Statuses map old statuses to new ones,
+	// statuses not listed are kept if they exist in the new version
+	Statuses map[string]string `json:"statuses" yaml:"statuses"`
+}
+
+// MigrationReport the result of a migration
+type MigrationReport struct {
+	From     string             `json:"from"`
+	To       string             `json:"to"`
+	DryRun   bool               `json:"dry_run"`
+	Migrated []MigratedInstance `json:"migrated"`
+	Failed   []FailedInstance   `json:"failed"`
+}
+
+// MigratedInstance an instance moved to the new version
+type MigratedInstance struct {
+	ID         string `json:"id"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+}
+
+// FailedInstance an instance which can not be migrated
+type FailedInstance struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// Migrator migrate instances in a store between namespace versions of a repo
+type Migrator struct {
+	repo  TableRepo
+	store Store
+}
+
+// NewMigrator new a migrator
+func NewMigrator(repo TableRepo, store Store) *Migrator {
+	return &Migrator{repo: repo, store: store}
+}
+
+// MigrateEvent the event of the outbox records written for migrated instances
+const MigrateEvent = "fsm.migrate"
+
+// Migrate rewrite namespace, status and data of instances in m.From, and put them by PutTransition
+// so a store with an outbox records every migration as a transition of MigrateEvent.
+// Data gets the defaults of variables new in m.To and its values are converted to their types in m.To,
+// an instance whose data can not be converted fails.
+// Nothing is written with dryRun, and the report shows what would happen
+func (p *Migrator) Migrate(m *Migration, dryRun bool) (*MigrationReport, error) {
+	if m == nil || m.From == "" || m.To == "" || m.From == m.To {
+		return nil, ErrInvalidMigration
+	}
+	statuses, err := p.statuses(m.To)
+	if err != nil {
+		return nil, err
+	}
+	oldStatuses, err := p.statuses(m.From)
+	if err != nil {
+		return nil, err
+	}
+	for from, to := range m.Statuses {
+		if !oldStatuses[from] {
+			return nil, fmt.Errorf("%w: %q is not in %s", ErrInvalidMigration, from, m.From)
+		}
+		if !statuses[to] {
+			return nil, fmt.Errorf("%w: %q maps to %q which is not in %s", ErrInvalidMigration, from, to, m.To)
+		}
+	}
+	variables := p.repo.Table().variablesOf(m.To)
+
+	var instances []*Instance
+	err = p.store.Range(func(inst *Instance) bool {
+		if inst.Namespace == m.From {
+			instances = append(instances, inst)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MigrationReport{
+		From:     m.From,
+		To:       m.To,
+		DryRun:   dryRun,
+		Migrated: []MigratedInstance{},
+		Failed:   []FailedInstance{},
+	}
+	for _, inst := range instances {
+		from := inst.Status
+		target, ok := m.Statuses[from]
+		if !ok {
+			target = from
+		}
+		if !statuses[target] {
+			report.Failed = append(report.Failed, FailedInstance{
+				ID:     inst.ID,
+				Status: from,
+				Reason: fmt.Sprintf("status %q is not in %s and not mapped", from, m.To),
+			})
+			continue
+		}
+		data, err := initialData(variables, inst.Data)
+		if err != nil {
+			report.Failed = append(report.Failed, FailedInstance{ID: inst.ID, Status: from, Reason: err.Error()})
+			continue
+		}
+
+		if !dryRun {
+			inst.Namespace, inst.Status, inst.Data = m.To, target, data.Copy()
+			if err = PutTransition(p.store, inst, MigrateEvent, from); err != nil {
+				report.Failed = append(report.Failed, FailedInstance{ID: inst.ID, Status: from, Reason: err.Error()})
+				continue
+			}
+		}
+		report.Migrated = append(report.Migrated, MigratedInstance{ID: inst.ID, FromStatus: from, ToStatus: target})
+	}
+	return report, nil
+}
+
+// statuses get the statuses of a namespace in the repo
+func (p *Migrator) statuses(namespace string) (map[string]bool, error) {
+	ts := p.repo.GetTransactions(namespace)
+	if len(ts) == 0 {
+		return nil, &ConfigError{Key: "fsm." + namespace, Err: ErrNamespaceNotFound}
+	}
+	return stringSet(NewNamespaceGraph(namespace, ts).Statuses), nil
+}
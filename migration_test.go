@@ -0,0 +1,180 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newMigrationRepo add orders@v2, new -pay-> paid -ship-> shipped with an int variable count,
+// and orders@v3, created -pay-> paid -ship-> sent with a float count and a string note of default "migrated"
+func newMigrationRepo(t *testing.T) *DefaultRepo {
+	t.Helper()
+	repo := Default()
+	repo.Remove()
+	t.Cleanup(repo.Remove)
+	for _, tr := range []*Transaction{
+		{Namespace: "orders@v2", CurrentStatus: "new", Event: "pay", TargetStatus: "paid"},
+		{Namespace: "orders@v2", CurrentStatus: "paid", Event: "ship", TargetStatus: "shipped"},
+		{Namespace: "orders@v3", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"},
+		{Namespace: "orders@v3", CurrentStatus: "paid", Event: "ship", TargetStatus: "sent"},
+	} {
+		repo.Add(tr)
+	}
+	for _, v := range []*Variable{
+		{Namespace: "orders@v2", Name: "count", Type: VariableInt},
+		{Namespace: "orders@v3", Name: "count", Type: VariableFloat},
+		{Namespace: "orders@v3", Name: "note", Type: VariableString, Default: "migrated"},
+	} {
+		if err := repo.Table().SetVariable(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+// putInstances put the instances into the store
+func putInstances(t *testing.T, store Store, instances ...*Instance) {
+	t.Helper()
+	for _, inst := range instances {
+		if err := store.Put(inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrateInvalid(t *testing.T) {
+	repo := newMigrationRepo(t)
+	migrator := NewMigrator(repo, NewMemoryStore())
+
+	tests := []struct {
+		name string
+		m    *Migration
+		err  error
+	}{
+		{name: "nil", err: ErrInvalidMigration},
+		{name: "same version", m: &Migration{From: "orders@v2", To: "orders@v2"}, err: ErrInvalidMigration},
+		{name: "missing new version", m: &Migration{From: "orders@v2", To: "orders@v4"}, err: ErrNamespaceNotFound},
+		{name: "missing old version", m: &Migration{From: "orders@v1", To: "orders@v3"}, err: ErrNamespaceNotFound},
+		{
+			name: "source not in the old version",
+			m:    &Migration{From: "orders@v2", To: "orders@v3", Statuses: map[string]string{"created": "paid"}},
+			err:  ErrInvalidMigration,
+		},
+		{
+			name: "target not in the new version",
+			m:    &Migration{From: "orders@v2", To: "orders@v3", Statuses: map[string]string{"new": "fresh"}},
+			err:  ErrInvalidMigration,
+		},
+	}
+	for _, test := range tests {
+		if _, err := migrator.Migrate(test.m, false); !errors.Is(err, test.err) {
+			t.Errorf("%s: Migrate() = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	repo := newMigrationRepo(t)
+	store := NewMemoryOutboxStore()
+	putInstances(t, store,
+		&Instance{ID: "o1", Namespace: "orders@v2", Status: "new", Data: Data{"count": int64(2)}},
+		&Instance{ID: "o2", Namespace: "orders@v2", Status: "paid"},
+		&Instance{ID: "o3", Namespace: "orders@v2", Status: "shipped"},
+		&Instance{ID: "o4", Namespace: "orders@v2", Status: "new", Data: Data{"count": "many"}},
+		&Instance{ID: "o5", Namespace: "orders@v3", Status: "created"},
+	)
+	m := &Migration{From: "orders@v2", To: "orders@v3", Statuses: map[string]string{"new": "created"}}
+
+	wantMigrated := []MigratedInstance{
+		{ID: "o1", FromStatus: "new", ToStatus: "created"},
+		{ID: "o2", FromStatus: "paid", ToStatus: "paid"},
+	}
+	wantFailed := []string{"o3", "o4"}
+	check := func(report *MigrationReport, dryRun bool) {
+		t.Helper()
+		if report.DryRun != dryRun || report.From != m.From || report.To != m.To {
+			t.Fatalf("report = %+v", report)
+		}
+		if !reflect.DeepEqual(report.Migrated, wantMigrated) {
+			t.Fatalf("migrated = %+v, want %+v", report.Migrated, wantMigrated)
+		}
+		var failed []string
+		for _, f := range report.Failed {
+			failed = append(failed, f.ID)
+		}
+		if !reflect.DeepEqual(failed, wantFailed) {
+			t.Fatalf("failed = %+v, want %v", report.Failed, wantFailed)
+		}
+	}
+
+	// a dry run reports stranded instances and writes nothing
+	report, err := NewMigrator(repo, store).Migrate(m, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(report, true)
+	if f := report.Failed[0]; f.Status != "shipped" || f.Reason != `status "shipped" is not in orders@v3 and not mapped` {
+		t.Fatalf("stranded instance = %+v", f)
+	}
+	if inst, _ := store.Get("o1"); inst.Namespace != "orders@v2" || inst.Status != "new" || inst.Revision != 1 {
+		t.Fatalf("dry run wrote %+v", inst)
+	}
+	if records, _ := store.PendingOutbox(time.Now(), 10); len(records) != 0 {
+		t.Fatalf("dry run wrote outbox records %+v", records)
+	}
+
+	report, err = NewMigrator(repo, store).Migrate(m, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(report, false)
+
+	o1, _ := store.Get("o1")
+	if o1.Namespace != "orders@v3" || o1.Status != "created" || o1.Revision != 2 ||
+		!reflect.DeepEqual(o1.Data, Data{"count": 2.0, "note": "migrated"}) {
+		t.Fatalf("migrated o1 = %+v", o1)
+	}
+	if o3, _ := store.Get("o3"); o3.Namespace != "orders@v2" || o3.Revision != 1 {
+		t.Fatalf("stranded o3 is written: %+v", o3)
+	}
+	if o5, _ := store.Get("o5"); o5.Revision != 1 {
+		t.Fatalf("o5 of the new version is written: %+v", o5)
+	}
+
+	records, err := store.PendingOutbox(time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("outbox = %+v, want a record per migrated instance", records)
+	}
+	if r := records[0]; r.InstanceID != "o1" || r.Event != MigrateEvent || r.Namespace != "orders@v3" ||
+		r.From != "new" || r.To != "created" || r.Revision != 2 {
+		t.Fatalf("outbox record = %+v", r)
+	}
+
+	// the migrated instances fire in the new version
+	if _, _, err = FireInstance(context.Background(), repo, store, o1, "pay", nil); err != nil {
+		t.Fatal(err)
+	}
+}
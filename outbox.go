@@ -0,0 +1,315 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OutboxRecord a notification of a transition, stored with the new status of the instance
+type OutboxRecord struct {
+	// ID the idempotency key, <instance id>@<revision>, the same for every attempt to publish the record
+	ID         string    `json:"id"`
+	InstanceID string    `json:"instance_id"`
+	Namespace  string    `json:"namespace"`
+	Event      string    `json:"event"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Revision   int64     `json:"revision"`
+	Time       time.Time `json:"time"`
+
+	// Attempts failed attempts to publish
+	Attempts    int       `json:"attempts,omitempty"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// OutboxStore a store which puts an instance and its outbox records in one operation
+type OutboxStore interface {
+	Store
+	// put the instance like Put, and add the records only if it is put
+	PutWithOutbox(inst *Instance, records ...*OutboxRecord) error
+	// get copies of at most limit records due at now, the oldest first,
+	// records of an instance are not due while an earlier one of it waits for its next attempt
+	PendingOutbox(now time.Time, limit int) ([]*OutboxRecord, error)
+	// remove a published record
+	AckOutbox(id string) error
+	// count a failed attempt of a record and schedule the next one
+	RetryOutbox(id string, next time.Time, err error) error
+}
+
+// PutTransition put the instance after firing the event from the status, with an outbox record
+// of the transition in the same operation if the store is an OutboxStore
+func PutTransition(store Store, inst *Instance, event, from string) error {
+	outbox, ok := store.(OutboxStore)
+	if !ok {
+		return store.Put(inst)
+	}
+	revision := inst.Revision + 1
+	return outbox.PutWithOutbox(inst, &OutboxRecord{
+		ID:         inst.ID + VersionSeparator + strconv.FormatInt(revision, 10),
+		InstanceID: inst.ID,
+		Namespace:  inst.Namespace,
+		Event:      event,
+		From:       from,
+		To:         inst.Status,
+		Revision:   revision,
+		Time:       time.Now(),
+	})
+}
+
+// FireInstance fire an event at the stored instance with a machine of the repo and put it by PutTransition,
+// the machine's audit entry is written, its event is published and its metrics and log are recorded
+// only after the put succeeds, a rejected put is logged as a failed transition and an entry failed then is logged.
+// The machine is returned for its output and history
+func FireInstance(ctx context.Context, repo TableRepo, store Store, inst *Instance, event string,
+	payload map[string]interface{}, opts ...MachineOption[string, string]) (*Machine[string, string], *Transaction, error) {
+	opts = append(append([]MachineOption[string, string]{}, opts...), MachineID[string, string](inst.ID))
+	m, err := NewRepoMachine(repo, inst.Namespace, inst.Status, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = m.Restore(inst.Snapshot()); err != nil {
+		return nil, nil, err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	sink, broker, metrics, logger := m.audit, m.broker, m.metrics, m.logger
+	m.audit, m.broker, m.metrics, m.logger = nil, nil, nil, nil
+	defer func() { m.audit, m.broker, m.metrics, m.logger = sink, broker, metrics, logger }()
+
+	from, start := inst.Status, time.Now()
+	t, err := m.fire(ctx, event, payload)
+	if err == nil {
+		inst.SetSnapshot(m.snapshot())
+		err = PutTransition(store, inst, event, from)
+	}
+	// a transition is observed and logged as fired only once it is put
+	m.metrics, m.logger = metrics, logger
+	m.observe(event, from, t, err, time.Since(start))
+	if err != nil {
+		return nil, nil, err
+	}
+	if sink != nil {
+		entry := m.auditEntry("", event, from, inst.Status, time.Now(), payloadDigest(payload))
+		if err = sink.Write(entry); err != nil {
+			getLogger().Error("fsm: audit entry not written", "namespace", inst.Namespace, "instance", inst.ID,
+				"event", event, "error", err)
+		}
+	}
+	if broker != nil {
+		e := m.transitionEvent("", event, from, inst.Status)
+		e.Revision, e.Data = inst.Revision, inst.Data.Copy()
+		broker.Publish(e)
+	}
+	return m, t, nil
+}
+
+// Publisher publish outbox records downstream, consumers deduplicate them by ID
+type Publisher interface {
+	Publish(ctx context.Context, r *OutboxRecord) error
+}
+
+// PublisherFunc a function as a publisher
+type PublisherFunc func(ctx context.Context, r *OutboxRecord) error
+
+// Publish call the function
+func (p PublisherFunc) Publish(ctx context.Context, r *OutboxRecord) error {
+	return p(ctx, r)
+}
+
+// defaults of the relay
+const (
+	DefaultRelayInterval   = time.Second
+	DefaultRelayBatch      = 100
+	DefaultRelayMinBackoff = time.Second
+	DefaultRelayMaxBackoff = 5 * time.Minute
+)
+
+// RelayOption relay option function
+type RelayOption func(*Relay)
+
+// RelayInterval set the interval of polling the outbox
+func RelayInterval(interval time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.interval = interval
+	}
+}
+
+// RelayBatch set the number of records published in a pass
+func RelayBatch(size int) RelayOption {
+	return func(r *Relay) {
+		r.batch = size
+	}
+}
+
+// RelayBackoff set the delay after the first failure of a record, doubled by every next one up to max
+func RelayBackoff(min, max time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.minBackoff, r.maxBackoff = min, max
+	}
+}
+
+// Relay publish records of the outbox until stopped, a record is removed after it is published,
+// so it is published at least once and again if removing it fails.
+// Records of an instance are published in order, later ones wait while an earlier one is retried
+type Relay struct {
+	store      OutboxStore
+	publisher  Publisher
+	interval   time.Duration
+	batch      int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	started  bool
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	sync.Mutex
+}
+
+// NewRelay new a relay of the outbox to the publisher
+func NewRelay(store OutboxStore, publisher Publisher, opts ...RelayOption) *Relay {
+	r := &Relay{
+		store:      store,
+		publisher:  publisher,
+		interval:   DefaultRelayInterval,
+		batch:      DefaultRelayBatch,
+		minBackoff: DefaultRelayMinBackoff,
+		maxBackoff: DefaultRelayMaxBackoff,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	if r.interval <= 0 {
+		r.interval = DefaultRelayInterval
+	}
+	if r.batch <= 0 {
+		r.batch = DefaultRelayBatch
+	}
+	if r.maxBackoff < r.minBackoff {
+		r.maxBackoff = r.minBackoff
+	}
+	return r
+}
+
+// Start poll the outbox in a goroutine until stopped
+func (p *Relay) Start() {
+	p.Lock()
+	defer p.Unlock()
+	if p.started {
+		return
+	}
+	p.started = true
+	go p.run()
+}
+
+// Stop stop polling and wait for the running pass, the publisher's context is canceled
+func (p *Relay) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+
+	p.Lock()
+	started := p.started
+	p.Unlock()
+	if started {
+		<-p.done
+	}
+}
+
+func (p *Relay) run() {
+	defer close(p.done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-p.stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := p.Drain(ctx); err != nil {
+			getLogger().Error("fsm: outbox not drained", "error", err)
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain publish the due records once, and get the number of published ones
+func (p *Relay) Drain(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		records, err := p.store.PendingOutbox(time.Now(), p.batch)
+		if err != nil || len(records) == 0 {
+			return published, err
+		}
+		acked := 0
+		// instances with a failed record in the batch, their later records wait for it
+		failed := make(map[string]bool)
+		for _, r := range records {
+			if err = ctx.Err(); err != nil {
+				return published, err
+			}
+			if failed[r.InstanceID] {
+				continue
+			}
+			if err = p.publisher.Publish(ctx, r); err != nil {
+				failed[r.InstanceID] = true
+				getLogger().Warn("fsm: outbox record not published", "id", r.ID, "attempts", r.Attempts+1, "error", err)
+				if err = p.store.RetryOutbox(r.ID, time.Now().Add(p.backoff(r.Attempts+1)), err); err != nil {
+					return published, err
+				}
+				continue
+			}
+			if err = p.store.AckOutbox(r.ID); err != nil {
+				return published, err
+			}
+			acked++
+			published++
+		}
+		// the records which failed and those after them are not due again in this pass
+		if acked == 0 || len(records) < p.batch {
+			return published, nil
+		}
+	}
+}
+
+// backoff get the delay after the attempts failed
+func (p *Relay) backoff(attempts int) time.Duration {
+	d := p.minBackoff
+	for i := 1; i < attempts && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,244 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder a publisher which records the published ids and fails the ids set in failing
+type recorder struct {
+	published []string
+	failing   map[string]bool
+
+	sync.Mutex
+}
+
+func (p *recorder) Publish(_ context.Context, r *OutboxRecord) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.failing[r.ID] {
+		return errors.New("unavailable")
+	}
+	p.published = append(p.published, r.ID)
+	return nil
+}
+
+func (p *recorder) fail(id string, failing bool) {
+	p.Lock()
+	defer p.Unlock()
+	p.failing[id] = failing
+}
+
+func (p *recorder) ids() []string {
+	p.Lock()
+	defer p.Unlock()
+	return append([]string(nil), p.published...)
+}
+
+// putTransitions put the instance once and then fire it n times through PutTransition
+func putTransitions(t *testing.T, store Store, id string, n int) {
+	t.Helper()
+	inst := &Instance{ID: id, Namespace: "order", Status: "s0"}
+	if err := store.Put(inst); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		from := inst.Status
+		inst.Status = from + "'"
+		if err := PutTransition(store, inst, "next", from); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryStoreWithoutOutbox(t *testing.T) {
+	store := NewMemoryStore()
+	if _, ok := store.(OutboxStore); ok {
+		t.Fatal("NewMemoryStore() is an OutboxStore")
+	}
+	putTransitions(t, store, "o1", 2)
+	inst, err := store.Get("o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.Revision != 3 {
+		t.Fatalf("revision = %d, want 3", inst.Revision)
+	}
+}
+
+func TestRelayDrain(t *testing.T) {
+	store := NewMemoryOutboxStore()
+	putTransitions(t, store, "o1", 2)
+	putTransitions(t, store, "o2", 1)
+
+	pub := &recorder{failing: map[string]bool{}}
+	relay := NewRelay(store, pub, RelayBatch(2))
+	n, err := relay.Drain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"o1@2", "o1@3", "o2@2"}
+	if n != len(want) || !reflect.DeepEqual(pub.ids(), want) {
+		t.Fatalf("Drain() = %d, published %v, want %v", n, pub.ids(), want)
+	}
+	if records, _ := store.PendingOutbox(time.Now(), 10); len(records) != 0 {
+		t.Fatalf("pending after drain = %d, want 0", len(records))
+	}
+}
+
+func TestRelayKeepOrderOfInstance(t *testing.T) {
+	store := NewMemoryOutboxStore()
+	putTransitions(t, store, "o1", 2)
+	putTransitions(t, store, "o2", 1)
+
+	pub := &recorder{failing: map[string]bool{"o1@2": true}}
+	relay := NewRelay(store, pub, RelayBackoff(20*time.Millisecond, time.Second))
+	if _, err := relay.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// o1@3 waits for o1@2, o2 goes on
+	if want := []string{"o2@2"}; !reflect.DeepEqual(pub.ids(), want) {
+		t.Fatalf("published %v, want %v", pub.ids(), want)
+	}
+
+	records, err := store.PendingOutbox(time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("pending while o1@2 waits = %v, want none", records)
+	}
+	records, _ = store.PendingOutbox(time.Now().Add(time.Minute), 10)
+	if len(records) != 2 || records[0].ID != "o1@2" || records[1].ID != "o1@3" {
+		t.Fatalf("pending after backoff = %v, want o1@2, o1@3", records)
+	}
+	if records[0].Attempts != 1 || records[0].LastError != "unavailable" {
+		t.Fatalf("o1@2 attempts = %d, last error = %q", records[0].Attempts, records[0].LastError)
+	}
+
+	pub.fail("o1@2", false)
+	time.Sleep(30 * time.Millisecond)
+	if _, err := relay.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"o2@2", "o1@2", "o1@3"}; !reflect.DeepEqual(pub.ids(), want) {
+		t.Fatalf("published %v, want %v", pub.ids(), want)
+	}
+}
+
+func TestRelayRetry(t *testing.T) {
+	store := NewMemoryOutboxStore()
+	putTransitions(t, store, "o1", 1)
+
+	pub := &recorder{failing: map[string]bool{"o1@2": true}}
+	relay := NewRelay(store, pub, RelayInterval(5*time.Millisecond), RelayBackoff(5*time.Millisecond, 10*time.Millisecond))
+	relay.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	records, _ := store.PendingOutbox(time.Now().Add(time.Minute), 10)
+	if len(records) != 1 || records[0].Attempts < 2 {
+		t.Fatalf("pending = %v, want o1@2 retried", records)
+	}
+
+	pub.fail("o1@2", false)
+	deadline := time.Now().Add(time.Second)
+	for len(pub.ids()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	relay.Stop()
+	if want := []string{"o1@2"}; !reflect.DeepEqual(pub.ids(), want) {
+		t.Fatalf("published %v, want %v", pub.ids(), want)
+	}
+}
+
+func TestRelayBackoff(t *testing.T) {
+	relay := NewRelay(NewMemoryOutboxStore(), &recorder{}, RelayBackoff(time.Second, 5*time.Second))
+	for attempts, want := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		if got := relay.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestFireInstanceObserveAfterPut(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid"})
+
+	store := NewMemoryStore()
+	inst := &Instance{ID: "o1", Namespace: "order", Status: "created"}
+	if err := store.Put(inst); err != nil {
+		t.Fatal(err)
+	}
+	metrics, logger := NewMetricsRegistry(), &recordingLogger{}
+	opts := []MachineOption[string, string]{MachineMetrics[string, string](metrics), MachineLogger[string, string](logger)}
+	transitions := func() string {
+		var buf bytes.Buffer
+		if _, err := metrics.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.HasPrefix(line, "fsm_transitions_total{") {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	stale := *inst
+	stale.Revision = 0
+	_, _, err := FireInstance(context.Background(), repo, store, &stale, "pay", nil, opts...)
+	if !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("FireInstance(stale) = %v, want %v", err, ErrRevisionConflict)
+	}
+	if got := transitions(); got != "" {
+		t.Fatalf("observed a transition which was not put: %s", got)
+	}
+	if fired := logger.find("fsm: transition fired"); len(fired) != 0 {
+		t.Fatalf("logged a transition which was not put: %v", fired)
+	}
+	if failed := logger.find("WARN fsm: transition failed", "instance=o1", ErrRevisionConflict.Error()); len(failed) != 1 {
+		t.Fatalf("rejected put not logged: %v", logger.lines)
+	}
+
+	if _, _, err = FireInstance(context.Background(), repo, store, inst, "pay", nil, opts...); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := transitions(), `fsm_transitions_total{namespace="order",event="pay",from="created",to="paid"} 1`; got != want {
+		t.Fatalf("transitions = %q, want %q", got, want)
+	}
+	if fired := logger.find("DEBUG fsm: transition fired", "instance=o1", "to=paid"); len(fired) != 1 {
+		t.Fatalf("transition not logged: %v", logger.lines)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DiagramFormat the format of rendered diagrams
+type DiagramFormat string
+
+// supported diagram formats
+const (
+	DiagramDOT      DiagramFormat = "dot"
+	DiagramMermaid  DiagramFormat = "mermaid"
+	DiagramPlantUML DiagramFormat = "plantuml"
+)
+
+// Render render namespaces of the repo as diagrams, all namespaces if none is given
+func Render(w io.Writer, format DiagramFormat, namespaces ...string) error {
+	repo := Default()
+	if len(namespaces) == 0 {
+		namespaces = repo.GetNamespaces()
+	}
+	for _, namespace := range namespaces {
+		ts := repo.GetTransactions(namespace)
+		if len(ts) == 0 {
+			return &ConfigError{Key: "fsm." + namespace, Err: ErrNamespaceNotFound}
+		}
+		if err := RenderGraph(w, format, NewNamespaceGraph(namespace, ts)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderGraph render a namespace graph as a diagram
+func RenderGraph(w io.Writer, format DiagramFormat, g *NamespaceGraph) error {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case DiagramDOT:
+		renderDOT(bw, g)
+	case DiagramMermaid:
+		renderStateDiagram(bw, g, "stateDiagram-v2", "", mermaidLabel)
+	case DiagramPlantUML:
+		renderStateDiagram(bw, g, "@startuml "+g.Namespace, "@enduml", plantUMLLabel)
+	default:
+		return ErrUnsupportedFormat
+	}
+	return bw.Flush()
+}
+
+func renderDOT(w *bufio.Writer, g *NamespaceGraph) {
+	fmt.Fprintf(w, "digraph %s {\n", strconv.Quote(g.Namespace))
+	fmt.Fprintln(w, "  rankdir=LR;")
+	for _, status := range g.Initials {
+		fmt.Fprintf(w, "  %s [shape=box, style=rounded, penwidth=2];\n", strconv.Quote(status))
+	}
+	for _, status := range g.Terminals {
+		fmt.Fprintf(w, "  %s [shape=doublecircle];\n", strconv.Quote(status))
+	}
+	if len(g.Wildcards) > 0 {
+		fmt.Fprintf(w, "  %s [label=\"any status\", shape=plaintext];\n", strconv.Quote(AnyStatus))
+	}
+	for _, t := range g.Transactions {
+		label, style := transitionLabel(t), ""
+		switch t.Kind {
+		case TransitionInternal:
+			label, style = label+" (internal)", ", style=dotted"
+		case TransitionExternal:
+			label += " (external)"
+		}
+		if t.CurrentStatus == AnyStatus {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(w, "  %s -> %s [label=%s%s];\n",
+			strconv.Quote(t.CurrentStatus), strconv.Quote(t.TargetStatus), strconv.Quote(label), style)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// transitionLabel the event of a transition with its guard and Mealy output like "event [guard] / output"
+func transitionLabel(t *Transaction) string {
+	label := t.Event
+	if t.Guard != "" {
+		label += " [" + t.Guard + "]"
+	}
+	if t.Output != "" {
+		label += " / " + t.Output
+	}
+	return label
+}
+
+// renderStateDiagram render mermaid and plantuml state diagrams, which share the syntax
+func renderStateDiagram(w *bufio.Writer, g *NamespaceGraph, header, footer string, label func(string) string) {
+	ids := make(map[string]string, len(g.Statuses))
+	fmt.Fprintln(w, header)
+	for i, status := range g.Statuses {
+		ids[status] = "s" + strconv.Itoa(i)
+		fmt.Fprintf(w, "  state \"%s\" as %s\n", label(status), ids[status])
+	}
+	if len(g.Wildcards) > 0 {
+		ids[AnyStatus] = "any"
+		fmt.Fprintf(w, "  state \"any status\" as %s\n", ids[AnyStatus])
+	}
+	for _, status := range g.Initials {
+		fmt.Fprintf(w, "  [*] --> %s\n", ids[status])
+	}
+	for _, t := range g.Transactions {
+		// internal transitions are written in the state like UML, without an edge
+		if t.Kind == TransitionInternal {
+			fmt.Fprintf(w, "  %s : %s\n", ids[t.CurrentStatus], label(transitionLabel(t)))
+			continue
+		}
+		fmt.Fprintf(w, "  %s --> %s : %s\n", ids[t.CurrentStatus], ids[t.TargetStatus], label(transitionLabel(t)))
+	}
+	for _, status := range g.Terminals {
+		fmt.Fprintf(w, "  %s --> [*]\n", ids[status])
+	}
+	if footer != "" {
+		fmt.Fprintln(w, footer)
+	}
+}
+
+func mermaidLabel(name string) string {
+	return strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(name)
+}
+
+func plantUMLLabel(name string) string {
+	return strings.NewReplacer(`"`, "'", "\n", " ").Replace(name)
+}
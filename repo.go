@@ -30,3 +30,15 @@ type Repo interface {
 	// get target transaction by current information
 	GetTargetTranstion(namespace, curStatus, event string) *Transaction
 }
+
+// TableRepo a repo which lists its transactions from a table of string statuses and events,
+// implemented by the default repo and InstrumentRepo
+type TableRepo interface {
+	Repo
+	// get all namespaces
+	GetNamespaces() []string
+	// get namespace's transactions
+	GetTransactions(namespace string) []*Transaction
+	// get the table of string statuses and events behind the repo
+	Table() *Table[string, string]
+}
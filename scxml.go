@@ -0,0 +1,485 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// SCXMLNamespace the xml namespace of W3C SCXML
+const SCXMLNamespace = "http://www.w3.org/2005/07/scxml"
+
+// SCXMLWarning an unsupported SCXML construct which is skipped
+type SCXMLWarning struct {
+	// Line the line of the element in the document, 0 when exporting
+	Line    int
+	Element string
+	Message string
+}
+
+func (p SCXMLWarning) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: <%s>: %s", p.Line, p.Element, p.Message)
+	}
+	return fmt.Sprintf("<%s>: %s", p.Element, p.Message)
+}
+
+// LoadSCXML load a SCXML document into the repo as namespace,
+// the name attribute of <scxml> is used if namespace is empty
+func LoadSCXML(r io.Reader, namespace string) ([]SCXMLWarning, error) {
+	ts, warnings, err := ReadSCXML(r, namespace)
+	if err != nil {
+		return warnings, err
+	}
+	return warnings, Default().ReplaceNamespaces(nil, ts)
+}
+
+// ReadSCXML convert a SCXML document into transactions.
+// Nested states are flattened into their atomic states: a transition of a compound state
+// applies to all its descendants unless they define the same event, and a compound target
+// enters its initial descendant. Event descriptors are matched exactly, not by prefix.
+// Executable content, datamodel, parallel and history states,
+// conditions and eventless transitions are not supported and reported as warnings.
+// Targetless transitions are read as internal transactions.
+func ReadSCXML(r io.Reader, namespace string) ([]*Transaction, []SCXMLWarning, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := parseSCXMLTree(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if root.name != "scxml" {
+		return nil, nil, fmt.Errorf("%w: root element is <%s>", ErrInvalidSCXML, root.name)
+	}
+	if namespace == "" {
+		namespace = root.attrs["name"]
+	}
+	if namespace == "" {
+		return nil, nil, fmt.Errorf("%w: empty namespace", ErrInvalidSCXML)
+	}
+
+	doc := &scxmlDocument{states: make(map[string]*scxmlState)}
+	if err = doc.readChildren(root, nil); err != nil {
+		return nil, doc.warnings, err
+	}
+
+	var ts []*Transaction
+	for _, leaf := range doc.order {
+		if len(leaf.children) > 0 {
+			continue
+		}
+		fired := make(map[string]bool)
+		for s := leaf; s != nil; s = s.parent {
+			for _, t := range s.transitions {
+				// a targetless transition is internal
+				target, kind := leaf, TransitionInternal
+				if t.target != "" {
+					var err error
+					if target, err = doc.enter(t.target); err != nil {
+						return nil, doc.warnings, fmt.Errorf("%w: line %d: %s", ErrInvalidSCXML, t.line, err)
+					}
+					kind = TransitionNormal
+				}
+				for _, event := range t.events {
+					if fired[event] {
+						continue
+					}
+					fired[event] = true
+					ts = append(ts, &Transaction{
+						Namespace:     namespace,
+						CurrentStatus: leaf.id,
+						Event:         event,
+						TargetStatus:  target.id,
+						Kind:          kind,
+					})
+				}
+			}
+		}
+	}
+	if len(ts) == 0 {
+		return nil, doc.warnings, ErrEmptyDefinition
+	}
+	return ts, doc.warnings, nil
+}
+
+// ExportSCXML export a namespace of the repo as a flat SCXML document,
+// statuses without transactions are written as <final>, and guards as cond with a warning.
+// Variables, assignments and outputs can not be carried and are reported as warnings
+func ExportSCXML(w io.Writer, namespace string) ([]SCXMLWarning, error) {
+	g := NewNamespaceGraph(namespace, Default().GetTransactions(namespace))
+	if len(g.Transactions) == 0 {
+		return nil, &ConfigError{Key: "fsm." + namespace, Err: ErrNamespaceNotFound}
+	}
+
+	var warnings []SCXMLWarning
+	if len(g.Wildcards) > 0 {
+		warnings = append(warnings, SCXMLWarning{Element: "transition",
+			Message: "transactions from any status are written to every state"})
+	}
+	ts := g.Expand()
+	doc := scxmlExport{Xmlns: SCXMLNamespace, Version: "1.0", Name: namespace}
+	statuses, targeted := make(map[string]*scxmlExportState), make(map[string]bool)
+	var order []string
+	addStatus := func(status string) *scxmlExportState {
+		s, ok := statuses[status]
+		if !ok {
+			s = &scxmlExportState{ID: status}
+			statuses[status] = s
+			order = append(order, status)
+		}
+		return s
+	}
+
+	for i, t := range ts {
+		if strings.IndexFunc(t.CurrentStatus+t.Event+t.TargetStatus, isSpace) >= 0 {
+			return nil, &ConfigError{Key: "fsm." + namespace + "." + definitionKey(i), Err: ErrNotExportable}
+		}
+		s := addStatus(t.CurrentStatus)
+		// internal transactions are targetless transitions
+		target := t.TargetStatus
+		if t.Kind == TransitionInternal {
+			target = ""
+		}
+		s.Transitions = append(s.Transitions, scxmlExportTransition{Event: t.Event, Target: target, Cond: t.Guard})
+		if len(t.Assignments) > 0 {
+			warnings = append(warnings, SCXMLWarning{Element: "transition",
+				Message: fmt.Sprintf("assignments of %q from %q are not supported and not exported", t.Event, t.CurrentStatus)})
+		}
+		if t.Output != "" {
+			warnings = append(warnings, SCXMLWarning{Element: "transition",
+				Message: fmt.Sprintf("output of %q from %q is not supported and not exported", t.Event, t.CurrentStatus)})
+		}
+		if t.Guard != "" {
+			warnings = append(warnings, SCXMLWarning{Element: "transition",
+				Message: fmt.Sprintf("guard of %q from %q is written as cond in the fsm expression language, not ECMAScript",
+					t.Event, t.CurrentStatus)})
+		}
+		addStatus(t.TargetStatus)
+		if t.TargetStatus != t.CurrentStatus {
+			targeted[t.TargetStatus] = true
+		}
+	}
+
+	outputs := Default().Table().GetOutputs(namespace)
+	for _, status := range order {
+		if outputs[status] != "" {
+			warnings = append(warnings, SCXMLWarning{Element: "state",
+				Message: fmt.Sprintf("output of status %q is not supported and not exported", status)})
+		}
+	}
+	if vs := Default().Table().GetVariables(namespace); len(vs) > 0 {
+		warnings = append(warnings, SCXMLWarning{Element: "datamodel",
+			Message: fmt.Sprintf("%d variables are not supported and not exported", len(vs))})
+	}
+
+	var roots []string
+	for _, status := range order {
+		if !targeted[status] {
+			roots = append(roots, status)
+		}
+	}
+	switch len(roots) {
+	case 1:
+		doc.Initial = roots[0]
+	case 0:
+		warnings = append(warnings, SCXMLWarning{Element: "scxml",
+			Message: "no status is only a source, the first state is initial"})
+	default:
+		warnings = append(warnings, SCXMLWarning{Element: "scxml",
+			Message: fmt.Sprintf("%d statuses are only sources, the first state %q is initial", len(roots), order[0])})
+	}
+
+	for _, status := range order {
+		s := statuses[status]
+		if len(s.Transitions) == 0 {
+			doc.Finals = append(doc.Finals, scxmlExportState{ID: s.ID})
+			continue
+		}
+		if status == doc.Initial {
+			doc.States = append([]scxmlExportState{*s}, doc.States...)
+			continue
+		}
+		doc.States = append(doc.States, *s)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return warnings, err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return warnings, err
+	}
+	_, err := io.WriteString(w, "\n")
+	return warnings, err
+}
+
+type scxmlExport struct {
+	XMLName xml.Name           `xml:"scxml"`
+	Xmlns   string             `xml:"xmlns,attr"`
+	Version string             `xml:"version,attr"`
+	Name    string             `xml:"name,attr"`
+	Initial string             `xml:"initial,attr,omitempty"`
+	States  []scxmlExportState `xml:"state"`
+	Finals  []scxmlExportState `xml:"final"`
+}
+
+type scxmlExportState struct {
+	ID          string                  `xml:"id,attr"`
+	Transitions []scxmlExportTransition `xml:"transition"`
+}
+
+type scxmlExportTransition struct {
+	Event  string `xml:"event,attr"`
+	Target string `xml:"target,attr,omitempty"`
+	Cond   string `xml:"cond,attr,omitempty"`
+}
+
+type scxmlNode struct {
+	name     string
+	attrs    map[string]string
+	children []*scxmlNode
+	line     int
+}
+
+func parseSCXMLTree(data []byte) (*scxmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var stack []*scxmlNode
+	var root *scxmlNode
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &scxmlNode{
+				name:  t.Name.Local,
+				attrs: make(map[string]string, len(t.Attr)),
+				line:  1 + bytes.Count(data[:offset], []byte("\n")),
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "" {
+					node.attrs[attr.Name.Local] = attr.Value
+				}
+			}
+			if len(stack) == 0 {
+				root = node
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("%w: empty document", ErrInvalidSCXML)
+	}
+	return root, nil
+}
+
+type scxmlState struct {
+	id          string
+	parent      *scxmlState
+	children    []*scxmlState
+	initial     string
+	transitions []scxmlTransition
+}
+
+type scxmlTransition struct {
+	events []string
+	target string
+	line   int
+}
+
+type scxmlDocument struct {
+	states   map[string]*scxmlState
+	order    []*scxmlState
+	warnings []SCXMLWarning
+}
+
+func (p *scxmlDocument) warn(node *scxmlNode, format string, args ...interface{}) {
+	p.warnings = append(p.warnings, SCXMLWarning{
+		Line:    node.line,
+		Element: node.name,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (p *scxmlDocument) readChildren(node *scxmlNode, parent *scxmlState) error {
+	for _, child := range node.children {
+		switch child.name {
+		case "state", "final":
+			if err := p.readState(child, parent); err != nil {
+				return err
+			}
+		case "transition":
+			if parent == nil {
+				p.warn(child, "transition outside of a state is skipped")
+				continue
+			}
+			p.readTransition(child, parent)
+		case "initial":
+			if parent == nil {
+				p.warn(child, "initial outside of a state is skipped")
+				continue
+			}
+			if err := p.readInitial(child, parent); err != nil {
+				return err
+			}
+		case "datamodel", "script":
+			p.warn(child, "data model is not supported")
+		case "onentry", "onexit":
+			p.warn(child, "executable content is not supported")
+		case "parallel", "history", "invoke", "donedata":
+			p.warn(child, "not supported")
+		default:
+			p.warn(child, "unknown element")
+		}
+	}
+	return nil
+}
+
+func (p *scxmlDocument) readState(node *scxmlNode, parent *scxmlState) error {
+	id := node.attrs["id"]
+	if id == "" {
+		return fmt.Errorf("%w: line %d: <%s> without id", ErrInvalidSCXML, node.line, node.name)
+	}
+	if _, ok := p.states[id]; ok {
+		return fmt.Errorf("%w: line %d: duplicate state id %q", ErrInvalidSCXML, node.line, id)
+	}
+
+	s := &scxmlState{id: id, parent: parent}
+	if initial, ok := node.attrs["initial"]; ok {
+		if err := p.setInitial(node, s, initial); err != nil {
+			return err
+		}
+	}
+	p.states[id] = s
+	p.order = append(p.order, s)
+	if parent != nil {
+		parent.children = append(parent.children, s)
+	}
+	return p.readChildren(node, s)
+}
+
+func (p *scxmlDocument) readInitial(node *scxmlNode, parent *scxmlState) error {
+	for _, child := range node.children {
+		if child.name != "transition" {
+			p.warn(child, "unknown element")
+			continue
+		}
+		if len(child.children) > 0 {
+			p.warn(child, "executable content is not supported")
+		}
+		if err := p.setInitial(child, parent, child.attrs["target"]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setInitial set the initial state of a compound state by the ids in node,
+// only the first one is entered because parallel states are not supported
+func (p *scxmlDocument) setInitial(node *scxmlNode, s *scxmlState, ids string) error {
+	initial := strings.Fields(ids)
+	if len(initial) == 0 {
+		return fmt.Errorf("%w: line %d: <%s> with empty initial of state %q", ErrInvalidSCXML, node.line, node.name, s.id)
+	}
+	if len(initial) > 1 {
+		p.warn(node, "multiple initial states, only %q is entered", initial[0])
+	}
+	s.initial = initial[0]
+	return nil
+}
+
+func (p *scxmlDocument) readTransition(node *scxmlNode, parent *scxmlState) {
+	if len(node.children) > 0 {
+		p.warn(node, "executable content is not supported")
+	}
+	if _, ok := node.attrs["cond"]; ok {
+		p.warn(node, "condition is not supported, the transition is always taken")
+	}
+
+	events := strings.Fields(node.attrs["event"])
+	targets := strings.Fields(node.attrs["target"])
+	switch {
+	case len(events) == 0:
+		p.warn(node, "eventless transition is skipped")
+		return
+	case len(targets) > 1:
+		p.warn(node, "transition with multiple targets is skipped")
+		return
+	}
+
+	var supported []string
+	for _, event := range events {
+		if strings.HasSuffix(event, "*") {
+			p.warn(node, "wildcard event %q is skipped", event)
+			continue
+		}
+		supported = append(supported, event)
+	}
+	if len(supported) == 0 {
+		return
+	}
+	t := scxmlTransition{events: supported, line: node.line}
+	if len(targets) > 0 {
+		t.target = targets[0]
+	}
+	parent.transitions = append(parent.transitions, t)
+}
+
+// enter get the atomic state entered by target
+func (p *scxmlDocument) enter(target string) (*scxmlState, error) {
+	s, ok := p.states[target]
+	if !ok {
+		return nil, fmt.Errorf("unknown target %q", target)
+	}
+	visited := make(map[*scxmlState]bool)
+	for len(s.children) > 0 {
+		visited[s] = true
+		next := s.children[0]
+		if s.initial != "" {
+			if next, ok = p.states[s.initial]; !ok {
+				return nil, fmt.Errorf("unknown initial %q of state %q", s.initial, s.id)
+			}
+		}
+		if visited[next] {
+			return nil, fmt.Errorf("initial of state %q enters a loop", s.id)
+		}
+		s = next
+	}
+	return s, nil
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
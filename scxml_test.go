@@ -0,0 +1,277 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// transitionsOf get transactions by "current event" with their targets, internal ones target themselves
+func transitionsOf(ts []*Transaction) map[string]string {
+	m := make(map[string]string, len(ts))
+	for _, t := range ts {
+		m[t.CurrentStatus+" "+t.Event] = t.TargetStatus
+	}
+	return m
+}
+
+const nestedSCXML = `<?xml version="1.0"?>
+<scxml xmlns="http://www.w3.org/2005/07/scxml" version="1.0" name="order">
+  <state id="open" initial="created">
+    <transition event="cancel" target="canceled"/>
+    <state id="created">
+      <transition event="pay" target="paying"/>
+    </state>
+    <state id="paying">
+      <initial>
+        <transition target="authorizing"/>
+      </initial>
+      <transition event="cancel" target="refunding"/>
+      <state id="capturing">
+        <transition event="captured" target="paid"/>
+      </state>
+      <state id="authorizing">
+        <transition event="authorized" target="capturing"/>
+      </state>
+    </state>
+    <state id="paid">
+      <transition event="ship" target="shipped"/>
+      <transition event="note"/>
+    </state>
+  </state>
+  <state id="refunding">
+    <transition event="refunded" target="canceled"/>
+  </state>
+  <final id="shipped"/>
+  <final id="canceled"/>
+</scxml>
+`
+
+func TestReadSCXMLNested(t *testing.T) {
+	ts, warnings, err := ReadSCXML(strings.NewReader(nestedSCXML), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("warnings = %v, want none", warnings)
+	}
+	want := map[string]string{
+		// the transition of open applies to its descendants
+		"created cancel": "canceled",
+		"paid cancel":    "canceled",
+		// unless they define the same event
+		"authorizing cancel": "refunding",
+		"capturing cancel":   "refunding",
+		// a compound target enters its initial, set by <initial>
+		"created pay":            "authorizing",
+		"authorizing authorized": "capturing",
+		"capturing captured":     "paid",
+		"paid ship":              "shipped",
+		"paid note":              "paid",
+		"refunding refunded":     "canceled",
+	}
+	got := transitionsOf(ts)
+	if len(got) != len(want) {
+		t.Fatalf("read %d transactions %v, want %d", len(got), got, len(want))
+	}
+	for key, target := range want {
+		if got[key] != target {
+			t.Errorf("%s targets %q, want %q", key, got[key], target)
+		}
+	}
+	for _, tr := range ts {
+		if tr.Namespace != "order" {
+			t.Fatalf("namespace = %q, want order", tr.Namespace)
+		}
+		if tr.Event == "note" && tr.Kind != TransitionInternal {
+			t.Fatalf("targetless transition kind = %q, want internal", tr.Kind)
+		}
+	}
+}
+
+func TestReadSCXMLFinal(t *testing.T) {
+	ts, _, err := ReadSCXML(strings.NewReader(nestedSCXML), "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewNamespaceGraph("orders", ts)
+	if len(g.Terminals) != 2 || g.Terminals[0] != "canceled" || g.Terminals[1] != "shipped" {
+		t.Fatalf("terminals = %v, want canceled and shipped", g.Terminals)
+	}
+}
+
+func TestReadSCXMLWarnings(t *testing.T) {
+	doc := `<scxml xmlns="http://www.w3.org/2005/07/scxml" name="door">
+  <datamodel>
+    <data id="opened" expr="0"/>
+  </datamodel>
+  <state id="closed">
+    <onentry><log expr="'closed'"/></onentry>
+    <transition event="open" target="opened" cond="locked == false"><assign location="opened" expr="1"/></transition>
+  </state>
+  <state id="opened">
+    <onexit><log expr="'leaving'"/></onexit>
+    <transition event="close" target="closed"/>
+  </state>
+</scxml>`
+	ts, warnings, err := ReadSCXML(strings.NewReader(doc), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 2 {
+		t.Fatalf("read %d transactions, want 2", len(ts))
+	}
+	want := []string{
+		"line 2: <datamodel>: data model is not supported",
+		"line 6: <onentry>: executable content is not supported",
+		"line 7: <transition>: executable content is not supported",
+		"line 7: <transition>: condition is not supported, the transition is always taken",
+		"line 10: <onexit>: executable content is not supported",
+	}
+	if len(warnings) != len(want) {
+		t.Fatalf("warnings = %v, want %v", warnings, want)
+	}
+	for i, w := range warnings {
+		if w.String() != want[i] {
+			t.Errorf("warning %d = %q, want %q", i, w.String(), want[i])
+		}
+	}
+}
+
+func TestReadSCXMLEmptyInitial(t *testing.T) {
+	for name, doc := range map[string]string{
+		"attribute": `<scxml name="n">
+  <state id="a" initial=" ">
+    <state id="b"/>
+  </state>
+</scxml>`,
+		"element": `<scxml name="n">
+  <state id="a">
+    <initial>
+      <transition target=""/>
+    </initial>
+    <state id="b"/>
+  </state>
+</scxml>`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := ReadSCXML(strings.NewReader(doc), "")
+			if !errors.Is(err, ErrInvalidSCXML) {
+				t.Fatalf("ReadSCXML() = %v, want %v", err, ErrInvalidSCXML)
+			}
+			if !strings.Contains(err.Error(), "line 2") && !strings.Contains(err.Error(), "line 4") {
+				t.Fatalf("error %q has no line", err)
+			}
+		})
+	}
+}
+
+func TestExportSCXMLRoundTrip(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	ts, _, err := ReadSCXML(strings.NewReader(nestedSCXML), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.ReplaceNamespaces(nil, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	warnings, err := ExportSCXML(&buf, "order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("warnings = %v, want none", warnings)
+	}
+	if !strings.Contains(buf.String(), `<final id="shipped">`) || !strings.Contains(buf.String(), `initial="created"`) {
+		t.Fatalf("exported document:\n%s", buf.String())
+	}
+
+	exported, _, err := ReadSCXML(&buf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := transitionsOf(exported), transitionsOf(ts)
+	if len(got) != len(want) {
+		t.Fatalf("read back %v, want %v", got, want)
+	}
+	for key, target := range want {
+		if got[key] != target {
+			t.Errorf("%s targets %q, want %q", key, got[key], target)
+		}
+	}
+}
+
+func TestExportSCXMLGuard(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	repo.Add(&Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid",
+		Guard: "payload.amount > 0"})
+
+	var buf bytes.Buffer
+	warnings, err := ExportSCXML(&buf, "order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `cond="payload.amount &gt; 0"`) {
+		t.Fatalf("guard is not exported as cond:\n%s", buf.String())
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Message, "guard of \"pay\" from \"created\"") {
+		t.Fatalf("warnings = %v, want the guard", warnings)
+	}
+}
+
+func TestExportSCXMLUnsupported(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+	if err := repo.Table().SetVariable(&Variable{Namespace: "counter", Name: "n", Type: VariableInt}); err != nil {
+		t.Fatal(err)
+	}
+	repo.Add(&Transaction{Namespace: "counter", CurrentStatus: "on", Event: "tick", Kind: TransitionInternal,
+		Assignments: []*Assignment{{Variable: "n", Op: AssignAdd, Value: 1}}})
+	repo.Add(&Transaction{Namespace: "counter", CurrentStatus: "on", Event: "stop", TargetStatus: "off", Output: "bye"})
+	repo.Table().SetOutput("counter", "off", "stopped")
+
+	warnings, err := ExportSCXML(&bytes.Buffer{}, "counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`output of "stop" from "on" is not supported`,
+		`assignments of "tick" from "on" are not supported`,
+		`output of status "off" is not supported`,
+		"1 variables are not supported",
+	}
+	for _, w := range want {
+		found := false
+		for _, warning := range warnings {
+			found = found || strings.Contains(warning.Message, w)
+		}
+		if !found {
+			t.Errorf("no warning %q in %v", w, warnings)
+		}
+	}
+}
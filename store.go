@@ -0,0 +1,259 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VersionSeparator the separator between namespace's name and version, e.g. orders@v3
+const VersionSeparator = "@"
+
+// VersionedNamespace join namespace's name and version, the name is returned if version is empty
+func VersionedNamespace(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + VersionSeparator + version
+}
+
+// SplitNamespace split a versioned namespace into name and version
+func SplitNamespace(namespace string) (name, version string) {
+	i := strings.LastIndex(namespace, VersionSeparator)
+	if i < 0 {
+		return namespace, ""
+	}
+	return namespace[:i], namespace[i+len(VersionSeparator):]
+}
+
+// Instance an object walking in a namespace
+type Instance struct {
+	ID string `json:"id"`
+	// Namespace the namespace the instance walks in, may be versioned
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+	// Data the extended state, persisted with the status
+	Data Data `json:"data,omitempty"`
+	// Revision increased by the store on every put, for optimistic concurrency
+	Revision int64 `json:"revision"`
+}
+
+// Snapshot get the snapshot of the instance to restore a machine
+func (p *Instance) Snapshot() *Snapshot[string] {
+	return &Snapshot[string]{
+		Namespace: p.Namespace,
+		Status:    p.Status,
+		Data:      p.Data.Copy(),
+		Revision:  p.Revision,
+	}
+}
+
+// SetSnapshot set status and extended state of the instance from a machine's snapshot,
+// the revision is kept because it is managed by the store
+func (p *Instance) SetSnapshot(s *Snapshot[string]) {
+	p.Status, p.Data = s.Status, s.Data.Copy()
+	if s.Namespace != "" {
+		p.Namespace = s.Namespace
+	}
+}
+
+func (p *Instance) valid() error {
+	if p == nil || p.ID == "" || p.Namespace == "" || p.Status == "" {
+		return ErrInvalidInstance
+	}
+	return nil
+}
+
+// Store the functions of instance storage
+type Store interface {
+	// get an instance by id
+	Get(id string) (*Instance, error)
+	// put an instance whose revision equals the stored one, 0 for a new instance,
+	// the revision of instance is increased if succeeded
+	Put(*Instance) error
+	// delete an instance by id
+	Delete(id string) error
+	// walk copies of all instances until fn returns false
+	Range(fn func(*Instance) bool) error
+}
+
+type memoryStore struct {
+	instances map[string]*Instance
+
+	sync.RWMutex
+}
+
+// NewMemoryStore new an in-memory store
+func NewMemoryStore() Store {
+	return newMemoryStore()
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{instances: make(map[string]*Instance)}
+}
+
+func (p *memoryStore) Get(id string) (*Instance, error) {
+	p.RLock()
+	defer p.RUnlock()
+	inst, ok := p.instances[id]
+	if !ok {
+		return nil, ErrInstanceNotFound
+	}
+	copied := *inst
+	copied.Data = inst.Data.Copy()
+	return &copied, nil
+}
+
+func (p *memoryStore) Put(inst *Instance) error {
+	if e := inst.valid(); e != nil {
+		return e
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.put(inst)
+}
+
+// put put the instance in the lock
+func (p *memoryStore) put(inst *Instance) error {
+	var revision int64
+	if stored, ok := p.instances[inst.ID]; ok {
+		revision = stored.Revision
+	}
+	if inst.Revision != revision {
+		return ErrRevisionConflict
+	}
+	inst.Revision++
+	copied := *inst
+	copied.Data = inst.Data.Copy()
+	p.instances[inst.ID] = &copied
+	return nil
+}
+
+func (p *memoryStore) Delete(id string) error {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.instances[id]; !ok {
+		return ErrInstanceNotFound
+	}
+	delete(p.instances, id)
+	return nil
+}
+
+func (p *memoryStore) Range(fn func(*Instance) bool) error {
+	p.RLock()
+	ids := make([]string, 0, len(p.instances))
+	for id := range p.instances {
+		ids = append(ids, id)
+	}
+	p.RUnlock()
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		inst, err := p.Get(id)
+		if err == ErrInstanceNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !fn(inst) {
+			return nil
+		}
+	}
+	return nil
+}
+
+type memoryOutboxStore struct {
+	*memoryStore
+	// outbox records in order of putting, guarded by the lock of memoryStore
+	outbox []*OutboxRecord
+}
+
+// NewMemoryOutboxStore new an in-memory store with an outbox, records are kept until they are acknowledged
+func NewMemoryOutboxStore() OutboxStore {
+	return &memoryOutboxStore{memoryStore: newMemoryStore()}
+}
+
+func (p *memoryOutboxStore) PutWithOutbox(inst *Instance, records ...*OutboxRecord) error {
+	if e := inst.valid(); e != nil {
+		return e
+	}
+	p.Lock()
+	defer p.Unlock()
+	if e := p.put(inst); e != nil {
+		return e
+	}
+	for _, r := range records {
+		record := *r
+		p.outbox = append(p.outbox, &record)
+	}
+	return nil
+}
+
+func (p *memoryOutboxStore) PendingOutbox(now time.Time, limit int) ([]*OutboxRecord, error) {
+	p.RLock()
+	defer p.RUnlock()
+	var records []*OutboxRecord
+	// instances whose earlier record is waiting for its next attempt
+	waiting := make(map[string]bool)
+	for _, r := range p.outbox {
+		if len(records) >= limit {
+			break
+		}
+		if waiting[r.InstanceID] {
+			continue
+		}
+		if r.NextAttempt.After(now) {
+			waiting[r.InstanceID] = true
+			continue
+		}
+		copied := *r
+		records = append(records, &copied)
+	}
+	return records, nil
+}
+
+func (p *memoryOutboxStore) AckOutbox(id string) error {
+	p.Lock()
+	defer p.Unlock()
+	for i, r := range p.outbox {
+		if r.ID == id {
+			p.outbox = append(p.outbox[:i], p.outbox[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *memoryOutboxStore) RetryOutbox(id string, next time.Time, err error) error {
+	p.Lock()
+	defer p.Unlock()
+	for _, r := range p.outbox {
+		if r.ID == id {
+			r.Attempts++
+			r.NextAttempt = next
+			if err != nil {
+				r.LastError = err.Error()
+			}
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"sort"
+	"sync"
+)
+
+type transitionKey[S, E comparable] struct {
+	status S
+	event  E
+}
+
+// Table transitions of custom status and event types in namespaces, it is safe for concurrent use
+type Table[S, E comparable] struct {
+	transitions map[string]map[transitionKey[S, E]]*Transition[S, E]
+	// outputs the Moore outputs of statuses in namespaces
+	outputs map[string]map[S]string
+	// variables the extended state variables of namespaces by name
+	variables map[string]map[string]*Variable
+
+	// wildcard transitions from it apply to every status without the same event
+	wildcard    S
+	hasWildcard bool
+
+	sync.RWMutex
+}
+
+// TableOption table option function
+type TableOption[S, E comparable] func(*Table[S, E])
+
+// TableWildcard set the wildcard status, transitions from it apply to every status,
+// unless the status has a transition of the same event
+func TableWildcard[S, E comparable](status S) TableOption[S, E] {
+	return func(t *Table[S, E]) {
+		t.wildcard, t.hasWildcard = status, true
+	}
+}
+
+// NewTable new an empty table
+func NewTable[S, E comparable](opts ...TableOption[S, E]) *Table[S, E] {
+	t := &Table[S, E]{
+		transitions: make(map[string]map[transitionKey[S, E]]*Transition[S, E]),
+		outputs:     make(map[string]map[S]string),
+		variables:   make(map[string]map[string]*Variable),
+	}
+	for _, o := range opts {
+		o(t)
+	}
+	return t
+}
+
+// Wildcard get the wildcard status
+func (p *Table[S, E]) Wildcard() (S, bool) {
+	return p.wildcard, p.hasWildcard
+}
+
+// Add add a transition, a transition of Currents is added per current status,
+// variables read by its guard and assignments must be declared in its namespace before
+func (p *Table[S, E]) Add(t *Transition[S, E]) error {
+	if e := t.valid(); e != nil {
+		return e
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if e := t.checkVariables(p.variables[t.Namespace]); e != nil {
+		return e
+	}
+	p.add(t)
+	return nil
+}
+
+func (p *Table[S, E]) add(t *Transition[S, E]) {
+	spaceTrans := p.transitions[t.Namespace]
+	if spaceTrans == nil {
+		spaceTrans = make(map[transitionKey[S, E]]*Transition[S, E])
+		p.transitions[t.Namespace] = spaceTrans
+	}
+	var guard *Expr
+	if t.Guard != "" {
+		// the guard is valid, types of payload and state are checked when it is evaluated
+		guard, _ = CompileGuard(t.Guard, nil)
+	}
+	for _, expanded := range t.expand() {
+		expanded.guard = guard
+		spaceTrans[transitionKey[S, E]{status: expanded.CurrentStatus, event: expanded.Event}] = expanded
+	}
+}
+
+// GetTransition get transition by current status and event, nil if not exists,
+// a transition from the status beats the one from wildcard
+func (p *Table[S, E]) GetTransition(namespace string, curStatus S, event E) *Transition[S, E] {
+	p.RLock()
+	defer p.RUnlock()
+	spaceTrans := p.transitions[namespace]
+	if t := spaceTrans[transitionKey[S, E]{status: curStatus, event: event}]; t != nil || !p.hasWildcard {
+		return t
+	}
+	if t := spaceTrans[transitionKey[S, E]{status: p.wildcard, event: event}]; t != nil {
+		return t.at(curStatus)
+	}
+	return nil
+}
+
+// GetAvailable get copies of transitions which can be fired in the status, including wildcard ones
+func (p *Table[S, E]) GetAvailable(namespace string, curStatus S) []*Transition[S, E] {
+	p.RLock()
+	defer p.RUnlock()
+	spaceTrans := p.transitions[namespace]
+
+	var ts []*Transition[S, E]
+	for key, t := range spaceTrans {
+		switch {
+		case key.status == curStatus:
+		case p.hasWildcard && key.status == p.wildcard:
+			if _, ok := spaceTrans[transitionKey[S, E]{status: curStatus, event: key.event}]; ok {
+				continue
+			}
+		default:
+			continue
+		}
+		ts = append(ts, t.at(curStatus).clone())
+	}
+	return ts
+}
+
+// SetOutput set the Moore output of a status in namespace, an empty output removes it
+func (p *Table[S, E]) SetOutput(namespace string, status S, output string) {
+	p.Lock()
+	defer p.Unlock()
+	p.setOutput(namespace, status, output)
+}
+
+func (p *Table[S, E]) setOutput(namespace string, status S, output string) {
+	spaceOutputs := p.outputs[namespace]
+	if output == "" {
+		delete(spaceOutputs, status)
+		if len(spaceOutputs) == 0 {
+			delete(p.outputs, namespace)
+		}
+		return
+	}
+	if spaceOutputs == nil {
+		spaceOutputs = make(map[S]string)
+		p.outputs[namespace] = spaceOutputs
+	}
+	spaceOutputs[status] = output
+}
+
+// GetOutput get the Moore output of a status in namespace, empty if not set
+func (p *Table[S, E]) GetOutput(namespace string, status S) string {
+	p.RLock()
+	defer p.RUnlock()
+	return p.outputs[namespace][status]
+}
+
+// GetOutputs get a copy of namespace's Moore outputs by status
+func (p *Table[S, E]) GetOutputs(namespace string) map[S]string {
+	p.RLock()
+	defer p.RUnlock()
+	outputs := make(map[S]string, len(p.outputs[namespace]))
+	for status, output := range p.outputs[namespace] {
+		outputs[status] = output
+	}
+	return outputs
+}
+
+// SetVariable declare a variable of the extended state in its namespace, the same name is replaced
+func (p *Table[S, E]) SetVariable(v *Variable) error {
+	if v == nil {
+		return ErrInvalidVariable
+	}
+	copied := *v
+	if e := copied.valid(); e != nil {
+		return e
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.setVariable(&copied)
+	return nil
+}
+
+func (p *Table[S, E]) setVariable(v *Variable) {
+	spaceVars := p.variables[v.Namespace]
+	if spaceVars == nil {
+		spaceVars = make(map[string]*Variable)
+		p.variables[v.Namespace] = spaceVars
+	}
+	spaceVars[v.Name] = v
+}
+
+// GetVariables get copies of namespace's variables ordered by name
+func (p *Table[S, E]) GetVariables(namespace string) []*Variable {
+	p.RLock()
+	defer p.RUnlock()
+	vs := make([]*Variable, 0, len(p.variables[namespace]))
+	for _, v := range p.variables[namespace] {
+		copied := *v
+		vs = append(vs, &copied)
+	}
+	sortVariables(vs)
+	return vs
+}
+
+// variablesOf get namespace's variables by name, they must not be changed
+func (p *Table[S, E]) variablesOf(namespace string) map[string]*Variable {
+	p.RLock()
+	defer p.RUnlock()
+	vs := make(map[string]*Variable, len(p.variables[namespace]))
+	for name, v := range p.variables[namespace] {
+		vs[name] = v
+	}
+	return vs
+}
+
+// hasNamespace judge the namespace has transitions
+func (p *Table[S, E]) hasNamespace(namespace string) bool {
+	p.RLock()
+	defer p.RUnlock()
+	return len(p.transitions[namespace]) > 0
+}
+
+// GetNamespaces get all namespaces in order
+func (p *Table[S, E]) GetNamespaces() []string {
+	p.RLock()
+	defer p.RUnlock()
+	namespaces := make([]string, 0, len(p.transitions))
+	for namespace := range p.transitions {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// GetTransitions get copies of namespace's transitions in no particular order
+func (p *Table[S, E]) GetTransitions(namespace string) []*Transition[S, E] {
+	p.RLock()
+	defer p.RUnlock()
+	spaceTrans := p.transitions[namespace]
+	ts := make([]*Transition[S, E], 0, len(spaceTrans))
+	for _, t := range spaceTrans {
+		ts = append(ts, t.clone())
+	}
+	return ts
+}
+
+// Remove remove all transitions
+func (p *Table[S, E]) Remove() {
+	p.Lock()
+	defer p.Unlock()
+	p.transitions = make(map[string]map[transitionKey[S, E]]*Transition[S, E])
+	p.outputs = make(map[string]map[S]string)
+	p.variables = make(map[string]map[string]*Variable)
+}
+
+// RemoveNamespace remove namespace's transitions, outputs and variables
+func (p *Table[S, E]) RemoveNamespace(namespace string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.transitions, namespace)
+	delete(p.outputs, namespace)
+	delete(p.variables, namespace)
+}
+
+// RemoveTransition remove a transition by namespace, current status and event
+func (p *Table[S, E]) RemoveTransition(t *Transition[S, E]) error {
+	if e := t.validCurrent(); e != nil {
+		return e
+	}
+	p.Lock()
+	defer p.Unlock()
+	spaceTrans := p.transitions[t.Namespace]
+	for _, expanded := range t.expand() {
+		delete(spaceTrans, transitionKey[S, E]{status: expanded.CurrentStatus, event: expanded.Event})
+	}
+	if len(spaceTrans) == 0 {
+		delete(p.transitions, t.Namespace)
+	}
+	return nil
+}
+
+// TableDefinition transitions, status outputs and variables of namespaces
+type TableDefinition[S, E comparable] struct {
+	Transitions []*Transition[S, E]
+	Outputs     []*StatusOutput[S]
+	Variables   []*Variable
+}
+
+// ReplaceNamespaces remove the namespaces and add the transitions and outputs in one lock,
+// nothing changes if any transition or output is invalid
+func (p *Table[S, E]) ReplaceNamespaces(namespaces []string, ts []*Transition[S, E], outputs ...*StatusOutput[S]) error {
+	return p.ReplaceDefinition(namespaces, &TableDefinition[S, E]{Transitions: ts, Outputs: outputs})
+}
+
+// ReplaceDefinition remove the namespaces with their outputs and variables, and add the definition in one lock,
+// nothing changes if anything in the definition is invalid
+func (p *Table[S, E]) ReplaceDefinition(namespaces []string, def *TableDefinition[S, E]) error {
+	for _, t := range def.Transitions {
+		if e := t.valid(); e != nil {
+			return e
+		}
+	}
+	for _, o := range def.Outputs {
+		if e := o.valid(); e != nil {
+			return e
+		}
+	}
+	vs := make([]*Variable, 0, len(def.Variables))
+	for _, v := range def.Variables {
+		if v == nil {
+			return ErrInvalidVariable
+		}
+		copied := *v
+		if e := copied.valid(); e != nil {
+			return e
+		}
+		vs = append(vs, &copied)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	spaceVars := p.replacedVariables(namespaces, vs)
+	for _, t := range def.Transitions {
+		if e := t.checkVariables(spaceVars[t.Namespace]); e != nil {
+			return e
+		}
+	}
+
+	for _, namespace := range namespaces {
+		delete(p.transitions, namespace)
+		delete(p.outputs, namespace)
+		delete(p.variables, namespace)
+	}
+	for _, v := range vs {
+		p.setVariable(v)
+	}
+	for _, t := range def.Transitions {
+		p.add(t)
+	}
+	for _, o := range def.Outputs {
+		p.setOutput(o.Namespace, o.Status, o.Output)
+	}
+	return nil
+}
+
+// replacedVariables get the variables by namespace after removing the namespaces and declaring vs
+func (p *Table[S, E]) replacedVariables(namespaces []string, vs []*Variable) map[string]map[string]*Variable {
+	removed := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		removed[namespace] = true
+	}
+	result := make(map[string]map[string]*Variable, len(p.variables))
+	for namespace, spaceVars := range p.variables {
+		if removed[namespace] {
+			continue
+		}
+		result[namespace] = make(map[string]*Variable, len(spaceVars))
+		for name, v := range spaceVars {
+			result[namespace][name] = v
+		}
+	}
+	for _, v := range vs {
+		if result[v.Namespace] == nil {
+			result[v.Namespace] = make(map[string]*Variable)
+		}
+		result[v.Namespace][v.Name] = v
+	}
+	return result
+}
@@ -0,0 +1,183 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"errors"
+	"testing"
+)
+
+type lightStatus int
+
+const (
+	lightOff lightStatus = iota
+	lightOn
+)
+
+type lightEvent int
+
+const (
+	lightToggle lightEvent = iota
+	lightReset
+)
+
+func TestTableZeroValues(t *testing.T) {
+	table := NewTable[lightStatus, lightEvent]()
+	ts := []*Transition[lightStatus, lightEvent]{
+		{Namespace: "light", CurrentStatus: lightOff, Event: lightToggle, TargetStatus: lightOn},
+		{Namespace: "light", CurrentStatus: lightOn, Event: lightToggle, TargetStatus: lightOff},
+		{Namespace: "light", Currents: []lightStatus{lightOff, lightOn}, Event: lightReset, Kind: TransitionInternal},
+	}
+	for _, tr := range ts {
+		if err := table.Add(tr); err != nil {
+			t.Fatalf("Add(%+v) = %v", tr, err)
+		}
+	}
+	table.SetOutput("light", lightOff, "dark")
+
+	if got := table.GetTransition("light", lightOn, lightToggle); got == nil || got.TargetStatus != lightOff {
+		t.Fatalf("GetTransition(on, toggle) = %+v, want target off", got)
+	}
+	if got := table.GetTransition("light", lightOff, lightReset); got == nil || got.TargetStatus != lightOff {
+		t.Fatalf("GetTransition(off, reset) = %+v, want target off", got)
+	}
+	if got := table.GetOutput("light", lightOff); got != "dark" {
+		t.Fatalf("GetOutput(off) = %q, want dark", got)
+	}
+}
+
+func TestTableEmptyStrings(t *testing.T) {
+	table := NewTable[string, string]()
+	tests := []struct {
+		t    *Transaction
+		want error
+	}{
+		{&Transaction{Namespace: "ns", Event: "e", TargetStatus: "b"}, ErrInvalidTransaction},
+		{&Transaction{Namespace: "ns", CurrentStatus: "a", TargetStatus: "b"}, ErrInvalidTransaction},
+		{&Transaction{Namespace: "ns", CurrentStatus: "a", Event: "e"}, ErrTargetStatusEmpty},
+		{&Transaction{Namespace: "ns", Currents: []string{"a", ""}, Event: "e", TargetStatus: "b"}, ErrInvalidTransaction},
+	}
+	for _, tt := range tests {
+		if err := table.Add(tt.t); !errors.Is(err, tt.want) {
+			t.Errorf("Add(%+v) = %v, want %v", tt.t, err, tt.want)
+		}
+	}
+	if err := (&StatusOutput[string]{Namespace: "ns", Output: "out"}).valid(); !errors.Is(err, ErrInvalidOutput) {
+		t.Errorf("valid(empty status) = %v, want %v", err, ErrInvalidOutput)
+	}
+	if err := (&StatusOutput[lightStatus]{Namespace: "light", Status: lightOff, Output: "dark"}).valid(); err != nil {
+		t.Errorf("valid(zero status) = %v", err)
+	}
+}
+
+func TestTableVariableUses(t *testing.T) {
+	table := NewTable[string, string]()
+	guarded := &Transaction{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid",
+		Guard: "state.c > 1"}
+	assigned := &Transaction{Namespace: "order", CurrentStatus: "paid", Event: "retry", TargetStatus: "created",
+		Assignments: []*Assignment{{Variable: "c", Op: AssignAdd, Value: 1}}}
+	if err := table.Add(guarded); !errors.Is(err, ErrInvalidExpr) {
+		t.Fatalf("Add(undeclared guard variable) = %v, want %v", err, ErrInvalidExpr)
+	}
+	if err := table.Add(assigned); !errors.Is(err, ErrInvalidAssignment) {
+		t.Fatalf("Add(undeclared assignment variable) = %v, want %v", err, ErrInvalidAssignment)
+	}
+	def := &TableDefinition[string, string]{Transitions: []*Transaction{guarded, assigned}}
+	if err := table.ReplaceDefinition([]string{"order"}, def); err == nil {
+		t.Fatal("ReplaceDefinition(undeclared variables) succeeded")
+	}
+	if len(table.GetTransitions("order")) != 0 {
+		t.Fatal("rejected transitions were added")
+	}
+
+	def.Variables = []*Variable{{Namespace: "order", Name: "c", Type: VariableInt}}
+	if err := table.ReplaceDefinition([]string{"order"}, def); err != nil {
+		t.Fatalf("ReplaceDefinition = %v", err)
+	}
+	if err := table.Add(&Transaction{Namespace: "order", CurrentStatus: "paid", Event: "ship", TargetStatus: "shipped",
+		Guard: "state.c == 'x'"}); !errors.Is(err, ErrInvalidExpr) {
+		t.Fatalf("Add(mistyped guard) = %v, want %v", err, ErrInvalidExpr)
+	}
+}
+
+func TestTableWildcardPrecedence(t *testing.T) {
+	wildcard := &Transaction{Namespace: "order", CurrentStatus: AnyStatus, Event: "cancel", TargetStatus: "canceled"}
+	exact := &Transaction{Namespace: "order", CurrentStatus: "shipped", Event: "cancel", TargetStatus: "returned"}
+
+	// the exact transition wins whichever is added first
+	for _, order := range [][]*Transaction{{wildcard, exact}, {exact, wildcard}} {
+		table := NewTable(TableWildcard[string, string](AnyStatus))
+		for _, tr := range order {
+			if err := table.Add(tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := table.GetTransition("order", "shipped", "cancel"); got == nil || got.TargetStatus != "returned" {
+			t.Fatalf("GetTransition(shipped, cancel) = %+v, want returned", got)
+		}
+		got := table.GetTransition("order", "created", "cancel")
+		if got == nil || got.TargetStatus != "canceled" || got.CurrentStatus != AnyStatus {
+			t.Fatalf("GetTransition(created, cancel) = %+v, want the wildcard", got)
+		}
+		if available := table.GetAvailable("order", "shipped"); len(available) != 1 || available[0].TargetStatus != "returned" {
+			t.Fatalf("GetAvailable(shipped) = %+v, want only the exact cancel", available)
+		}
+	}
+
+	// without the wildcard option "*" is an ordinary status
+	table := NewTable[string, string]()
+	if err := table.Add(wildcard); err != nil {
+		t.Fatal(err)
+	}
+	if got := table.GetTransition("order", "created", "cancel"); got != nil {
+		t.Fatalf("GetTransition(created, cancel) without wildcards = %+v, want nil", got)
+	}
+}
+
+func TestTableCurrents(t *testing.T) {
+	table := NewTable(TableWildcard[string, string](AnyStatus))
+	cancel := &Transaction{Namespace: "order", Currents: []string{"created", "paid"}, Event: "cancel",
+		TargetStatus: "canceled"}
+	if err := table.Add(cancel); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := table.GetTransitions("order")
+	sortTransactions(ts)
+	if len(ts) != 2 {
+		t.Fatalf("GetTransitions() = %+v, want a transition per current status", ts)
+	}
+	for i, current := range []string{"created", "paid"} {
+		if ts[i].CurrentStatus != current || ts[i].Currents != nil || ts[i].TargetStatus != "canceled" {
+			t.Errorf("transition %d = %+v, want cancel from %s", i, ts[i], current)
+		}
+		if got := table.GetTransition("order", current, "cancel"); got == nil {
+			t.Errorf("GetTransition(%s, cancel) = nil", current)
+		}
+	}
+	if got := table.GetTransition("order", "shipped", "cancel"); got != nil {
+		t.Fatalf("GetTransition(shipped, cancel) = %+v, want nil", got)
+	}
+
+	if err := table.RemoveTransition(cancel); err != nil {
+		t.Fatal(err)
+	}
+	if ts = table.GetTransitions("order"); len(ts) != 0 {
+		t.Fatalf("GetTransitions() after removing = %+v", ts)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// names of spans started by machines
+const (
+	SpanFire   = "fsm.fire"
+	SpanGuard  = "fsm.guard"
+	SpanAction = "fsm.action"
+)
+
+// keys of span attributes set by machines
+const (
+	AttributeNamespace = "fsm.namespace"
+	AttributeInstance  = "fsm.instance"
+	AttributeEvent     = "fsm.event"
+	AttributeFrom      = "fsm.from"
+	AttributeTo        = "fsm.to"
+	AttributeKind      = "fsm.kind"
+	AttributeGuard     = "fsm.guard"
+	AttributeAction    = "fsm.action"
+)
+
+// Attribute a key and value of a span
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Tracer start spans, it is shaped like the tracer of OpenTelemetry so that an adapter only converts attributes
+type Tracer interface {
+	// Start start a span as a child of the span in ctx, the returned context holds the new span
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span a timed operation started by a tracer
+type Span interface {
+	// SetAttributes set attributes of the span
+	SetAttributes(attrs ...Attribute)
+	// SetError mark the span failed with the error
+	SetError(err error)
+	// End end the span, it is called once
+	End()
+}
+
+// RecordedSpan a span kept by the span recorder
+type RecordedSpan struct {
+	// ID the id of the span from 1 in order of starting
+	ID int64
+	// ParentID the id of the parent span, 0 for root spans
+	ParentID   int64
+	Name       string
+	Attributes map[string]string
+	Err        error
+	Start      time.Time
+	End        time.Time
+	Ended      bool
+}
+
+// SpanRecorder a tracer keeping spans in memory, for tests
+type SpanRecorder struct {
+	spans []*RecordedSpan
+
+	sync.Mutex
+}
+
+// NewSpanRecorder new a span recorder
+func NewSpanRecorder() *SpanRecorder {
+	return &SpanRecorder{}
+}
+
+type recorderSpanKey struct{}
+
+// Start start a span as a child of the recorded span in ctx
+func (p *SpanRecorder) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p.Lock()
+	defer p.Unlock()
+	s := &RecordedSpan{ID: int64(len(p.spans)) + 1, Name: name, Attributes: make(map[string]string), Start: time.Now()}
+	if parent, ok := ctx.Value(recorderSpanKey{}).(*recorderSpan); ok && parent.recorder == p {
+		s.ParentID = parent.span.ID
+	}
+	for _, a := range attrs {
+		s.Attributes[a.Key] = a.Value
+	}
+	p.spans = append(p.spans, s)
+	span := &recorderSpan{recorder: p, span: s}
+	return context.WithValue(ctx, recorderSpanKey{}, span), span
+}
+
+// Spans get copies of the recorded spans in order of starting
+func (p *SpanRecorder) Spans() []*RecordedSpan {
+	p.Lock()
+	defer p.Unlock()
+	spans := make([]*RecordedSpan, 0, len(p.spans))
+	for _, s := range p.spans {
+		copied := *s
+		copied.Attributes = make(map[string]string, len(s.Attributes))
+		for k, v := range s.Attributes {
+			copied.Attributes[k] = v
+		}
+		spans = append(spans, &copied)
+	}
+	return spans
+}
+
+// Reset remove the recorded spans
+func (p *SpanRecorder) Reset() {
+	p.Lock()
+	defer p.Unlock()
+	p.spans = nil
+}
+
+type recorderSpan struct {
+	recorder *SpanRecorder
+	span     *RecordedSpan
+}
+
+func (p *recorderSpan) SetAttributes(attrs ...Attribute) {
+	p.recorder.Lock()
+	defer p.recorder.Unlock()
+	for _, a := range attrs {
+		p.span.Attributes[a.Key] = a.Value
+	}
+}
+
+func (p *recorderSpan) SetError(err error) {
+	p.recorder.Lock()
+	defer p.recorder.Unlock()
+	p.span.Err = err
+}
+
+func (p *recorderSpan) End() {
+	p.recorder.Lock()
+	defer p.recorder.Unlock()
+	if !p.span.Ended {
+		p.span.End, p.span.Ended = time.Now(), true
+	}
+}
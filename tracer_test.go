@@ -0,0 +1,155 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// newTracedOrder new a machine of the order table, created -pay-> paid for amounts over 10,
+// paid -note-> paid as an internal transition, traced by the recorder with exit and entry functions
+func newTracedOrder(t *testing.T, recorder *SpanRecorder) *Machine[string, string] {
+	t.Helper()
+	table := NewTable[string, string]()
+	for _, tr := range []*Transaction{
+		{Namespace: "order", CurrentStatus: "created", Event: "pay", TargetStatus: "paid", Guard: "payload.amount > 10"},
+		{Namespace: "order", CurrentStatus: "paid", Event: "note", TargetStatus: "paid", Kind: TransitionInternal},
+	} {
+		if err := table.Add(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	noop := func(string, *Transition[string, string]) {}
+	m, err := NewMachine(table, "order", "created", MachineID[string, string]("o1"),
+		MachineTracer[string, string](recorder), MachineOnExit(noop), MachineOnEnter(noop))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// spanSummary the name, parent, attributes and error of a recorded span
+type spanSummary struct {
+	name       string
+	parent     int64
+	attributes map[string]string
+	err        error
+}
+
+// summarize get the summaries of the recorded spans, which must all be ended
+func summarize(t *testing.T, spans []*RecordedSpan) []spanSummary {
+	t.Helper()
+	summaries := make([]spanSummary, 0, len(spans))
+	for _, s := range spans {
+		if !s.Ended || s.End.Before(s.Start) {
+			t.Fatalf("span %d %s is not ended", s.ID, s.Name)
+		}
+		summaries = append(summaries, spanSummary{name: s.Name, parent: s.ParentID, attributes: s.Attributes, err: s.Err})
+	}
+	return summaries
+}
+
+func TestMachineTracerFire(t *testing.T) {
+	recorder := NewSpanRecorder()
+	m := newTracedOrder(t, recorder)
+
+	ctx, parent := recorder.Start(context.Background(), "request")
+	if err := m.FireContext(ctx, "pay", map[string]interface{}{"amount": 20}); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	base := map[string]string{AttributeNamespace: "order", AttributeInstance: "o1", AttributeEvent: "pay",
+		AttributeFrom: "created", AttributeTo: "paid"}
+	with := func(kvs ...string) map[string]string {
+		attrs := make(map[string]string, len(base)+len(kvs)/2)
+		for k, v := range base {
+			attrs[k] = v
+		}
+		for i := 0; i+1 < len(kvs); i += 2 {
+			attrs[kvs[i]] = kvs[i+1]
+		}
+		return attrs
+	}
+	want := []spanSummary{
+		{name: "request", attributes: map[string]string{}},
+		{name: SpanFire, parent: 1, attributes: with()},
+		{name: SpanGuard, parent: 2, attributes: with(AttributeGuard, "payload.amount > 10")},
+		{name: SpanAction, parent: 2, attributes: with(AttributeAction, "exit")},
+		{name: SpanAction, parent: 2, attributes: with(AttributeAction, "enter")},
+	}
+	if got := summarize(t, recorder.Spans()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("spans =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestMachineTracerGuardRejected(t *testing.T) {
+	recorder := NewSpanRecorder()
+	m := newTracedOrder(t, recorder)
+
+	err := m.FireWith("pay", map[string]interface{}{"amount": 5})
+	if !errors.Is(err, ErrGuardRejected) {
+		t.Fatalf("FireWith() = %v, want %v", err, ErrGuardRejected)
+	}
+	spans := summarize(t, recorder.Spans())
+	if len(spans) != 2 || spans[0].name != SpanFire || spans[1].name != SpanGuard || spans[1].parent != 1 {
+		t.Fatalf("spans = %+v, want the fire and guard spans without actions", spans)
+	}
+	for _, s := range spans {
+		if !errors.Is(s.err, ErrGuardRejected) {
+			t.Errorf("span %s error = %v, want %v", s.name, s.err, ErrGuardRejected)
+		}
+	}
+}
+
+func TestMachineTracerInternal(t *testing.T) {
+	recorder := NewSpanRecorder()
+	m := newTracedOrder(t, recorder)
+	if err := m.FireWith("pay", map[string]interface{}{"amount": 20}); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Reset()
+
+	if err := m.Fire("note"); err != nil {
+		t.Fatal(err)
+	}
+	spans := summarize(t, recorder.Spans())
+	if len(spans) != 1 || spans[0].name != SpanFire || spans[0].attributes[AttributeKind] != string(TransitionInternal) ||
+		spans[0].attributes[AttributeTo] != "paid" {
+		t.Fatalf("spans = %+v, want one internal fire span without guard and actions", spans)
+	}
+}
+
+func TestMachineTracerNotFound(t *testing.T) {
+	recorder := NewSpanRecorder()
+	m := newTracedOrder(t, recorder)
+
+	if err := m.Fire("ship"); !errors.Is(err, ErrTransactionNotFound) {
+		t.Fatalf("Fire(ship) = %v, want %v", err, ErrTransactionNotFound)
+	}
+	spans := summarize(t, recorder.Spans())
+	if len(spans) != 1 || !errors.Is(spans[0].err, ErrTransactionNotFound) {
+		t.Fatalf("spans = %+v, want one failed fire span", spans)
+	}
+	if _, ok := spans[0].attributes[AttributeTo]; ok {
+		t.Fatalf("failed fire span has a target: %+v", spans[0].attributes)
+	}
+}
@@ -17,37 +17,204 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 
 package fsm
 
+import (
+	"reflect"
+)
+
 // Transaction information for current to target status in namespace
-type Transaction struct {
+type Transaction = Transition[string, string]
+
+// TransitionKind how a transition leaves and enters statuses
+type TransitionKind string
+
+// transition kinds
+const (
+	// TransitionNormal exit the current status and enter the target status
+	TransitionNormal TransitionKind = ""
+	// TransitionInternal stay in the current status, without exit and entry
+	TransitionInternal TransitionKind = "internal"
+	// TransitionExternal exit and re-enter the current status
+	TransitionExternal TransitionKind = "external"
+)
+
+// Transition information for current to target status in namespace of custom status and event types,
+// empty strings are not valid statuses and events, zero values of other types such as iota enums are
+type Transition[S, E comparable] struct {
 	Namespace     string `json:"namespace"`
-	CurrentStatus string `json:"current"`
-	Event         string `json:"event"`
-	TargetStatus  string `json:"target"`
+	CurrentStatus S      `json:"current"`
+	// Currents a list of current statuses instead of CurrentStatus,
+	// it is expanded into a transition per status when added
+	Currents     []S `json:"currents,omitempty"`
+	Event        E   `json:"event"`
+	TargetStatus S   `json:"target"`
+	// Kind internal and external transitions target the current status, their target can be the zero value
+	Kind TransitionKind `json:"kind,omitempty"`
+	// Output the Mealy output emitted when the transition is fired
+	Output string `json:"output,omitempty"`
+	// Assignments update variables of the extended state when the transition is fired
+	Assignments []*Assignment `json:"assign,omitempty"`
+	// Guard an expression of payload and state which must be true to fire the transition
+	Guard string `json:"guard,omitempty"`
+
+	// guard the compiled Guard, set when the transition is added into a table
+	guard *Expr
+}
+
+// IsSelf judge the transition is internal or external, which stays in the current status
+func (p *Transition[S, E]) IsSelf() bool {
+	return p.Kind == TransitionInternal || p.Kind == TransitionExternal
+}
+
+// clone get a copy of the transition which shares no assignments or current statuses with it
+func (p *Transition[S, E]) clone() *Transition[S, E] {
+	t := *p
+	if p.Currents != nil {
+		t.Currents = append([]S{}, p.Currents...)
+	}
+	if p.Assignments != nil {
+		t.Assignments = make([]*Assignment, len(p.Assignments))
+		for i, a := range p.Assignments {
+			if a != nil {
+				copied := *a
+				t.Assignments[i] = &copied
+			}
+		}
+	}
+	return &t
+}
+
+// expand get copies of the transition per current status, self transitions target their current status
+func (p *Transition[S, E]) expand() []*Transition[S, E] {
+	if len(p.Currents) == 0 {
+		t := p.clone()
+		if t.IsSelf() {
+			t.TargetStatus = t.CurrentStatus
+		}
+		return []*Transition[S, E]{t}
+	}
+	ts := make([]*Transition[S, E], 0, len(p.Currents))
+	for _, current := range p.Currents {
+		t := p.clone()
+		t.CurrentStatus, t.Currents = current, nil
+		if t.IsSelf() {
+			t.TargetStatus = current
+		}
+		ts = append(ts, t)
+	}
+	return ts
 }
 
-func (p *Transaction) valid() error {
+// at get the transition fired in status, a self transition from wildcard targets status
+func (p *Transition[S, E]) at(status S) *Transition[S, E] {
+	if !p.IsSelf() || p.CurrentStatus == status {
+		return p
+	}
+	t := *p
+	t.CurrentStatus, t.TargetStatus = status, status
+	return &t
+}
+
+func (p *Transition[S, E]) valid() error {
 
 	if e := p.validCurrent(); e != nil {
 		return e
 	}
 
-	if p.TargetStatus == "" {
-		return ErrTargetStatusEmpty
+	var zero S
+	switch p.Kind {
+	case TransitionNormal:
+		if isEmpty(p.TargetStatus) {
+			return ErrTargetStatusEmpty
+		}
+	case TransitionInternal, TransitionExternal:
+		if p.TargetStatus != zero &&
+			(len(p.Currents) > 0 || p.TargetStatus != p.CurrentStatus) {
+			return ErrInvalidTransaction
+		}
+	default:
+		return ErrInvalidTransaction
 	}
 
+	for _, a := range p.Assignments {
+		if e := a.valid(); e != nil {
+			return e
+		}
+	}
+	if p.Guard != "" {
+		if _, e := CompileGuard(p.Guard, nil); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// checkVariables check the guard and assignments of the transition by the declared variables of its namespace
+func (p *Transition[S, E]) checkVariables(variables map[string]*Variable) error {
+	if p.Guard != "" {
+		types := make(map[string]VariableType, len(variables))
+		for name, v := range variables {
+			types[name] = v.Type
+		}
+		if _, e := CompileGuard(p.Guard, types); e != nil {
+			return e
+		}
+	}
+	for _, a := range p.Assignments {
+		copied := *a
+		if e := copied.check(variables[a.Variable]); e != nil {
+			return e
+		}
+	}
 	return nil
 }
 
-func (p *Transaction) validCurrent() error {
+func (p *Transition[S, E]) validCurrent() error {
 
 	if p == nil {
 		return ErrInvalidTransaction
 	}
 
 	if p.Namespace == "" ||
-		p.Event == "" ||
-		p.CurrentStatus == "" {
+		isEmpty(p.Event) {
+		return ErrInvalidTransaction
+	}
+
+	if len(p.Currents) == 0 {
+		if isEmpty(p.CurrentStatus) {
+			return ErrInvalidTransaction
+		}
+		return nil
+	}
+	// the current status is unset with Currents
+	var zero S
+	if p.CurrentStatus != zero {
 		return ErrInvalidTransaction
 	}
+	for _, current := range p.Currents {
+		if isEmpty(current) {
+			return ErrInvalidTransaction
+		}
+	}
 	return nil
 }
+
+// StatusOutput the Moore output of a status in namespace
+type StatusOutput[S comparable] struct {
+	Namespace string `json:"namespace"`
+	Status    S      `json:"status"`
+	Output    string `json:"output"`
+}
+
+func (p *StatusOutput[S]) valid() error {
+	if p == nil || p.Namespace == "" || isEmpty(p.Status) || p.Output == "" {
+		return ErrInvalidOutput
+	}
+	return nil
+}
+
+// isEmpty judge a status or event is an empty string,
+// zero values of other kinds are valid statuses and events
+func isEmpty[T comparable](v T) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.String && rv.Len() == 0
+}
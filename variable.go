@@ -0,0 +1,289 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// VariableType the type of an extended state variable
+type VariableType string
+
+// variable types, values are stored as int64, float64, string and bool
+const (
+	VariableInt    VariableType = "int"
+	VariableFloat  VariableType = "float"
+	VariableString VariableType = "string"
+	VariableBool   VariableType = "bool"
+)
+
+// Variable an extended state variable declared in namespace
+type Variable struct {
+	Namespace string       `json:"namespace"`
+	Name      string       `json:"name"`
+	Type      VariableType `json:"type"`
+	// Default the initial value of machines, the zero value of type if nil
+	Default interface{} `json:"default,omitempty"`
+}
+
+func (p *Variable) valid() error {
+	if p == nil || p.Namespace == "" || p.Name == "" {
+		return ErrInvalidVariable
+	}
+	if _, err := ConvertValue(p.Type, p.zero()); err != nil {
+		return err
+	}
+	if p.Default == nil {
+		return nil
+	}
+	value, err := ConvertValue(p.Type, p.Default)
+	if err != nil {
+		return err
+	}
+	p.Default = value
+	return nil
+}
+
+// initial get the default value, or the zero value of type
+func (p *Variable) initial() interface{} {
+	if p.Default != nil {
+		return p.Default
+	}
+	return p.zero()
+}
+
+func (p *Variable) zero() interface{} {
+	switch p.Type {
+	case VariableInt:
+		return int64(0)
+	case VariableFloat:
+		return float64(0)
+	case VariableString:
+		return ""
+	case VariableBool:
+		return false
+	default:
+		return nil
+	}
+}
+
+// ConvertValue convert a value to the type of variables,
+// integral numbers of any type are accepted by int, numbers by float, and strings and json numbers are parsed
+func ConvertValue(typ VariableType, v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok && typ != VariableString {
+		return parseValue(typ, s)
+	}
+	if n, ok := v.(json.Number); ok && (typ == VariableInt || typ == VariableFloat) {
+		return parseValue(typ, n.String())
+	}
+
+	switch typ {
+	case VariableInt:
+		switch n := v.(type) {
+		case int:
+			return int64(n), nil
+		case int32:
+			return int64(n), nil
+		case int64:
+			return n, nil
+		case uint64:
+			if n <= math.MaxInt64 {
+				return int64(n), nil
+			}
+		case float64:
+			if n == math.Trunc(n) && math.Abs(n) < 1<<63 {
+				return int64(n), nil
+			}
+		}
+	case VariableFloat:
+		switch n := v.(type) {
+		case int:
+			return float64(n), nil
+		case int32:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case uint64:
+			return float64(n), nil
+		case float32:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	case VariableString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case VariableBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidVariable, typ)
+	}
+	return nil, fmt.Errorf("%w: %v is not %s", ErrInvalidValue, v, typ)
+}
+
+func parseValue(typ VariableType, s string) (interface{}, error) {
+	var v interface{}
+	var err error
+	switch typ {
+	case VariableInt:
+		v, err = strconv.ParseInt(s, 10, 64)
+	case VariableFloat:
+		v, err = strconv.ParseFloat(s, 64)
+	case VariableBool:
+		v, err = strconv.ParseBool(s)
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidVariable, typ)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q is not %s", ErrInvalidValue, s, typ)
+	}
+	return v, nil
+}
+
+// Data the extended state of an instance by variable name
+type Data map[string]interface{}
+
+// Copy get a copy of data, nil if data is empty
+func (p Data) Copy() Data {
+	if len(p) == 0 {
+		return nil
+	}
+	copied := make(Data, len(p))
+	for name, value := range p {
+		copied[name] = value
+	}
+	return copied
+}
+
+// AssignOp how an assignment updates a variable
+type AssignOp string
+
+// assignment operations
+const (
+	// AssignSet set the variable to the value
+	AssignSet AssignOp = "set"
+	// AssignAdd add the value to an int or float variable
+	AssignAdd AssignOp = "add"
+)
+
+// Assignment update a variable when a transition is fired
+type Assignment struct {
+	Variable string      `json:"variable"`
+	Op       AssignOp    `json:"op"`
+	Value    interface{} `json:"value"`
+}
+
+func (p *Assignment) valid() error {
+	if p == nil || p.Variable == "" || p.Value == nil {
+		return ErrInvalidAssignment
+	}
+	switch p.Op {
+	case AssignSet, AssignAdd:
+		return nil
+	default:
+		return ErrInvalidAssignment
+	}
+}
+
+// check check and convert the value by the declared variable
+func (p *Assignment) check(v *Variable) error {
+	if v == nil {
+		return fmt.Errorf("%w: variable %q is not declared", ErrInvalidAssignment, p.Variable)
+	}
+	if p.Op == AssignAdd && v.Type != VariableInt && v.Type != VariableFloat {
+		return fmt.Errorf("%w: can not add to %s variable %q", ErrInvalidAssignment, v.Type, p.Variable)
+	}
+	value, err := ConvertValue(v.Type, p.Value)
+	if err != nil {
+		return err
+	}
+	p.Value = value
+	return nil
+}
+
+// assign get the data after the assignments, they all read the data before the transition
+func assign(variables map[string]*Variable, data Data, as []*Assignment) (Data, error) {
+	if len(as) == 0 {
+		return data, nil
+	}
+	result := data.Copy()
+	if result == nil {
+		result = make(Data, len(as))
+	}
+	for _, a := range as {
+		v := variables[a.Variable]
+		if v == nil {
+			return nil, fmt.Errorf("%w: variable %q is not declared", ErrInvalidAssignment, a.Variable)
+		}
+		value, err := ConvertValue(v.Type, a.Value)
+		if err != nil {
+			return nil, err
+		}
+		if a.Op == AssignAdd {
+			switch n := value.(type) {
+			case int64:
+				old, _ := data[a.Variable].(int64)
+				value = old + n
+			case float64:
+				old, _ := data[a.Variable].(float64)
+				value = old + n
+			default:
+				return nil, fmt.Errorf("%w: can not add to %s variable %q", ErrInvalidAssignment, v.Type, a.Variable)
+			}
+		}
+		result[a.Variable] = value
+	}
+	return result, nil
+}
+
+// initialData get the initial data of variables with values overridden by data
+func initialData(variables map[string]*Variable, data Data) (Data, error) {
+	result := make(Data, len(variables)+len(data))
+	for name, v := range variables {
+		result[name] = v.initial()
+	}
+	for name, value := range data {
+		v := variables[name]
+		if v == nil {
+			result[name] = value
+			continue
+		}
+		converted, err := ConvertValue(v.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: %w", name, err)
+		}
+		result[name] = converted
+	}
+	return result, nil
+}
+
+func sortVariables(vs []*Variable) {
+	sort.Slice(vs, func(i, j int) bool {
+		if vs[i].Namespace != vs[j].Namespace {
+			return vs[i].Namespace < vs[j].Namespace
+		}
+		return vs[i].Name < vs[j].Name
+	})
+}
@@ -0,0 +1,248 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval default interval of polling the config file
+const DefaultWatchInterval = 3 * time.Second
+
+// ReloadEvent the result of reloading a config file
+type ReloadEvent struct {
+	Filepath string
+	// Namespaces loaded namespaces, empty if failed
+	Namespaces []string
+	// Err nil if reloaded, or the old definition is kept
+	Err  error
+	Time time.Time
+}
+
+// WatcherOption watcher option function
+type WatcherOption func(*Watcher)
+
+// WatcherInterval set the polling interval
+func WatcherInterval(interval time.Duration) WatcherOption {
+	return func(w *Watcher) {
+		w.interval = interval
+	}
+}
+
+// WatcherOnReload set the function called after every reload
+func WatcherOnReload(fn func(ReloadEvent)) WatcherOption {
+	return func(w *Watcher) {
+		w.onReload = fn
+	}
+}
+
+// Watcher reload transactions when the config file changes
+type Watcher struct {
+	filepath string
+	interval time.Duration
+	onReload func(ReloadEvent)
+
+	modTime    time.Time
+	size       int64
+	hash       [sha256.Size]byte
+	namespaces []string
+	missing    bool
+
+	started  bool
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	sync.Mutex
+}
+
+// NewWatcher new a watcher of the config file
+func NewWatcher(filepath string, opts ...WatcherOption) *Watcher {
+	w := &Watcher{
+		filepath: filepath,
+		interval: DefaultWatchInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(w)
+	}
+	if w.interval <= 0 {
+		w.interval = DefaultWatchInterval
+	}
+	return w
+}
+
+// Start load the config file and poll it until stopped
+func (p *Watcher) Start() error {
+	p.Lock()
+	if p.started {
+		p.Unlock()
+		return nil
+	}
+	event, err := p.reload(true)
+	if err == nil {
+		p.started = true
+		go p.run()
+	}
+	p.Unlock()
+	p.reloaded(event)
+	return err
+}
+
+// Stop stop polling
+func (p *Watcher) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+
+	p.Lock()
+	started := p.started
+	p.Unlock()
+	if started {
+		<-p.done
+	}
+}
+
+func (p *Watcher) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			_ = p.check()
+		}
+	}
+}
+
+// Reload reload the config file even if it does not change
+func (p *Watcher) Reload() error {
+	p.Lock()
+	event, err := p.reload(true)
+	p.Unlock()
+	p.reloaded(event)
+	return err
+}
+
+func (p *Watcher) check() error {
+	p.Lock()
+	event, err := p.reload(false)
+	p.Unlock()
+	p.reloaded(event)
+	return err
+}
+
+// reload reload the config file under the lock,
+// the event is nil if the file is not reloaded
+func (p *Watcher) reload(force bool) (*ReloadEvent, error) {
+	info, err := os.Stat(p.filepath)
+	if err != nil {
+		if !force && p.missing {
+			return nil, err
+		}
+		p.missing = true
+		return p.notify(nil, err)
+	}
+	p.missing = false
+	if !force && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(p.filepath)
+	if err != nil {
+		return p.notify(nil, err)
+	}
+	hash := sha256.Sum256(data)
+	if !force && hash == p.hash {
+		p.modTime, p.size = info.ModTime(), info.Size()
+		return nil, nil
+	}
+
+	// the file is not read again until it changes, even if it is invalid
+	p.modTime, p.size, p.hash = info.ModTime(), info.Size(), hash
+
+	format, err := FormatOf(p.filepath)
+	if err != nil {
+		return p.notify(nil, err)
+	}
+	def, err := parseDefinition(format, data)
+	if err != nil {
+		return p.notify(nil, err)
+	}
+
+	namespaces := def.namespaces()
+	if err = Default().ReplaceDefinition(append(namespaces, p.namespaces...), def); err != nil {
+		return p.notify(nil, err)
+	}
+	p.namespaces = namespaces
+	return p.notify(namespaces, nil)
+}
+
+// notify log the result of reloading and get its event
+func (p *Watcher) notify(namespaces []string, err error) (*ReloadEvent, error) {
+	if err != nil {
+		getLogger().Error("fsm: reload failed, the old definition is kept", "file", p.filepath, "error", err)
+	} else {
+		getLogger().Info("fsm: definition reloaded", "file", p.filepath, "namespaces", namespaces)
+	}
+	return &ReloadEvent{
+		Filepath:   p.filepath,
+		Namespaces: namespaces,
+		Err:        err,
+		Time:       time.Now(),
+	}, err
+}
+
+// reloaded call onReload without the lock, so it may call Reload
+func (p *Watcher) reloaded(event *ReloadEvent) {
+	if event != nil && p.onReload != nil {
+		p.onReload(*event)
+	}
+}
+
+// namespaces get the namespaces of the definition's transactions, outputs and variables in order
+func (p *Definition) namespaces() []string {
+	set := make(map[string]bool)
+	var namespaces []string
+	add := func(namespace string) {
+		if !set[namespace] {
+			set[namespace] = true
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	for _, t := range p.Transactions {
+		add(t.Namespace)
+	}
+	for _, o := range p.Outputs {
+		add(o.Namespace)
+	}
+	for _, v := range p.Variables {
+		add(v.Namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
@@ -0,0 +1,226 @@
+/*
+Copyright © 2016 Henry Huang <hhh@rutcode.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package fsm
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reloadRecorder record reload events of a watcher
+type reloadRecorder struct {
+	events []ReloadEvent
+
+	sync.Mutex
+}
+
+func (p *reloadRecorder) record(e ReloadEvent) {
+	p.Lock()
+	defer p.Unlock()
+	p.events = append(p.events, e)
+}
+
+// take get the events recorded since the last take
+func (p *reloadRecorder) take() []ReloadEvent {
+	p.Lock()
+	defer p.Unlock()
+	events := p.events
+	p.events = nil
+	return events
+}
+
+// writeWatched write the content into the file with the modification time
+func writeWatched(t *testing.T, file, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const (
+	watchedOrder = "fsm:\n  order:\n    pay:\n      current: created\n      event: pay\n      target: paid\n"
+	watchedShip  = "fsm:\n  order:\n    pay:\n      current: created\n      event: pay\n      target: sent\n"
+	watchedBoth  = watchedOrder + "  refund:\n    back:\n      current: paid\n      event: back\n      target: refunded\n"
+)
+
+func TestWatcherReload(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+
+	file := filepath.Join(t.TempDir(), "fsm.yaml")
+	now := time.Now().Truncate(time.Second)
+	writeWatched(t, file, watchedBoth, now)
+	recorder := &reloadRecorder{}
+	w := NewWatcher(file, WatcherOnReload(recorder.record))
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if events := recorder.take(); len(events) != 1 || events[0].Err != nil ||
+		len(events[0].Namespaces) != 2 || events[0].Filepath != file {
+		t.Fatalf("events of the first load = %+v", events)
+	}
+
+	target := func(namespace, status, event string) string {
+		if tr := repo.GetTargetTranstion(namespace, status, event); tr != nil {
+			return tr.TargetStatus
+		}
+		return ""
+	}
+	tests := []struct {
+		name    string
+		change  func()
+		events  int
+		failed  bool
+		pay     string
+		refunds bool
+	}{
+		{name: "unchanged", change: func() {}, pay: "paid", refunds: true},
+		{
+			name:    "touched with the same content",
+			change:  func() { writeWatched(t, file, watchedBoth, now.Add(time.Second)) },
+			pay:     "paid",
+			refunds: true,
+		},
+		{
+			name:   "changed",
+			change: func() { writeWatched(t, file, watchedShip, now.Add(2*time.Second)) },
+			events: 1,
+			pay:    "sent",
+		},
+		{
+			name:   "invalid",
+			change: func() { writeWatched(t, file, "fsm: [", now.Add(3*time.Second)) },
+			events: 1,
+			failed: true,
+			pay:    "sent",
+		},
+		{name: "invalid and unchanged", change: func() {}, pay: "sent"},
+		{
+			name: "removed",
+			change: func() {
+				if err := os.Remove(file); err != nil {
+					t.Fatal(err)
+				}
+			},
+			events: 1,
+			failed: true,
+			pay:    "sent",
+		},
+		{name: "still removed", change: func() {}, pay: "sent"},
+		{
+			name:    "restored",
+			change:  func() { writeWatched(t, file, watchedBoth, now.Add(4*time.Second)) },
+			events:  1,
+			pay:     "paid",
+			refunds: true,
+		},
+		{
+			name:   "namespace dropped",
+			change: func() { writeWatched(t, file, watchedOrder, now.Add(5*time.Second)) },
+			events: 1,
+			pay:    "paid",
+		},
+		{
+			// paid and sent have the same length, only the time and hash change
+			name:   "changed with the same size",
+			change: func() { writeWatched(t, file, watchedShip, now.Add(6*time.Second)) },
+			events: 1,
+			pay:    "sent",
+		},
+	}
+	for _, test := range tests {
+		test.change()
+		err := w.check()
+		events := recorder.take()
+		if len(events) != test.events {
+			t.Fatalf("%s: %d events, want %d: %+v", test.name, len(events), test.events, events)
+		}
+		if len(events) == 1 && (events[0].Err != nil) != test.failed {
+			t.Fatalf("%s: event error = %v, want failed %t", test.name, events[0].Err, test.failed)
+		}
+		if len(events) == 1 && events[0].Err != err {
+			t.Fatalf("%s: check() = %v, event error %v", test.name, err, events[0].Err)
+		}
+		if got := target("order", "created", "pay"); got != test.pay {
+			t.Fatalf("%s: pay goes to %q, want %q", test.name, got, test.pay)
+		}
+		if refunds := target("refund", "paid", "back") != ""; refunds != test.refunds {
+			t.Fatalf("%s: refund namespace loaded = %t, want %t", test.name, refunds, test.refunds)
+		}
+	}
+}
+
+func TestWatcherStartStop(t *testing.T) {
+	repo := Default()
+	repo.Remove()
+	defer repo.Remove()
+
+	file := filepath.Join(t.TempDir(), "fsm.yaml")
+	now := time.Now().Truncate(time.Second)
+	writeWatched(t, file, watchedOrder, now)
+	reloaded := make(chan ReloadEvent, 10)
+	w := NewWatcher(file, WatcherInterval(10*time.Millisecond), WatcherOnReload(func(e ReloadEvent) { reloaded <- e }))
+
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	if e := <-reloaded; e.Err != nil {
+		t.Fatalf("first load failed: %v", e.Err)
+	}
+
+	writeWatched(t, file, watchedShip, now.Add(time.Second))
+	select {
+	case e := <-reloaded:
+		if e.Err != nil {
+			t.Fatalf("reload failed: %v", e.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change is not reloaded")
+	}
+	if tr := repo.GetTargetTranstion("order", "created", "pay"); tr == nil || tr.TargetStatus != "sent" {
+		t.Fatalf("pay goes to %+v, want sent", tr)
+	}
+
+	w.Stop()
+	writeWatched(t, file, watchedOrder, now.Add(2*time.Second))
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case e := <-reloaded:
+		t.Fatalf("reloaded after Stop: %+v", e)
+	default:
+	}
+}
+
+func TestWatcherStartInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fsm.yaml")
+	writeWatched(t, file, "fsm: [", time.Now())
+	w := NewWatcher(file)
+	if err := w.Start(); err == nil {
+		t.Fatal("Start() of an invalid file = nil, want an error")
+	}
+	// a watcher which did not start stops at once
+	w.Stop()
+}